@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/legacy/legacytest"
+)
+
+// mock-legacy serves the fake legacy labor-cost API from legacytest, so the
+// server has something to report to in local runs and integration tests.
+// Faults are scripted and accepted reports inspected through the /mock
+// endpoints.
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	flag.Parse()
+
+	mock := legacytest.NewServer()
+	server := &http.Server{
+		Addr: *addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("%s %s", r.Method, r.URL.Path)
+			mock.ServeHTTP(w, r)
+		}),
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start mock legacy API: %v", err)
+		}
+	}()
+	log.Printf("Mock legacy API listening on %s (reports at %s, bulk at %s)", *addr, legacytest.ReportPath, legacytest.BulkPath)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Mock legacy API shutdown: %v", err)
+	}
+	log.Println("Mock legacy API stopped")
+}
@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/omaaartamer/factory-checkin-api/internal/email"
+	"github.com/omaaartamer/factory-checkin-api/internal/export"
 	"github.com/omaaartamer/factory-checkin-api/internal/handler"
+	"github.com/omaaartamer/factory-checkin-api/internal/legacy"
+	"github.com/omaaartamer/factory-checkin-api/internal/migrate"
+	"github.com/omaaartamer/factory-checkin-api/internal/outbox"
 	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/reconciliation"
 	"github.com/omaaartamer/factory-checkin-api/internal/repository"
 	"github.com/omaaartamer/factory-checkin-api/internal/service"
 	"github.com/omaaartamer/factory-checkin-api/internal/worker"
@@ -15,41 +26,69 @@ import (
 )
 
 func main() {
+	demo := flag.Bool("demo", false, "run with in-memory storage and queue, without PostgreSQL or RabbitMQ")
+	flag.Parse()
+
 	cfg := config.Load()
 	log.Printf("Starting Factory Check-in API on port %s", cfg.Port)
 
-	// Initialize repository
-	repo, err := repository.NewRepository(cfg.DatabaseURL)
-	if err != nil {
-		log.Fatalf("Failed to initialize repository: %v", err)
+	var repo repository.Repository
+	var q queue.Queue
+	if *demo {
+		// Nothing survives a restart in demo mode
+		log.Println("Demo mode: using in-memory storage and queue")
+		repo = repository.NewMemoryRepository()
+		q = queue.NewMemoryQueue()
+	} else {
+		repo, q = connect(cfg)
 	}
-	defer repo.Close()
 
-	// Initialize queue
-	q, err := queue.NewRabbitMQQueue(cfg.RabbitMQURL)
+	// Initialize service
+	checkinService := service.NewCheckinService(repo, q, cfg)
+	deadLetterService := service.NewDeadLetterService(repo, q, cfg)
+	reconciliationService := service.NewReconciliationService(repo, cfg)
+
+	// Checked before anything starts, as the export layout may be invalid
+	exporter, err := export.NewExporter(repo, cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize RabbitMQ queue: %v", err)
+		log.Fatalf("Failed to initialize payroll export: %v", err)
 	}
-	defer q.Close()
 
-	// Initialize service
-	checkinService := service.NewCheckinService(repo, q)
-
-	// Initialize background worker
-	bgWorker := worker.NewWorker(q, cfg)
+	// Initialize background worker with the handlers for each message type
+	handlers := worker.NewRegistry()
+	if err := legacy.RegisterHandlers(handlers, repo, cfg); err != nil {
+		log.Fatalf("Failed to initialize legacy API client: %v", err)
+	}
+	if err := email.RegisterHandlers(handlers, repo, cfg); err != nil {
+		log.Fatalf("Failed to initialize email delivery: %v", err)
+	}
+	bgWorker := worker.NewWorker(q, repo, handlers, cfg)
 	bgWorker.Start()
-	defer bgWorker.Stop()
+
+	// Initialize outbox relay
+	relay := outbox.NewRelay(repo, q, cfg)
+	relay.Start()
+
+	// Initialize labor cost report reconciliation
+	reconciler := reconciliation.NewJob(reconciliationService, cfg)
+	reconciler.Start()
+
+	// Start flat-file payroll export
+	exporter.Start()
 
 	// Initialize HTTP handler
-	h := handler.NewHandler(checkinService)
+	h := handler.NewHandler(checkinService, deadLetterService, reconciliationService)
 	router := h.SetupRoutes()
 
-	log.Println("Database Connected!")
-	log.Println("RabbitMQ Queue Connected!")
 	log.Println("Business logic ready!")
 
+	server := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: router,
+	}
+
 	go func() {
-		if err := router.Run(":" + cfg.Port); err != nil {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -59,4 +98,66 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	// Stop in dependency order: no new check-ins or re-enqueued reports,
+	// then publish what they wrote to the outbox, then let the worker finish
+	// or hand back its messages before the connections they use are closed
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	reconciler.Stop()
+	exporter.Stop()
+	relay.Stop()
+	if err := bgWorker.Shutdown(ctx); err != nil {
+		log.Printf("Worker shutdown: %v", err)
+	}
+	if err := q.Close(); err != nil {
+		log.Printf("Failed to close queue: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		log.Printf("Failed to close repository: %v", err)
+	}
+	log.Println("Server stopped")
+}
+
+// connect opens the database, applies pending migrations and opens the queue
+// backend selected by QUEUE_BACKEND.
+func connect(cfg *config.Config) (repository.Repository, queue.Queue) {
+	// Initialize repository
+	repo, err := repository.NewPostgresRepository(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize repository: %v", err)
+	}
+	log.Println("Database Connected!")
+
+	// Apply pending schema migrations
+	if cfg.AutoMigrate {
+		migrator, err := migrate.NewMigrator(repo.DB().DB)
+		if err != nil {
+			log.Fatalf("Failed to load migrations: %v", err)
+		}
+		if _, err := migrator.Up(); err != nil {
+			log.Fatalf("Failed to apply migrations: %v", err)
+		}
+	}
+
+	// Initialize queue
+	var q queue.Queue
+	switch cfg.QueueBackend {
+	case "rabbitmq":
+		q, err = queue.NewRabbitMQQueue(cfg.RabbitMQURL, time.Duration(cfg.PublishConfirmTimeoutMs)*time.Millisecond)
+		if err != nil {
+			log.Fatalf("Failed to initialize RabbitMQ queue: %v", err)
+		}
+	case "postgres":
+		q = queue.NewPostgresQueue(repo.DB(), time.Duration(cfg.QueueVisibilityTimeoutSeconds)*time.Second)
+	default:
+		log.Fatalf("Unknown QUEUE_BACKEND %q (expected rabbitmq or postgres)", cfg.QueueBackend)
+	}
+	log.Printf("Queue ready (%s backend)", cfg.QueueBackend)
+
+	return repo, q
 }
@@ -1,26 +1,146 @@
 package email
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net/mail"
+	"time"
 
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/payload"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
 	"github.com/omaaartamer/factory-checkin-api/pkg/config"
 )
 
+// Sender delivers one email.
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
 type EmailService struct {
-	config *config.Config
+	config    *config.Config
+	repo      repository.Repository
+	sender    Sender
+	templates *Templates
+	from      *mail.Address
 }
 
-func NewEmailService(cfg *config.Config) *EmailService {
-	return &EmailService{config: cfg}
+func NewEmailService(repo repository.Repository, cfg *config.Config) (*EmailService, error) {
+	from, err := mail.ParseAddress(cfg.SMTPFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_FROM %q: %w", cfg.SMTPFrom, err)
+	}
+
+	var sender Sender
+	switch cfg.EmailDelivery {
+	case "smtp":
+		sender, err = newSMTPSender(cfg)
+		if err != nil {
+			return nil, err
+		}
+	case "log":
+		sender = logSender{}
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_DELIVERY %q (expected smtp or log)", cfg.EmailDelivery)
+	}
+
+	templates, err := LoadTemplates(cfg.EmailTemplateDir, cfg.EmailDefaultLocale)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailService{config: cfg, repo: repo, sender: sender, templates: templates, from: from}, nil
 }
 
-func (e *EmailService) SendWorkedHoursEmail(employeeID string, hoursWorked float64, date string) error {
-	// Mock email sending (in real system, use SMTP)
-	log.Printf("   MOCK EMAIL SENT:")
-	log.Printf("   To: %s@company.com", employeeID)
-	log.Printf("   Subject: Your work hours for %s", date)
-	log.Printf("   Body: You worked %.2f hours today. Great job!", hoursWorked)
-	log.Printf("   SMTP Config: %s:%d", e.config.SMTPHost, e.config.SMTPPort)
+func (e *EmailService) SendWorkedHoursEmail(ctx context.Context, notification *model.EmailNotification) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("email cancelled: %w", err)
+	}
+
+	data, err := e.workedHours(ctx, notification)
+	if err != nil {
+		return err
+	}
+
+	subject, text, html, err := e.templates.Render(data.Locale, data)
+	if err != nil {
+		return configError{fmt.Errorf("failed to render email for %s: %w", notification.EmployeeID, err)}
+	}
+
+	msg := &Message{
+		From:    e.from,
+		To:      &mail.Address{Address: notification.EmployeeID + "@" + e.config.EmailDomain},
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	}
+
+	if err := e.sender.Send(ctx, msg); err != nil {
+		return err
+	}
+	log.Printf("Sent work hours email to %s for %s", msg.To.Address, notification.Date)
+	return nil
+}
 
+// workedHours gathers the template data for a notification: the employee's
+// locale and the hours worked so far in the week of the notification's date.
+func (e *EmailService) workedHours(ctx context.Context, notification *model.EmailNotification) (*WorkedHours, error) {
+	date, err := time.ParseInLocation("2006-01-02", notification.Date, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid date %q", payload.ErrInvalid, notification.Date)
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, e.config.Timeouts.Database)
+	defer cancel()
+
+	locale := e.config.EmailDefaultLocale
+	prefs, err := e.repo.GetEmployeePreferences(dbCtx, notification.EmployeeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preferences for %s: %w", notification.EmployeeID, err)
+	}
+	if prefs != nil && prefs.Locale != "" {
+		locale = prefs.Locale
+	}
+
+	// Weeks start on Monday
+	weekStart := date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+	weekHours, err := e.repo.SumHoursWorked(dbCtx, notification.EmployeeID, weekStart, date.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum weekly hours for %s: %w", notification.EmployeeID, err)
+	}
+
+	return &WorkedHours{
+		EmployeeID:   notification.EmployeeID,
+		Locale:       locale,
+		Date:         date,
+		Worked:       hoursDuration(notification.HoursWorked),
+		CheckinTime:  localTime(notification.CheckinTime),
+		CheckoutTime: localTime(notification.CheckoutTime),
+		WeekStart:    weekStart,
+		WeekWorked:   hoursDuration(weekHours),
+	}, nil
+}
+
+func hoursDuration(hours float64) time.Duration {
+	return time.Duration(hours * float64(time.Hour)).Round(time.Minute)
+}
+
+func localTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := t.Local()
+	return &local
+}
+
+// logSender only logs emails, for development and demo runs.
+type logSender struct{}
+
+func (logSender) Send(ctx context.Context, msg *Message) error {
+	log.Printf("   MOCK EMAIL SENT:")
+	log.Printf("   To: %s", msg.To)
+	log.Printf("   Subject: %s", msg.Subject)
+	log.Printf("   Body: %s", msg.Text)
 	return nil
 }
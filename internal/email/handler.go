@@ -0,0 +1,40 @@
+package email
+
+import (
+	"context"
+	"errors"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/payload"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/internal/worker"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// RegisterHandlers registers the worker handler that emails employees their
+// worked hours.
+func RegisterHandlers(reg *worker.Registry, repo repository.Repository, cfg *config.Config) error {
+	svc, err := NewEmailService(repo, cfg)
+	if err != nil {
+		return err
+	}
+
+	reg.Register(worker.Registration{
+		Type:    payload.TypeEmailNotification,
+		Handler: svc.handleEmailNotification,
+		Timeout: cfg.Timeouts.Email,
+	})
+	return nil
+}
+
+func (e *EmailService) handleEmailNotification(ctx context.Context, msg *model.QueueMessage) error {
+	notification, err := payload.Decode[model.EmailNotification](msg)
+	if err != nil {
+		return worker.Permanent(err)
+	}
+	err = e.SendWorkedHoursEmail(ctx, &notification)
+	if errors.Is(err, payload.ErrInvalid) {
+		return worker.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// SMTPError is a reply from the SMTP server refusing a command.
+type SMTPError struct {
+	Command string
+	Code    int
+	Msg     string
+}
+
+func (e *SMTPError) Error() string {
+	return fmt.Sprintf("SMTP server refused %s: %d %s", e.Command, e.Code, e.Msg)
+}
+
+// Permanent reports whether the server refused for good (5xx), e.g. an
+// unknown mailbox or bad credentials. Transient replies (4xx) such as a full
+// mailbox or greylisting are worth retrying.
+func (e *SMTPError) Permanent() bool {
+	return e.Code >= 500
+}
+
+// configError is a failure caused by our own settings, such as an SMTP server
+// without STARTTLS or a template that fails to render. Retrying doesn't help
+// until the settings change.
+type configError struct {
+	err error
+}
+
+func (e configError) Error() string   { return e.err.Error() }
+func (e configError) Unwrap() error   { return e.err }
+func (e configError) Permanent() bool { return true }
+
+// smtpSender delivers messages to an SMTP relay, opening one connection per
+// message.
+type smtpSender struct {
+	addr      string
+	host      string
+	tlsMode   string
+	tlsConfig *tls.Config
+	auth      smtp.Auth
+	helo      string
+}
+
+func newSMTPSender(cfg *config.Config) (*smtpSender, error) {
+	s := &smtpSender{
+		addr:    net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		host:    cfg.SMTPHost,
+		tlsMode: cfg.SMTPTLS,
+		helo:    "localhost",
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		s.helo = hostname
+	}
+
+	switch cfg.SMTPTLS {
+	case "starttls", "implicit":
+		s.tlsConfig = &tls.Config{ServerName: cfg.SMTPHost, MinVersion: tls.VersionTLS12}
+		if cfg.SMTPCAFile != "" {
+			pem, err := os.ReadFile(cfg.SMTPCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read SMTP CA file: %w", err)
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in SMTP CA file %s", cfg.SMTPCAFile)
+			}
+			s.tlsConfig.RootCAs = roots
+		}
+	case "none":
+	default:
+		return nil, fmt.Errorf("unknown SMTP_TLS %q (expected starttls, implicit or none)", cfg.SMTPTLS)
+	}
+
+	if cfg.SMTPUsername != "" {
+		if s.tlsConfig == nil && !isLocalhost(cfg.SMTPHost) {
+			return nil, errors.New("SMTP credentials would be sent unencrypted; set SMTP_TLS to starttls or implicit")
+		}
+		switch cfg.SMTPAuth {
+		case "plain":
+			s.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+		case "login":
+			s.auth = &loginAuth{username: cfg.SMTPUsername, password: cfg.SMTPPassword, host: cfg.SMTPHost}
+		default:
+			return nil, fmt.Errorf("unknown SMTP_AUTH %q (expected plain or login)", cfg.SMTPAuth)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *smtpSender) Send(ctx context.Context, msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server %s: %w", s.addr, err)
+	}
+	defer conn.Close()
+
+	// net/smtp knows nothing of contexts: abort the conversation by closing
+	// the connection
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err = s.deliver(conn, msg, body)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return fmt.Errorf("email cancelled: %w", ctxErr)
+	}
+	return err
+}
+
+func (s *smtpSender) dial(ctx context.Context) (net.Conn, error) {
+	if s.tlsMode == "implicit" {
+		dialer := &tls.Dialer{Config: s.tlsConfig}
+		return dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", s.addr)
+}
+
+func (s *smtpSender) deliver(conn net.Conn, msg *Message, body []byte) error {
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return reply("greeting", err)
+	}
+	defer c.Close()
+
+	if err := c.Hello(s.helo); err != nil {
+		return reply("EHLO", err)
+	}
+
+	if s.tlsMode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return configError{fmt.Errorf("SMTP server %s does not support STARTTLS", s.addr)}
+		}
+		if err := c.StartTLS(s.tlsConfig); err != nil {
+			return reply("STARTTLS", err)
+		}
+	}
+
+	if s.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return configError{fmt.Errorf("SMTP server %s does not support authentication", s.addr)}
+		}
+		if err := c.Auth(s.auth); err != nil {
+			return reply("AUTH", err)
+		}
+	}
+
+	if err := c.Mail(msg.From.Address); err != nil {
+		return reply("MAIL", err)
+	}
+	if err := c.Rcpt(msg.To.Address); err != nil {
+		return reply("RCPT", err)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return reply("DATA", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return reply("DATA", err)
+	}
+	if err := w.Close(); err != nil {
+		return reply("DATA", err)
+	}
+
+	// The message is accepted; a failed QUIT doesn't change that
+	c.Quit()
+	return nil
+}
+
+// reply turns a refusal from the server into an SMTPError. Other failures,
+// such as a dropped connection, are returned as they are and retried.
+func reply(command string, err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return &SMTPError{Command: command, Code: protoErr.Code, Msg: protoErr.Msg}
+	}
+	return fmt.Errorf("SMTP %s failed: %w", command, err)
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp lacks but many
+// older relays still require. Like smtp.PlainAuth it refuses to send
+// credentials over an unencrypted connection except to localhost.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:", "User Name", "Username":
+		return []byte(a.username), nil
+	case "Password:", "Password":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,158 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/email/smtptest"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+func startSMTPServer(t *testing.T, opts smtptest.Options) *smtptest.Server {
+	t.Helper()
+	server, err := smtptest.NewServer(opts)
+	if err != nil {
+		t.Fatalf("smtptest.NewServer: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// newTestSender returns a sender for server that trusts its certificate.
+func newTestSender(t *testing.T, server *smtptest.Server, tlsMode, auth, username, password string) *smtpSender {
+	t.Helper()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, server.CertPEM(), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	sender, err := newSMTPSender(&config.Config{
+		SMTPHost:     server.Host(),
+		SMTPPort:     server.Port(),
+		SMTPTLS:      tlsMode,
+		SMTPAuth:     auth,
+		SMTPUsername: username,
+		SMTPPassword: password,
+		SMTPCAFile:   caFile,
+	})
+	if err != nil {
+		t.Fatalf("newSMTPSender: %v", err)
+	}
+	return sender
+}
+
+func testMessage() *Message {
+	return &Message{
+		From:    &mail.Address{Name: "Factory", Address: "noreply@factory.local"},
+		To:      &mail.Address{Address: "EMP001@factory.local"},
+		Subject: "Your worked hours",
+		Text:    "You worked 8 hours.",
+		HTML:    "<p>You worked 8 hours.</p>",
+	}
+}
+
+func TestSMTPDelivery(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     smtptest.Options
+		tlsMode  string
+		auth     string
+		wantAuth bool
+	}{
+		{"starttls with plain auth", smtptest.Options{StartTLS: true, Username: "mailer", Password: "s3cret"}, "starttls", "plain", true},
+		{"starttls with login auth", smtptest.Options{StartTLS: true, Username: "mailer", Password: "s3cret"}, "starttls", "login", true},
+		{"implicit tls with plain auth", smtptest.Options{ImplicitTLS: true, Username: "mailer", Password: "s3cret"}, "implicit", "plain", true},
+		{"implicit tls with login auth", smtptest.Options{ImplicitTLS: true, Username: "mailer", Password: "s3cret"}, "implicit", "login", true},
+		{"starttls without auth", smtptest.Options{StartTLS: true}, "starttls", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startSMTPServer(t, tt.opts)
+			sender := newTestSender(t, server, tt.tlsMode, tt.auth, tt.opts.Username, tt.opts.Password)
+
+			if err := sender.Send(context.Background(), testMessage()); err != nil {
+				t.Fatalf("Send() = %v", err)
+			}
+
+			messages := server.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("server accepted %d messages, want 1", len(messages))
+			}
+			got := messages[0]
+			if !got.TLS || got.Auth != tt.wantAuth {
+				t.Errorf("message sent with TLS %v and auth %v, want TLS and auth %v", got.TLS, got.Auth, tt.wantAuth)
+			}
+			if got.From != "noreply@factory.local" || len(got.To) != 1 || got.To[0] != "EMP001@factory.local" {
+				t.Errorf("envelope from %s to %v", got.From, got.To)
+			}
+			if !strings.Contains(string(got.Data), "Subject: Your worked hours") {
+				t.Errorf("message data missing its subject:\n%s", got.Data)
+			}
+		})
+	}
+}
+
+func TestSMTPWrongPassword(t *testing.T) {
+	server := startSMTPServer(t, smtptest.Options{StartTLS: true, Username: "mailer", Password: "s3cret"})
+	sender := newTestSender(t, server, "starttls", "login", "mailer", "wrong")
+
+	err := sender.Send(context.Background(), testMessage())
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Command != "AUTH" || !smtpErr.Permanent() {
+		t.Fatalf("Send() = %v, want a permanent AUTH refusal", err)
+	}
+}
+
+func TestSMTPReplyClassification(t *testing.T) {
+	tests := []struct {
+		command   string
+		code      int
+		text      string
+		permanent bool
+	}{
+		{"RCPT", 550, "No such mailbox", true},
+		{"MAIL", 553, "Sender address rejected", true},
+		{"DATA", 554, "Transaction failed", true},
+		{"RCPT", 450, "Mailbox busy", false},
+		{"RCPT", 451, "Greylisted, try again later", false},
+		{"DATA", 452, "Insufficient storage", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command+" "+tt.text, func(t *testing.T) {
+			server := startSMTPServer(t, smtptest.Options{StartTLS: true})
+			sender := newTestSender(t, server, "starttls", "", "", "")
+			server.Reply(tt.command, tt.code, tt.text)
+
+			err := sender.Send(context.Background(), testMessage())
+			var smtpErr *SMTPError
+			if !errors.As(err, &smtpErr) {
+				t.Fatalf("Send() = %v, want an *SMTPError", err)
+			}
+			if smtpErr.Command != tt.command || smtpErr.Code != tt.code {
+				t.Errorf("Send() = %v, want %s refused with %d", err, tt.command, tt.code)
+			}
+			if smtpErr.Permanent() != tt.permanent {
+				t.Errorf("Permanent() = %v, want %v", smtpErr.Permanent(), tt.permanent)
+			}
+			if len(server.Messages()) != 0 {
+				t.Error("the refused message was accepted")
+			}
+		})
+	}
+}
+
+func TestSMTPStartTLSMissing(t *testing.T) {
+	server := startSMTPServer(t, smtptest.Options{})
+	sender := newTestSender(t, server, "starttls", "", "", "")
+
+	err := sender.Send(context.Background(), testMessage())
+	var permanent interface{ Permanent() bool }
+	if !errors.As(err, &permanent) || !permanent.Permanent() {
+		t.Fatalf("Send() = %v, want a permanent configuration error", err)
+	}
+}
@@ -0,0 +1,395 @@
+// Package smtptest is an in-process SMTP server for testing email delivery.
+// It records the messages it accepts, can offer STARTTLS or implicit TLS
+// with a self-signed certificate and require PLAIN or LOGIN authentication,
+// and can be told to refuse the next use of a command with any reply code.
+package smtptest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures a Server. The zero value is a plain SMTP server
+// without authentication.
+type Options struct {
+	// StartTLS offers the STARTTLS extension; ImplicitTLS expects TLS from
+	// the first byte instead
+	StartTLS    bool
+	ImplicitTLS bool
+	// Username and Password, when set, are required before MAIL, through
+	// AUTH PLAIN or AUTH LOGIN
+	Username string
+	Password string
+}
+
+// Message is an accepted email.
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+	// TLS and Auth record whether the session was encrypted and
+	// authenticated when the message was sent
+	TLS  bool
+	Auth bool
+}
+
+type reply struct {
+	code int
+	text string
+}
+
+// Server is a running fake SMTP server listening on a loopback port.
+type Server struct {
+	opts      Options
+	listener  net.Listener
+	tlsConfig *tls.Config
+	certPEM   []byte
+
+	mu       sync.Mutex
+	messages []Message
+	replies  map[string][]reply // scripted replies by command
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+}
+
+func NewServer(opts Options) (*Server, error) {
+	cert, certPEM, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		opts:      opts,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		certPEM:   certPEM,
+		replies:   make(map[string][]reply),
+		conns:     make(map[net.Conn]bool),
+	}
+
+	if opts.ImplicitTLS {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Host is the address the server listens on, which its certificate is
+// valid for.
+func (s *Server) Host() string {
+	return "127.0.0.1"
+}
+
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// CertPEM returns the server's self-signed certificate, for clients to trust
+// as a CA.
+func (s *Server) CertPEM() []byte {
+	return s.certPEM
+}
+
+// Messages returns the messages accepted so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Reply makes the server answer the next use of command (e.g. "RCPT" or
+// "DATA") with code and text instead of handling it. Replies to the same
+// command are used in the order they were added.
+func (s *Server) Reply(command string, code int, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	command = strings.ToUpper(command)
+	s.replies[command] = append(s.replies[command], reply{code: code, text: text})
+}
+
+// Close stops the server and drops open connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// session is the state of one SMTP conversation.
+type session struct {
+	conn net.Conn
+	text *textproto.Conn
+	tls  bool
+	auth bool
+	from string
+	to   []string
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	sess := &session{conn: conn, text: textproto.NewConn(conn), tls: s.opts.ImplicitTLS}
+	sess.text.PrintfLine("220 smtptest ready")
+
+	for {
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, arg, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+
+		if r, ok := s.scripted(command); ok {
+			sess.text.PrintfLine("%d %s", r.code, r.text)
+			continue
+		}
+
+		switch command {
+		case "EHLO":
+			s.ehlo(sess)
+		case "HELO", "NOOP":
+			sess.text.PrintfLine("250 OK")
+		case "STARTTLS":
+			if !s.startTLS(sess) {
+				return
+			}
+		case "AUTH":
+			s.authenticate(sess, arg)
+		case "MAIL":
+			if s.opts.Username != "" && !sess.auth {
+				sess.text.PrintfLine("530 Authentication required")
+				continue
+			}
+			sess.from = address(arg)
+			sess.to = nil
+			sess.text.PrintfLine("250 OK")
+		case "RCPT":
+			if sess.from == "" {
+				sess.text.PrintfLine("503 MAIL first")
+				continue
+			}
+			sess.to = append(sess.to, address(arg))
+			sess.text.PrintfLine("250 OK")
+		case "DATA":
+			if len(sess.to) == 0 {
+				sess.text.PrintfLine("503 RCPT first")
+				continue
+			}
+			sess.text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := sess.text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, Message{From: sess.from, To: sess.to, Data: data, TLS: sess.tls, Auth: sess.auth})
+			s.mu.Unlock()
+			sess.from, sess.to = "", nil
+			sess.text.PrintfLine("250 OK: queued")
+		case "RSET":
+			sess.from, sess.to = "", nil
+			sess.text.PrintfLine("250 OK")
+		case "QUIT":
+			sess.text.PrintfLine("221 Bye")
+			return
+		default:
+			sess.text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *Server) scripted(command string) (reply, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued := s.replies[command]
+	if len(queued) == 0 {
+		return reply{}, false
+	}
+	s.replies[command] = queued[1:]
+	return queued[0], true
+}
+
+func (s *Server) ehlo(sess *session) {
+	lines := []string{"smtptest", "8BITMIME"}
+	if s.opts.StartTLS && !sess.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	if s.opts.Username != "" {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		sess.text.PrintfLine("250%s%s", sep, line)
+	}
+}
+
+// startTLS upgrades the session, returning false if the connection is no
+// longer usable.
+func (s *Server) startTLS(sess *session) bool {
+	if !s.opts.StartTLS || sess.tls {
+		sess.text.PrintfLine("502 STARTTLS not available")
+		return true
+	}
+	sess.text.PrintfLine("220 Ready to start TLS")
+
+	conn := tls.Server(sess.conn, s.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		return false
+	}
+	// The client starts over with EHLO on the encrypted connection
+	*sess = session{conn: conn, text: textproto.NewConn(conn), tls: true}
+	return true
+}
+
+func (s *Server) authenticate(sess *session, arg string) {
+	if s.opts.Username == "" {
+		sess.text.PrintfLine("502 AUTH not available")
+		return
+	}
+
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		if initial == "" {
+			initial = sess.challenge("")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(initial)
+		if err != nil {
+			sess.text.PrintfLine("501 Invalid response")
+			return
+		}
+		// authzid NUL authcid NUL password
+		fields := bytes.Split(decoded, []byte{0})
+		if len(fields) != 3 {
+			sess.text.PrintfLine("501 Invalid response")
+			return
+		}
+		username, password = string(fields[1]), string(fields[2])
+	case "LOGIN":
+		username = decode(sess.challenge("Username:"))
+		password = decode(sess.challenge("Password:"))
+	default:
+		sess.text.PrintfLine("504 Unrecognized authentication type")
+		return
+	}
+
+	if username != s.opts.Username || password != s.opts.Password {
+		sess.text.PrintfLine("535 Authentication credentials invalid")
+		return
+	}
+	sess.auth = true
+	sess.text.PrintfLine("235 Authentication successful")
+}
+
+// challenge sends a 334 challenge and returns the client's response.
+func (sess *session) challenge(prompt string) string {
+	sess.text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+	line, _ := sess.text.ReadLine()
+	return line
+}
+
+func decode(s string) string {
+	b, _ := base64.StdEncoding.DecodeString(s)
+	return string(b)
+}
+
+// address extracts the path from "FROM:<a@b>" or "TO:<a@b>".
+func address(arg string) string {
+	_, path, _ := strings.Cut(arg, ":")
+	path = strings.TrimSpace(path)
+	if end := strings.IndexByte(path, '>'); strings.HasPrefix(path, "<") && end > 0 {
+		return path[1:end]
+	}
+	return path
+}
+
+func selfSignedCert() (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smtptest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	return cert, certPEM, nil
+}
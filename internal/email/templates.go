@@ -0,0 +1,191 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Built-in templates, used for any file EMAIL_TEMPLATE_DIR doesn't provide.
+//
+//go:embed templates
+var defaultTemplates embed.FS
+
+// Template files are named worked_hours.<locale>.txt and
+// worked_hours.<locale>.html. The text template also defines "subject".
+var templateFile = regexp.MustCompile(`^worked_hours\.([A-Za-z0-9_-]+)\.(txt|html)$`)
+
+// WorkedHours is the data the worked_hours templates are executed with.
+// Times are in the server's time zone.
+type WorkedHours struct {
+	EmployeeID string
+	Locale     string
+	Date       time.Time
+	Worked     time.Duration
+	// CheckinTime and CheckoutTime are nil in emails queued by releases that
+	// didn't send them
+	CheckinTime  *time.Time
+	CheckoutTime *time.Time
+	// WeekWorked totals the week from WeekStart (a Monday) up to Date
+	WeekStart  time.Time
+	WeekWorked time.Duration
+}
+
+var templateFuncs = map[string]any{
+	"hours":   func(d time.Duration) int { return int(d / time.Hour) },
+	"minutes": func(d time.Duration) int { return int(d % time.Hour / time.Minute) },
+	"hm": func(d time.Duration) string {
+		return fmt.Sprintf("%dh %02dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	},
+}
+
+type localeTemplates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Templates renders worked-hours emails in the employee's language.
+type Templates struct {
+	locales       map[string]*localeTemplates
+	defaultLocale string
+}
+
+// LoadTemplates parses the built-in templates, replaced or extended by the
+// files in dir if it isn't empty. Every locale needs both a text and an HTML
+// template, and templates that fail on sample data are refused.
+func LoadTemplates(dir, defaultLocale string) (*Templates, error) {
+	sources := make(map[string]map[string]string) // locale -> extension -> source
+
+	builtin, err := fs.Sub(defaultTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if err := readTemplates(builtin, sources); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := readTemplates(os.DirFS(dir), sources); err != nil {
+			return nil, fmt.Errorf("failed to read email templates from %s: %w", dir, err)
+		}
+	}
+
+	t := &Templates{
+		locales:       make(map[string]*localeTemplates),
+		defaultLocale: normalizeLocale(defaultLocale),
+	}
+	for locale, files := range sources {
+		if files["txt"] == "" || files["html"] == "" {
+			return nil, fmt.Errorf("email templates for locale %s need both a .txt and an .html file", locale)
+		}
+
+		text, err := texttemplate.New(locale).Funcs(templateFuncs).Parse(files["txt"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse text email template for %s: %w", locale, err)
+		}
+		if text.Lookup("subject") == nil {
+			return nil, fmt.Errorf("text email template for %s does not define \"subject\"", locale)
+		}
+		html, err := htmltemplate.New(locale).Funcs(templateFuncs).Parse(files["html"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse HTML email template for %s: %w", locale, err)
+		}
+		t.locales[locale] = &localeTemplates{text: text, html: html}
+
+		if _, _, _, err := t.Render(locale, sampleWorkedHours()); err != nil {
+			return nil, fmt.Errorf("email templates for %s: %w", locale, err)
+		}
+	}
+
+	if t.locales[t.defaultLocale] == nil {
+		return nil, fmt.Errorf("no email templates for the default locale %q", defaultLocale)
+	}
+	return t, nil
+}
+
+func readTemplates(fsys fs.FS, sources map[string]map[string]string) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		match := templateFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return err
+		}
+
+		locale := normalizeLocale(match[1])
+		if sources[locale] == nil {
+			sources[locale] = make(map[string]string)
+		}
+		sources[locale][match[2]] = string(data)
+	}
+	return nil
+}
+
+// Render returns the subject and bodies of an email in locale, falling back
+// to its language ("de" for "de-AT") and then to the default locale.
+func (t *Templates) Render(locale string, data *WorkedHours) (subject, text, html string, err error) {
+	tmpl := t.lookup(locale)
+
+	var buf bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", fmt.Errorf("failed to render subject: %w", err)
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := tmpl.text.Execute(&buf, data); err != nil {
+		return "", "", "", fmt.Errorf("failed to render text body: %w", err)
+	}
+	text = buf.String()
+
+	buf.Reset()
+	if err := tmpl.html.Execute(&buf, data); err != nil {
+		return "", "", "", fmt.Errorf("failed to render HTML body: %w", err)
+	}
+	return subject, text, buf.String(), nil
+}
+
+func (t *Templates) lookup(locale string) *localeTemplates {
+	locale = normalizeLocale(locale)
+	if tmpl, ok := t.locales[locale]; ok {
+		return tmpl
+	}
+	if language, _, ok := strings.Cut(locale, "-"); ok {
+		if tmpl, ok := t.locales[language]; ok {
+			return tmpl
+		}
+	}
+	return t.locales[t.defaultLocale]
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+// sampleWorkedHours exercises every field a template may use.
+func sampleWorkedHours() *WorkedHours {
+	checkin := time.Date(2024, 1, 15, 8, 0, 0, 0, time.Local)
+	checkout := checkin.Add(8*time.Hour + 30*time.Minute)
+	return &WorkedHours{
+		EmployeeID:   "EMP001",
+		Locale:       "en",
+		Date:         time.Date(2024, 1, 15, 0, 0, 0, 0, time.Local),
+		Worked:       8*time.Hour + 30*time.Minute,
+		CheckinTime:  &checkin,
+		CheckoutTime: &checkout,
+		WeekStart:    time.Date(2024, 1, 15, 0, 0, 0, 0, time.Local),
+		WeekWorked:   8*time.Hour + 30*time.Minute,
+	}
+}
@@ -0,0 +1,105 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocaleFallback(t *testing.T) {
+	templates, err := LoadTemplates("", "en")
+	if err != nil {
+		t.Fatalf("LoadTemplates: %v", err)
+	}
+
+	const english, german = "Your work hours for Monday, January 15", "Ihre Arbeitszeit am 15.01.2024"
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"en", english},
+		{"de", german},
+		{"de-AT", german},
+		{"de_CH", german},
+		{"DE-at", german},
+		{"en-GB", english},
+		{"fr", english},
+		{"fr-CA", english},
+		{"", english},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			subject, _, _, err := templates.Render(tt.locale, sampleWorkedHours())
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if subject != tt.want {
+				t.Errorf("subject = %q, want %q", subject, tt.want)
+			}
+		})
+	}
+}
+
+func writeTemplate(t *testing.T, dir, name, source string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestTemplateDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "worked_hours.fr.txt", `{{define "subject"}}Vos heures du {{.Date.Format "02/01/2006"}}{{end}}Bonjour {{.EmployeeID}}`)
+	writeTemplate(t, dir, "worked_hours.fr.html", `<p>Bonjour {{.EmployeeID}}</p>`)
+	writeTemplate(t, dir, "worked_hours.en.txt", `{{define "subject"}}Hours worked{{end}}Hi {{.EmployeeID}}`)
+	writeTemplate(t, dir, "worked_hours.en.html", `<p>Hi {{.EmployeeID}}</p>`)
+
+	templates, err := LoadTemplates(dir, "de")
+	if err != nil {
+		t.Fatalf("LoadTemplates: %v", err)
+	}
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"fr-BE", "Vos heures du 15/01/2024"}, // added by the directory
+		{"en", "Hours worked"},                // replaced by the directory
+		{"de", "Ihre Arbeitszeit am 15.01.2024"},
+		{"es", "Ihre Arbeitszeit am 15.01.2024"}, // the default locale
+	}
+	for _, tt := range tests {
+		subject, text, _, err := templates.Render(tt.locale, sampleWorkedHours())
+		if err != nil {
+			t.Fatalf("Render(%s): %v", tt.locale, err)
+		}
+		if subject != tt.want || !strings.Contains(text, "EMP001") {
+			t.Errorf("Render(%s) = %q, %q; want subject %q", tt.locale, subject, text, tt.want)
+		}
+	}
+}
+
+func TestLoadTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         map[string]string
+		defaultLocale string
+	}{
+		{"no templates for the default locale", nil, "fr"},
+		{"missing html", map[string]string{"worked_hours.fr.txt": `{{define "subject"}}x{{end}}x`}, "en"},
+		{"missing subject", map[string]string{"worked_hours.fr.txt": `x`, "worked_hours.fr.html": `x`}, "en"},
+		{"fails on sample data", map[string]string{"worked_hours.fr.txt": `{{define "subject"}}{{.Missing}}{{end}}x`, "worked_hours.fr.html": `x`}, "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, source := range tt.files {
+				writeTemplate(t, dir, name, source)
+			}
+			if _, err := LoadTemplates(dir, tt.defaultLocale); err == nil {
+				t.Fatal("LoadTemplates succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+// Package export writes completed work sessions to flat files for payroll
+// systems that can only import files, as an alternative to the legacy API.
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+const (
+	// Name the exporter's progress is stored under
+	watermarkName = "payroll"
+	// Name of the lock taken with repository.Tx.LockJob
+	exportJob  = "payroll-export"
+	filePrefix = "labor-cost"
+	batchTime  = "20060102T150405Z"
+)
+
+// Manifest describes one export batch. It is written after the batch's data
+// and checksum files, so an importer that waits for it never sees a partial
+// batch.
+type Manifest struct {
+	Batch       string         `json:"batch"`
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Records     int            `json:"records"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Files       []ManifestFile `json:"files"`
+	Rejected    []Rejection    `json:"rejected,omitempty"`
+	// RejectsFile lists the rejected sessions in full, if there are any
+	RejectsFile string `json:"rejects_file,omitempty"`
+}
+
+// Rejection is a session left out of a batch because it can't be written.
+type Rejection struct {
+	SessionID int    `json:"session_id"`
+	Reason    string `json:"reason"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Exporter periodically writes the sessions completed since its last run to
+// the export directory, once per configured format. A watermark in the
+// database records how far it has got. If the process stops between writing
+// a batch and moving the watermark, the next batch repeats those sessions,
+// so importers should ignore session IDs they have already seen. Only one
+// replica exports at a time.
+type Exporter struct {
+	repo     repository.Repository
+	dir      string
+	formats  []string
+	layout   *Layout
+	interval time.Duration
+	// Sessions are exported once they have been completed this long, so a
+	// checkout still committing isn't skipped by the watermark
+	delay    time.Duration
+	timeouts config.Timeouts
+	stopChan chan bool
+	doneChan chan bool
+}
+
+func NewExporter(repo repository.Repository, cfg *config.Config) (*Exporter, error) {
+	e := &Exporter{
+		repo:     repo,
+		dir:      cfg.ExportDir,
+		formats:  cfg.ExportFormats,
+		interval: time.Duration(cfg.ExportIntervalMinutes) * time.Minute,
+		delay:    time.Duration(cfg.ExportDelaySeconds) * time.Second,
+		timeouts: cfg.Timeouts,
+		stopChan: make(chan bool),
+		doneChan: make(chan bool),
+	}
+	if !e.enabled() {
+		return e, nil
+	}
+
+	for _, name := range e.formats {
+		if _, ok := formats[name]; !ok {
+			return nil, fmt.Errorf("unknown export format %q (expected csv, fixed or xml)", name)
+		}
+	}
+
+	if cfg.ExportLayoutFile != "" {
+		layout, err := LoadLayout(cfg.ExportLayoutFile)
+		if err != nil {
+			return nil, err
+		}
+		e.layout = layout
+	} else {
+		e.layout = DefaultLayout()
+		if err := e.layout.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range e.formats {
+		if name != "fixed" {
+			continue
+		}
+		for _, c := range e.layout.Columns {
+			if c.Width <= 0 {
+				return nil, fmt.Errorf("column %s needs a width for fixed-width exports", c.Name)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(e.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+	return e, nil
+}
+
+func (e *Exporter) enabled() bool {
+	return len(e.formats) > 0 && e.interval > 0
+}
+
+func (e *Exporter) Start() {
+	if !e.enabled() {
+		log.Println("Payroll export disabled")
+		close(e.doneChan)
+		return
+	}
+	log.Printf("Payroll export started - writing %v files to %s every %s...", e.formats, e.dir, e.interval)
+
+	go func() {
+		defer close(e.doneChan)
+
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		e.run()
+		for {
+			select {
+			case <-e.stopChan:
+				log.Println("Payroll export stopped")
+				return
+			case <-ticker.C:
+				e.run()
+			}
+		}
+	}()
+}
+
+// Stop signals the exporter to stop and waits for an export in progress to
+// finish.
+func (e *Exporter) Stop() {
+	close(e.stopChan)
+	<-e.doneChan
+}
+
+func (e *Exporter) run() {
+	manifest, err := e.Export(context.Background())
+	if err != nil {
+		log.Printf("Payroll export failed: %v", err)
+		return
+	}
+	if manifest != nil {
+		log.Printf("Exported %d sessions as %s", manifest.Records, manifest.Batch)
+	}
+}
+
+// Export writes the sessions completed since the last export and moves the
+// watermark past them. It returns the batch's manifest, or nil if there was
+// nothing to export or another replica is exporting. Sessions before the
+// first export's day are never exported.
+//
+// The sessions are read and their files written outside any transaction, so
+// a slow export doesn't hold up check-ins. The files are staged under
+// temporary names; the transaction holding the export lock only checks that
+// no other replica moved the watermark meanwhile, moves the files into
+// place, writes the manifest and moves the watermark. A replica that loses
+// the race discards its staged files, so replicas never publish the same
+// sessions twice.
+func (e *Exporter) Export(ctx context.Context) (*Manifest, error) {
+	b, err := e.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, nil
+	}
+	defer b.discard()
+
+	err = e.commit(ctx, b)
+	if errors.Is(err, repository.ErrConflict) {
+		log.Printf("Payroll export is running elsewhere, skipping this run: %v", err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.manifest, nil
+}
+
+// batch is an export whose files are staged but not yet in place.
+type batch struct {
+	watermark *time.Time // as read; nil before the first export
+	to        time.Time
+	manifest  *Manifest // nil if no sessions were completed
+	staged    []stagedFile
+}
+
+type stagedFile struct {
+	tmp  string
+	name string
+}
+
+// discard removes the staged files that weren't moved into place.
+func (b *batch) discard() {
+	for _, f := range b.staged {
+		os.Remove(f.tmp)
+	}
+}
+
+// prepare reads the sessions completed since the watermark and stages their
+// files. It returns nil if the watermark is already up to date.
+func (e *Exporter) prepare(ctx context.Context) (b *batch, err error) {
+	dbCtx, cancel := context.WithTimeout(ctx, e.timeouts.Database)
+	defer cancel()
+
+	now := time.Now()
+	from := startOfDay(now)
+	watermark, err := e.repo.GetExportWatermark(dbCtx, watermarkName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export watermark: %w", err)
+	}
+	if watermark != nil {
+		from = *watermark
+	}
+	to := now.Add(-e.delay).Truncate(time.Second)
+	if !to.After(from) {
+		return nil, nil
+	}
+
+	sessions, err := e.repo.ListCompletedSessions(dbCtx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list completed sessions: %w", err)
+	}
+
+	b = &batch{watermark: watermark, to: to}
+	if len(sessions) == 0 {
+		return b, nil
+	}
+	defer func() {
+		if err != nil {
+			b.discard()
+		}
+	}()
+
+	manifest := &Manifest{
+		Batch:       fmt.Sprintf("%s-%s-%s", filePrefix, from.UTC().Format(batchTime), to.UTC().Format(batchTime)),
+		From:        from,
+		To:          to,
+		GeneratedAt: now,
+	}
+
+	kept, rejected := e.reject(sessions)
+	manifest.Records = len(kept)
+	manifest.Rejected = rejected
+	for _, r := range rejected {
+		log.Printf("Payroll export %s rejected session %d: %s", manifest.Batch, r.SessionID, r.Reason)
+	}
+
+	// Render every format before writing any, so a failure leaves no
+	// partial batch behind
+	rendered := make([][]byte, len(e.formats))
+	for i, name := range e.formats {
+		var buf bytes.Buffer
+		if err := formats[name].write(&buf, e.layout, kept); err != nil {
+			return nil, fmt.Errorf("failed to write %s export: %w", name, err)
+		}
+		rendered[i] = buf.Bytes()
+	}
+
+	for i, name := range e.formats {
+		file, err := e.stageWithChecksum(b, manifest.Batch+"."+formats[name].extension, rendered[i])
+		if err != nil {
+			return nil, err
+		}
+		file.Format = name
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	if len(rejected) > 0 {
+		var buf bytes.Buffer
+		if err := writeRejects(&buf, sessions, rejected); err != nil {
+			return nil, fmt.Errorf("failed to write rejected sessions: %w", err)
+		}
+		manifest.RejectsFile = manifest.Batch + ".rejected.csv"
+		if err := e.stage(b, manifest.RejectsFile, buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	b.manifest = manifest
+	return b, nil
+}
+
+// commit publishes a staged batch and moves the watermark past it, holding
+// the export lock. It returns repository.ErrConflict if another replica holds
+// the lock or moved the watermark since the batch was read.
+func (e *Exporter) commit(ctx context.Context, b *batch) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Database)
+	defer cancel()
+
+	return e.repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		if err := tx.LockJob(ctx, exportJob); err != nil {
+			return err
+		}
+		current, err := tx.GetExportWatermark(ctx, watermarkName)
+		if err != nil {
+			return fmt.Errorf("failed to read export watermark: %w", err)
+		}
+		if !sameWatermark(current, b.watermark) {
+			return fmt.Errorf("export watermark moved to %v meanwhile: %w", current, repository.ErrConflict)
+		}
+
+		if b.manifest != nil {
+			for _, f := range b.staged {
+				if err := os.Rename(f.tmp, filepath.Join(e.dir, f.name)); err != nil {
+					return fmt.Errorf("failed to move %s into place: %w", f.name, err)
+				}
+			}
+			data, err := json.MarshalIndent(b.manifest, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to encode export manifest: %w", err)
+			}
+			if err := e.writeFile(b.manifest.Batch+".manifest.json", append(data, '\n')); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.SetExportWatermark(ctx, watermarkName, b.to); err != nil {
+			return fmt.Errorf("failed to update export watermark: %w", err)
+		}
+		return nil
+	})
+}
+
+func sameWatermark(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
+
+// reject leaves out the sessions that can't be written in every format,
+// i.e. those with a value too long for its fixed-width column, so one bad
+// record can't hold up the export. Rejected sessions are left out of every
+// format, keeping the files of a batch in step.
+func (e *Exporter) reject(sessions []model.WorkSession) ([]model.WorkSession, []Rejection) {
+	if !slices.Contains(e.formats, "fixed") {
+		return sessions, nil
+	}
+
+	var rejected []Rejection
+	kept := sessions[:0:0]
+	for i := range sessions {
+		if err := e.layout.fitsFixed(&sessions[i]); err != nil {
+			rejected = append(rejected, Rejection{SessionID: sessions[i].ID, Reason: err.Error()})
+			continue
+		}
+		kept = append(kept, sessions[i])
+	}
+	return kept, rejected
+}
+
+// writeRejects writes the rejected sessions as CSV with the reason for each,
+// so they can be entered into payroll by hand.
+func writeRejects(w io.Writer, sessions []model.WorkSession, rejected []Rejection) error {
+	byID := make(map[int]*model.WorkSession, len(sessions))
+	for i := range sessions {
+		byID[sessions[i].ID] = &sessions[i]
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"session_id", "employee_id", "checkin_time", "checkout_time", "hours_worked", "reason"}); err != nil {
+		return err
+	}
+	for _, r := range rejected {
+		session := byID[r.SessionID]
+		var checkout, hours string
+		if session.CheckoutTime != nil {
+			checkout = session.CheckoutTime.Format(time.RFC3339)
+		}
+		if session.HoursWorked != nil {
+			hours = strconv.FormatFloat(*session.HoursWorked, 'f', 2, 64)
+		}
+		record := []string{strconv.Itoa(session.ID), session.EmployeeID, session.CheckinTime.Format(time.RFC3339), checkout, hours, r.Reason}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// stageWithChecksum stages a data file followed by a checksum file in the
+// format sha256sum -c reads.
+func (e *Exporter) stageWithChecksum(b *batch, name string, data []byte) (ManifestFile, error) {
+	sum := sha256.Sum256(data)
+	file := ManifestFile{Name: name, Size: len(data), SHA256: hex.EncodeToString(sum[:])}
+
+	if err := e.stage(b, name, data); err != nil {
+		return file, err
+	}
+	if err := e.stage(b, name+".sha256", []byte(file.SHA256+"  "+name+"\n")); err != nil {
+		return file, err
+	}
+	return file, nil
+}
+
+// stage writes a file under a temporary name, to be moved into place when b
+// is committed.
+func (e *Exporter) stage(b *batch, name string, data []byte) error {
+	tmp, err := e.writeTemp(name, data)
+	if err != nil {
+		return err
+	}
+	b.staged = append(b.staged, stagedFile{tmp: tmp, name: name})
+	return nil
+}
+
+// writeFile writes a file under a temporary name and renames it into place,
+// so importers watching the directory never read a partial file.
+func (e *Exporter) writeFile(name string, data []byte) error {
+	tmp, err := e.writeTemp(name, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Rename(tmp, filepath.Join(e.dir, name)); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", name, err)
+	}
+	return nil
+}
+
+// writeTemp writes data to a hidden temporary file for name and returns its
+// path.
+func (e *Exporter) writeTemp(name string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(e.dir, "."+name+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", name, err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return tmp.Name(), nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
@@ -0,0 +1,207 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+func newTestExporter(t *testing.T, repo repository.Repository) *Exporter {
+	t.Helper()
+	cfg := config.Load()
+	cfg.ExportFormats = []string{"csv", "fixed"}
+	cfg.ExportDir = t.TempDir()
+	cfg.ExportLayoutFile = ""
+	cfg.ExportIntervalMinutes = 60
+	cfg.ExportDelaySeconds = 0
+
+	e, err := NewExporter(repo, cfg)
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+	return e
+}
+
+func storeSession(t *testing.T, repo repository.Repository, employeeID string, checkout time.Time) int {
+	t.Helper()
+	ctx := context.Background()
+	session := &model.WorkSession{EmployeeID: employeeID, CheckinTime: checkout.Add(-8 * time.Hour), Status: "active"}
+	if err := repo.CreateWorkSession(ctx, session); err != nil {
+		t.Fatalf("CreateWorkSession: %v", err)
+	}
+	hours := 8.0
+	session.CheckoutTime = &checkout
+	session.HoursWorked = &hours
+	session.Status = "completed"
+	if err := repo.UpdateWorkSession(ctx, session); err != nil {
+		t.Fatalf("UpdateWorkSession: %v", err)
+	}
+	return session.ID
+}
+
+func TestExportRejectsOverflow(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	e := newTestExporter(t, repo)
+
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	if err := repo.SetExportWatermark(ctx, watermarkName, start); err != nil {
+		t.Fatalf("SetExportWatermark: %v", err)
+	}
+	storeSession(t, repo, "EMP001", start.Add(time.Hour))
+	// Longer than the 50 characters of the default employee_id column
+	tooLong := storeSession(t, repo, fmt.Sprintf("EMP-%060d", 1), start.Add(time.Hour+time.Minute))
+
+	manifest, err := e.Export(ctx)
+	if err != nil {
+		t.Fatalf("Export() = %v, want the batch written without the overflowing session", err)
+	}
+	if manifest == nil || manifest.Records != 1 || len(manifest.Rejected) != 1 || manifest.Rejected[0].SessionID != tooLong {
+		t.Fatalf("manifest = %+v, want 1 record and session %d rejected", manifest, tooLong)
+	}
+
+	for _, file := range manifest.Files {
+		data, err := os.ReadFile(filepath.Join(e.dir, file.Name))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		lines := bytes.Count(data, []byte("\n"))
+		if want := map[string]int{"csv": 2, "fixed": 1}[file.Format]; lines != want {
+			t.Errorf("%s has %d lines, want %d", file.Name, lines, want)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(e.dir, manifest.Batch+".manifest.json"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var written Manifest
+	if err := json.Unmarshal(data, &written); err != nil || len(written.Rejected) != 1 {
+		t.Fatalf("manifest file = %s, %v; want the rejected session listed", data, err)
+	}
+
+	// The rejected session is kept in full for entering by hand
+	if manifest.RejectsFile != manifest.Batch+".rejected.csv" {
+		t.Fatalf("rejects file = %q, want %s.rejected.csv", manifest.RejectsFile, manifest.Batch)
+	}
+	data, err = os.ReadFile(filepath.Join(e.dir, manifest.RejectsFile))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], fmt.Sprintf("%d,EMP-%060d,", tooLong, 1)) || !strings.Contains(lines[1], ",8.00,") {
+		t.Fatalf("rejects file = %q, want a header and session %d", data, tooLong)
+	}
+
+	// The watermark moved past both sessions
+	watermark, err := repo.GetExportWatermark(ctx, watermarkName)
+	if err != nil || watermark == nil || !watermark.Equal(manifest.To) {
+		t.Fatalf("watermark = %v, %v; want %v", watermark, err, manifest.To)
+	}
+	if again, err := e.Export(ctx); err != nil || again != nil {
+		t.Fatalf("second Export() = %+v, %v; want nothing new", again, err)
+	}
+}
+
+// lockedRepository is a repository whose export lock is held by another
+// replica.
+type lockedRepository struct {
+	*repository.MemoryRepository
+}
+
+func (r lockedRepository) WithinTransaction(ctx context.Context, fn func(tx repository.Tx) error) error {
+	return r.MemoryRepository.WithinTransaction(ctx, func(tx repository.Tx) error {
+		return fn(lockedTx{tx})
+	})
+}
+
+type lockedTx struct {
+	repository.Tx
+}
+
+func (lockedTx) LockJob(ctx context.Context, job string) error {
+	return fmt.Errorf("job %s is running elsewhere: %w", job, repository.ErrConflict)
+}
+
+func TestExportSkipsWhileLocked(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	e := newTestExporter(t, lockedRepository{repo})
+
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	if err := repo.SetExportWatermark(ctx, watermarkName, start); err != nil {
+		t.Fatalf("SetExportWatermark: %v", err)
+	}
+	storeSession(t, repo, "EMP001", start.Add(time.Hour))
+
+	if manifest, err := e.Export(ctx); err != nil || manifest != nil {
+		t.Fatalf("Export() = %+v, %v; want nothing while another replica exports", manifest, err)
+	}
+	if entries, _ := os.ReadDir(e.dir); len(entries) != 0 {
+		t.Fatalf("%d files written while locked, want none", len(entries))
+	}
+	if watermark, _ := repo.GetExportWatermark(ctx, watermarkName); !watermark.Equal(start) {
+		t.Fatalf("watermark = %v, want it unchanged at %v", watermark, start)
+	}
+}
+
+// racingRepository is a repository where another replica finishes an export
+// while this one writes its files.
+type racingRepository struct {
+	*repository.MemoryRepository
+	t     *testing.T
+	other time.Time
+}
+
+func (r racingRepository) WithinTransaction(ctx context.Context, fn func(tx repository.Tx) error) error {
+	if err := r.MemoryRepository.SetExportWatermark(ctx, watermarkName, r.other); err != nil {
+		return err
+	}
+	return r.MemoryRepository.WithinTransaction(ctx, func(tx repository.Tx) error {
+		return fn(noListingTx{tx, r.t})
+	})
+}
+
+// noListingTx fails the test if sessions are read inside the transaction,
+// which would hold up check-ins for as long as the export takes.
+type noListingTx struct {
+	repository.Tx
+	t *testing.T
+}
+
+func (tx noListingTx) ListCompletedSessions(ctx context.Context, from, to time.Time) ([]model.WorkSession, error) {
+	tx.t.Error("sessions listed inside the export transaction")
+	return tx.Tx.ListCompletedSessions(ctx, from, to)
+}
+
+func TestExportDiscardsBatchWhenWatermarkMoved(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	other := start.Add(90 * time.Minute)
+	e := newTestExporter(t, racingRepository{repo, t, other})
+
+	if err := repo.SetExportWatermark(ctx, watermarkName, start); err != nil {
+		t.Fatalf("SetExportWatermark: %v", err)
+	}
+	storeSession(t, repo, "EMP001", start.Add(time.Hour))
+
+	if manifest, err := e.Export(ctx); err != nil || manifest != nil {
+		t.Fatalf("Export() = %+v, %v; want nothing after another replica exported", manifest, err)
+	}
+	if entries, _ := os.ReadDir(e.dir); len(entries) != 0 {
+		t.Fatalf("%d files left behind, want the staged batch discarded", len(entries))
+	}
+	if watermark, _ := repo.GetExportWatermark(ctx, watermarkName); !watermark.Equal(other) {
+		t.Fatalf("watermark = %v, want the other replica's %v", watermark, other)
+	}
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// writer writes a batch of completed sessions in one format.
+type writer func(w io.Writer, layout *Layout, sessions []model.WorkSession) error
+
+type format struct {
+	extension string
+	write     writer
+}
+
+// Formats selectable with EXPORT_FORMATS.
+var formats = map[string]format{
+	"csv":   {extension: "csv", write: writeCSV},
+	"fixed": {extension: "dat", write: writeFixedWidth},
+	"xml":   {extension: "xml", write: writeXML},
+}
+
+func writeCSV(w io.Writer, layout *Layout, sessions []model.WorkSession) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = []rune(layout.CSVDelimiter)[0]
+	cw.UseCRLF = layout.LineEnding == "crlf"
+
+	record := make([]string, len(layout.Columns))
+	if layout.CSVHeader {
+		for i, c := range layout.Columns {
+			record[i] = c.Name
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	for i := range sessions {
+		for j := range layout.Columns {
+			record[j] = layout.Columns[j].value(&sessions[i])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeFixedWidth(w io.Writer, layout *Layout, sessions []model.WorkSession) error {
+	newline := "\n"
+	if layout.LineEnding == "crlf" {
+		newline = "\r\n"
+	}
+
+	bw := bufio.NewWriter(w)
+	for i := range sessions {
+		for j := range layout.Columns {
+			c := &layout.Columns[j]
+			field, err := c.fixed(c.value(&sessions[i]))
+			if err != nil {
+				return fmt.Errorf("session %d: %w", sessions[i].ID, err)
+			}
+			bw.WriteString(field)
+		}
+		bw.WriteString(newline)
+	}
+	return bw.Flush()
+}
+
+func writeXML(w io.Writer, layout *Layout, sessions []model.WorkSession) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	root := xml.StartElement{Name: xml.Name{Local: layout.XMLRoot}}
+	if err := enc.EncodeToken(root); err != nil {
+		return err
+	}
+	for i := range sessions {
+		record := xml.StartElement{Name: xml.Name{Local: layout.XMLRecord}}
+		if err := enc.EncodeToken(record); err != nil {
+			return err
+		}
+		for j := range layout.Columns {
+			c := &layout.Columns[j]
+			if err := enc.EncodeElement(c.value(&sessions[i]), xml.StartElement{Name: xml.Name{Local: c.Name}}); err != nil {
+				return err
+			}
+		}
+		if err := enc.EncodeToken(record.End()); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
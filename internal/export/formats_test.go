@@ -0,0 +1,124 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+func completedSession(id int, employeeID string, checkin time.Time, hours float64) model.WorkSession {
+	checkout := checkin.Add(time.Duration(hours * float64(time.Hour)))
+	return model.WorkSession{
+		ID:           id,
+		EmployeeID:   employeeID,
+		CheckinTime:  checkin,
+		CheckoutTime: &checkout,
+		HoursWorked:  &hours,
+		Status:       "completed",
+	}
+}
+
+// testLayout avoids time zones in its output, so it is the same wherever
+// the test runs.
+func testLayout(t *testing.T, lineEnding, delimiter string) *Layout {
+	t.Helper()
+	two := 2
+	layout := &Layout{
+		Columns: []Column{
+			{Field: FieldConstant, Name: "PLANT", Value: "P01", Width: 3},
+			{Field: FieldSessionID, Name: "ID", Width: 5, Align: "right", Pad: "0"},
+			{Field: FieldEmployeeID, Name: "EMPNO", Width: 8},
+			{Field: FieldWorkDate, Name: "DATE", Format: "20060102", Width: 8},
+			{Field: FieldCheckinTime, Name: "IN", Format: "15:04", Width: 5},
+			{Field: FieldHoursWorked, Name: "HOURS", Decimals: &two, Width: 6, Align: "right", Pad: "0"},
+		},
+		CSVDelimiter: delimiter,
+		CSVHeader:    true,
+		LineEnding:   lineEnding,
+		XMLRoot:      "LaborCost",
+		XMLRecord:    "Session",
+	}
+	if err := layout.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	return layout
+}
+
+func testSessions() []model.WorkSession {
+	return []model.WorkSession{
+		completedSession(7, "EMP001", time.Date(2024, 1, 15, 8, 0, 0, 0, time.Local), 8.5),
+		// Worked overnight: dated by checkout
+		completedSession(12, "A&B,1", time.Date(2024, 1, 16, 22, 0, 0, 0, time.Local), 7.25),
+	}
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		lineEnding string
+		delimiter  string
+		want       string
+	}{
+		{"csv", "csv", "lf", ",", "" +
+			"PLANT,ID,EMPNO,DATE,IN,HOURS\n" +
+			"P01,7,EMP001,20240115,08:00,8.50\n" +
+			"P01,12,\"A&B,1\",20240117,22:00,7.25\n"},
+		{"csv with crlf and semicolons", "csv", "crlf", ";", "" +
+			"PLANT;ID;EMPNO;DATE;IN;HOURS\r\n" +
+			"P01;7;EMP001;20240115;08:00;8.50\r\n" +
+			"P01;12;A&B,1;20240117;22:00;7.25\r\n"},
+		{"fixed", "fixed", "lf", ",", "" +
+			"P0100007EMP001  2024011508:00008.50\n" +
+			"P0100012A&B,1   2024011722:00007.25\n"},
+		{"fixed with crlf", "fixed", "crlf", ",", "" +
+			"P0100007EMP001  2024011508:00008.50\r\n" +
+			"P0100012A&B,1   2024011722:00007.25\r\n"},
+		{"xml", "xml", "lf", ",", `<?xml version="1.0" encoding="UTF-8"?>
+<LaborCost>
+  <Session>
+    <PLANT>P01</PLANT>
+    <ID>7</ID>
+    <EMPNO>EMP001</EMPNO>
+    <DATE>20240115</DATE>
+    <IN>08:00</IN>
+    <HOURS>8.50</HOURS>
+  </Session>
+  <Session>
+    <PLANT>P01</PLANT>
+    <ID>12</ID>
+    <EMPNO>A&amp;B,1</EMPNO>
+    <DATE>20240117</DATE>
+    <IN>22:00</IN>
+    <HOURS>7.25</HOURS>
+  </Session>
+</LaborCost>
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := formats[tt.format].write(&buf, testLayout(t, tt.lineEnding, tt.delimiter), testSessions()); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixedOverflow(t *testing.T) {
+	layout := testLayout(t, "lf", ",")
+	session := completedSession(7, "EMPLOYEE001", time.Date(2024, 1, 15, 8, 0, 0, 0, time.Local), 8)
+
+	if err := layout.fitsFixed(&session); err == nil {
+		t.Fatal("fitsFixed accepted an employee ID longer than its column")
+	}
+	fits := testSessions()[0]
+	if err := layout.fitsFixed(&fits); err != nil {
+		t.Fatalf("fitsFixed = %v, want the session to fit", err)
+	}
+}
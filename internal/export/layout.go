@@ -0,0 +1,204 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// Fields a column can take its value from. FieldConstant writes the column's
+// Value on every record, e.g. a plant code the payroll system expects.
+const (
+	FieldSessionID    = "session_id"
+	FieldEmployeeID   = "employee_id"
+	FieldWorkDate     = "work_date"
+	FieldCheckinTime  = "checkin_time"
+	FieldCheckoutTime = "checkout_time"
+	FieldHoursWorked  = "hours_worked"
+	FieldConstant     = "constant"
+)
+
+var defaultTimeFormats = map[string]string{
+	FieldWorkDate:     "2006-01-02",
+	FieldCheckinTime:  time.RFC3339,
+	FieldCheckoutTime: time.RFC3339,
+}
+
+// Layout describes the records written by every format. It is read from
+// EXPORT_LAYOUT_FILE as JSON; DefaultLayout is used without one.
+type Layout struct {
+	Columns []Column `json:"columns"`
+
+	CSVDelimiter string `json:"csv_delimiter"`
+	CSVHeader    bool   `json:"csv_header"`
+	// LineEnding is "lf" or "crlf", for the CSV and fixed-width formats
+	LineEnding string `json:"line_ending"`
+
+	XMLRoot   string `json:"xml_root"`
+	XMLRecord string `json:"xml_record"`
+}
+
+// Column is one field of a record. Name is the CSV header and XML element
+// name and defaults to Field. Width, Align and Pad only apply to fixed-width
+// records. A session with a value that doesn't fit is rejected from the
+// batch rather than cut short, since a truncated employee ID or number
+// would be read as a different one.
+type Column struct {
+	Field string `json:"field"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// Format is a Go time layout for dates and times
+	Format string `json:"format"`
+	// Decimals is the number of decimal places of hours_worked (default 2)
+	Decimals *int `json:"decimals"`
+
+	Width int    `json:"width"`
+	Align string `json:"align"` // "left" (default) or "right"
+	Pad   string `json:"pad"`   // a single character, default space
+}
+
+func DefaultLayout() *Layout {
+	return &Layout{
+		Columns: []Column{
+			{Field: FieldSessionID, Width: 10, Align: "right", Pad: "0"},
+			{Field: FieldEmployeeID, Width: 50},
+			{Field: FieldWorkDate, Width: 10},
+			{Field: FieldCheckinTime, Width: 25},
+			{Field: FieldCheckoutTime, Width: 25},
+			{Field: FieldHoursWorked, Width: 6, Align: "right", Pad: "0"},
+		},
+		CSVDelimiter: ",",
+		CSVHeader:    true,
+		LineEnding:   "lf",
+		XMLRoot:      "LaborCost",
+		XMLRecord:    "Session",
+	}
+}
+
+// LoadLayout reads a layout from a JSON file. Settings missing from the file
+// keep their DefaultLayout values.
+func LoadLayout(path string) (*Layout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export layout: %w", err)
+	}
+
+	layout := DefaultLayout()
+	layout.Columns = nil
+	if err := json.Unmarshal(data, layout); err != nil {
+		return nil, fmt.Errorf("failed to parse export layout %s: %w", path, err)
+	}
+	if err := layout.validate(); err != nil {
+		return nil, fmt.Errorf("invalid export layout %s: %w", path, err)
+	}
+	return layout, nil
+}
+
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+func (l *Layout) validate() error {
+	if len(l.Columns) == 0 {
+		return fmt.Errorf("no columns")
+	}
+	if utf8.RuneCountInString(l.CSVDelimiter) != 1 {
+		return fmt.Errorf("csv_delimiter must be a single character")
+	}
+	if l.LineEnding != "lf" && l.LineEnding != "crlf" {
+		return fmt.Errorf("line_ending must be lf or crlf")
+	}
+	if !xmlName.MatchString(l.XMLRoot) || !xmlName.MatchString(l.XMLRecord) {
+		return fmt.Errorf("xml_root and xml_record must be valid XML names")
+	}
+
+	for i := range l.Columns {
+		c := &l.Columns[i]
+		switch c.Field {
+		case FieldSessionID, FieldEmployeeID, FieldWorkDate, FieldCheckinTime, FieldCheckoutTime, FieldHoursWorked, FieldConstant:
+		default:
+			return fmt.Errorf("column %d: unknown field %q", i+1, c.Field)
+		}
+		if c.Name == "" {
+			c.Name = c.Field
+		}
+		if !xmlName.MatchString(c.Name) {
+			return fmt.Errorf("column %d: name %q is not a valid XML name", i+1, c.Name)
+		}
+		if c.Align != "" && c.Align != "left" && c.Align != "right" {
+			return fmt.Errorf("column %d: align must be left or right", i+1)
+		}
+		if c.Pad != "" && utf8.RuneCountInString(c.Pad) != 1 {
+			return fmt.Errorf("column %d: pad must be a single character", i+1)
+		}
+		if c.Width < 0 || (c.Decimals != nil && *c.Decimals < 0) {
+			return fmt.Errorf("column %d: width and decimals must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// value formats one column of a completed session.
+func (c *Column) value(session *model.WorkSession) string {
+	format := c.Format
+	if format == "" {
+		format = defaultTimeFormats[c.Field]
+	}
+
+	switch c.Field {
+	case FieldSessionID:
+		return strconv.Itoa(session.ID)
+	case FieldEmployeeID:
+		return session.EmployeeID
+	case FieldWorkDate:
+		// Labor cost reports are dated by checkout too
+		return session.CheckoutTime.In(time.Local).Format(format)
+	case FieldCheckinTime:
+		return session.CheckinTime.In(time.Local).Format(format)
+	case FieldCheckoutTime:
+		return session.CheckoutTime.In(time.Local).Format(format)
+	case FieldHoursWorked:
+		decimals := 2
+		if c.Decimals != nil {
+			decimals = *c.Decimals
+		}
+		return strconv.FormatFloat(*session.HoursWorked, 'f', decimals, 64)
+	default:
+		return c.Value
+	}
+}
+
+// fitsFixed returns an error if one of session's values doesn't fit its
+// fixed-width column.
+func (l *Layout) fitsFixed(session *model.WorkSession) error {
+	for i := range l.Columns {
+		if _, err := l.Columns[i].fixed(l.Columns[i].value(session)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixed pads v to the column width.
+func (c *Column) fixed(v string) (string, error) {
+	n := utf8.RuneCountInString(v)
+	if n > c.Width {
+		return "", fmt.Errorf("value %q does not fit the %d characters of column %s", v, c.Width, c.Name)
+	}
+
+	pad := c.Pad
+	if pad == "" {
+		pad = " "
+	}
+	padding := strings.Repeat(pad, c.Width-n)
+	if c.Align == "right" {
+		return padding + v, nil
+	}
+	return v + padding, nil
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/omaaartamer/factory-checkin-api/internal/service"
+)
+
+const (
+	defaultDeadLetterLimit = 50
+	maxDeadLetterLimit     = 500
+)
+
+// replayRequest selects dead letters to replay in bulk: either explicit IDs,
+// or all of them (optionally of one message type).
+type replayRequest struct {
+	IDs         []int64 `json:"ids"`
+	All         bool    `json:"all"`
+	MessageType string  `json:"type"`
+}
+
+func (h *Handler) listDeadLetters(c *gin.Context) {
+	limit, err := queryInt(c, "limit", defaultDeadLetterLimit)
+	if err != nil || limit < 1 || limit > maxDeadLetterLimit {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "limit must be between 1 and 500",
+		})
+		return
+	}
+
+	offset, err := queryInt(c, "offset", 0)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "offset must be a non-negative number",
+		})
+		return
+	}
+
+	deadLetters, total, err := h.deadLetterService.List(c.Request.Context(), c.Query("type"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to list dead letters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"dead_letters": deadLetters,
+		"total":        total,
+		"limit":        limit,
+		"offset":       offset,
+	})
+}
+
+func (h *Handler) getDeadLetter(c *gin.Context) {
+	id, ok := deadLetterID(c)
+	if !ok {
+		return
+	}
+
+	deadLetter, err := h.deadLetterService.Get(c.Request.Context(), id)
+	if err != nil {
+		h.deadLetterError(c, "Failed to get dead letter", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"dead_letter": deadLetter,
+	})
+}
+
+func (h *Handler) replayDeadLetter(c *gin.Context) {
+	id, ok := deadLetterID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.deadLetterService.Replay(c.Request.Context(), id); err != nil {
+		h.deadLetterError(c, "Failed to replay dead letter", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Dead letter queued for replay",
+		"id":      id,
+	})
+}
+
+func (h *Handler) replayDeadLetters(c *gin.Context) {
+	var req replayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if req.All == (len(req.IDs) > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   `Provide either "ids" or "all": true`,
+		})
+		return
+	}
+
+	if req.All {
+		replayed, err := h.deadLetterService.ReplayAll(c.Request.Context(), req.MessageType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success":  false,
+				"error":    "Failed to replay dead letters",
+				"details":  err.Error(),
+				"replayed": replayed,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"replayed": replayed,
+		})
+		return
+	}
+
+	replayed, err := h.deadLetterService.ReplayMany(c.Request.Context(), req.IDs)
+	if err != nil {
+		c.JSON(deadLetterStatus(err), gin.H{
+			"success":      false,
+			"error":        "Failed to replay dead letters",
+			"details":      err.Error(),
+			"replayed_ids": replayed,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"replayed":     len(replayed),
+		"replayed_ids": replayed,
+	})
+}
+
+func (h *Handler) deleteDeadLetter(c *gin.Context) {
+	id, ok := deadLetterID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.deadLetterService.Delete(c.Request.Context(), id); err != nil {
+		h.deadLetterError(c, "Failed to delete dead letter", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Dead letter deleted",
+		"id":      id,
+	})
+}
+
+func (h *Handler) purgeDeadLetters(c *gin.Context) {
+	purged, err := h.deadLetterService.Purge(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to purge dead letters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"purged":  purged,
+	})
+}
+
+func (h *Handler) deadLetterError(c *gin.Context, message string, err error) {
+	c.JSON(deadLetterStatus(err), gin.H{
+		"success": false,
+		"error":   message,
+		"details": err.Error(),
+	})
+}
+
+func deadLetterStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrDeadLetterNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrNotReplayable):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func deadLetterID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid dead letter ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+func queryInt(c *gin.Context, key string, defaultValue int) (int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -10,12 +11,16 @@ import (
 )
 
 type Handler struct {
-	checkinService *service.CheckinService
+	checkinService        *service.CheckinService
+	deadLetterService     *service.DeadLetterService
+	reconciliationService *service.ReconciliationService
 }
 
-func NewHandler(checkinService *service.CheckinService) *Handler {
+func NewHandler(checkinService *service.CheckinService, deadLetterService *service.DeadLetterService, reconciliationService *service.ReconciliationService) *Handler {
 	return &Handler{
-		checkinService: checkinService,
+		checkinService:        checkinService,
+		deadLetterService:     deadLetterService,
+		reconciliationService: reconciliationService,
 	}
 }
 
@@ -38,7 +43,21 @@ func (h *Handler) SetupRoutes() *gin.Engine {
 	{
 		api.POST("/checkin", h.checkin)
 		api.GET("/employee/:id/status", h.getEmployeeStatus)
+		api.GET("/employee/:id/preferences", h.getEmployeePreferences)
+		api.PUT("/employee/:id/preferences", h.setEmployeePreferences)
 		api.GET("/queue/status", h.getQueueStatus)
+
+		// Dead-letter administration
+		api.GET("/queue/dead-letters", h.listDeadLetters)
+		api.DELETE("/queue/dead-letters", h.purgeDeadLetters)
+		api.POST("/queue/dead-letters/replay", h.replayDeadLetters)
+		api.GET("/queue/dead-letters/:id", h.getDeadLetter)
+		api.DELETE("/queue/dead-letters/:id", h.deleteDeadLetter)
+		api.POST("/queue/dead-letters/:id/replay", h.replayDeadLetter)
+
+		// Labor cost report reconciliation
+		api.GET("/reports/reconciliation", h.getReconciliation)
+		api.POST("/reports/reconciliation", h.runReconciliation)
 	}
 
 	return router
@@ -66,8 +85,18 @@ func (h *Handler) checkin(c *gin.Context) {
 	}
 
 	// Process the checkin/checkout
-	response, err := h.checkinService.ProcessCheckin(req.EmployeeID)
+	response, err := h.checkinService.ProcessCheckin(c.Request.Context(), req.EmployeeID)
 	if err != nil {
+		var conflict *service.ConflictError
+		if errors.As(err, &conflict) {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"error":   "Check-in already in progress",
+				"details": err.Error(),
+			})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
 			"error":   "Failed to process checkin",
@@ -90,7 +119,7 @@ func (h *Handler) getEmployeeStatus(c *gin.Context) {
 		return
 	}
 
-	session, err := h.checkinService.GetEmployeeStatus(employeeID)
+	session, err := h.checkinService.GetEmployeeStatus(c.Request.Context(), employeeID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -117,19 +146,30 @@ func (h *Handler) getEmployeeStatus(c *gin.Context) {
 }
 
 func (h *Handler) getQueueStatus(c *gin.Context) {
-	status := h.checkinService.GetQueueStatus()
+	status := h.checkinService.GetQueueStatus(c.Request.Context())
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"queue":   status,
 	})
 }
 
+// healthCheck stays 200 while the queue is down: check-ins are still
+// accepted and their messages wait in the outbox until it is back.
 func (h *Handler) healthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"success": true,
 		"message": "Factory Check-in API is running",
 		"version": "1.0.0",
-	})
+		"queue":   "connected",
+	}
+
+	if err := h.checkinService.QueueHealth(); err != nil {
+		response["message"] = "Factory Check-in API is running without its queue"
+		response["queue"] = "unavailable"
+		response["details"] = err.Error()
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *Handler) corsMiddleware() gin.HandlerFunc {
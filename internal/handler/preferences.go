@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// A BCP 47 language tag such as "en", "de-AT" or "zh-Hant-TW". Underscores
+// are accepted as separators too, as in "de_AT".
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}([_-][A-Za-z0-9]{1,8})*$`)
+
+func (h *Handler) getEmployeePreferences(c *gin.Context) {
+	employeeID := c.Param("id")
+
+	if strings.TrimSpace(employeeID) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Employee ID is required",
+		})
+		return
+	}
+
+	prefs, err := h.checkinService.GetEmployeePreferences(c.Request.Context(), employeeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get employee preferences",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Emails then use EMAIL_DEFAULT_LOCALE
+	if prefs == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "No preferences set for employee",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"preferences": prefs,
+	})
+}
+
+func (h *Handler) setEmployeePreferences(c *gin.Context) {
+	employeeID := c.Param("id")
+
+	if strings.TrimSpace(employeeID) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Employee ID is required",
+		})
+		return
+	}
+
+	var req model.PreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	locale := strings.TrimSpace(req.Locale)
+	if !localePattern.MatchString(locale) || len(locale) > 35 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "locale must be a language tag such as en or de-AT",
+		})
+		return
+	}
+
+	prefs := &model.EmployeePreferences{EmployeeID: employeeID, Locale: locale}
+	if err := h.checkinService.SetEmployeePreferences(c.Request.Context(), prefs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to save employee preferences",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"preferences": prefs,
+	})
+}
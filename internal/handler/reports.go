@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Longest date range a reconciliation may cover, to keep the query bounded.
+const maxReconciliationDays = 31
+
+// getReconciliation lists the sessions between the from and to dates
+// (YYYY-MM-DD, both default to today) whose labor cost report the legacy
+// system hasn't acknowledged.
+func (h *Handler) getReconciliation(c *gin.Context) {
+	h.reconcile(c, false)
+}
+
+// runReconciliation is getReconciliation that also re-enqueues the reports
+// that were never sent.
+func (h *Handler) runReconciliation(c *gin.Context) {
+	h.reconcile(c, true)
+}
+
+func (h *Handler) reconcile(c *gin.Context, requeue bool) {
+	from, to, ok := dateRange(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.reconciliationService.Reconcile(c.Request.Context(), from, to, requeue)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to reconcile labor cost reports",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"reconciliation": report,
+	})
+}
+
+// dateRange parses the from and to query parameters, writing a 400 response
+// if they are invalid.
+func dateRange(c *gin.Context) (from, to time.Time, ok bool) {
+	today := time.Now().In(time.Local).Format("2006-01-02")
+
+	to, err := time.ParseInLocation("2006-01-02", c.DefaultQuery("to", today), time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "to must be a date in YYYY-MM-DD format",
+		})
+		return from, to, false
+	}
+
+	from, err = time.ParseInLocation("2006-01-02", c.DefaultQuery("from", to.Format("2006-01-02")), time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "from must be a date in YYYY-MM-DD format",
+		})
+		return from, to, false
+	}
+
+	if to.Before(from) || from.AddDate(0, 0, maxReconciliationDays-1).Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "from must not be after to, and the range must not exceed 31 days",
+		})
+		return from, to, false
+	}
+
+	return from, to, true
+}
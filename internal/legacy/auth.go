@@ -0,0 +1,220 @@
+package legacy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// Tokens are refreshed this long before they expire, so a token never runs
+// out between being attached to a request and the request arriving.
+const tokenExpiryMargin = 30 * time.Second
+
+// Authenticator adds credentials to a request to the legacy API.
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// tokenInvalidator is implemented by authenticators whose credentials can go
+// stale. Invalidate is called with the token of a request the legacy API
+// answered with 401, so the next request fetches fresh credentials.
+type tokenInvalidator interface {
+	Invalidate(token string)
+}
+
+// newAuthenticator returns the Authenticator for the configured scheme.
+// httpClient is used to fetch OAuth2 tokens.
+func newAuthenticator(auth config.LegacyAuth, httpClient *http.Client) (Authenticator, error) {
+	switch auth.Scheme {
+	case "", "none":
+		return noAuth{}, nil
+	case "api_key":
+		if auth.APIKeyHeader == "" || auth.APIKey == "" {
+			return nil, fmt.Errorf("api_key auth needs LEGACY_API_KEY_HEADER and LEGACY_API_KEY")
+		}
+		return apiKeyAuth{header: auth.APIKeyHeader, key: auth.APIKey}, nil
+	case "basic":
+		if auth.Username == "" {
+			return nil, fmt.Errorf("basic auth needs LEGACY_API_USERNAME")
+		}
+		return basicAuth{username: auth.Username, password: auth.Password}, nil
+	case "oauth2":
+		if auth.TokenURL == "" || auth.ClientID == "" {
+			return nil, fmt.Errorf("oauth2 auth needs LEGACY_OAUTH_TOKEN_URL and LEGACY_OAUTH_CLIENT_ID")
+		}
+		return &clientCredentials{
+			tokenURL:     auth.TokenURL,
+			clientID:     auth.ClientID,
+			clientSecret: auth.ClientSecret,
+			scopes:       auth.Scopes,
+			httpClient:   httpClient,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown LEGACY_AUTH_SCHEME %q (expected none, api_key, basic or oauth2)", auth.Scheme)
+	}
+}
+
+type noAuth struct{}
+
+func (noAuth) Authenticate(req *http.Request) error {
+	return nil
+}
+
+type apiKeyAuth struct {
+	header string
+	key    string
+}
+
+func (a apiKeyAuth) Authenticate(req *http.Request) error {
+	req.Header.Set(a.header, a.key)
+	return nil
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a basicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+// clientCredentials authenticates with a bearer token from an OAuth2 token
+// endpoint (RFC 6749 section 4.4). The token is cached until shortly before
+// it expires; concurrent requests share a single fetch.
+type clientCredentials struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	httpClient   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time // zero if the token doesn't expire
+}
+
+func (c *clientCredentials) Authenticate(req *http.Request) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == "" || (!c.expires.IsZero() && time.Now().After(c.expires)) {
+		if err := c.fetch(req); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	return nil
+}
+
+// Invalidate drops the cached token if it is still token. One that another
+// request fetched meanwhile is kept, so concurrent 401s don't throw away
+// each other's fresh tokens.
+func (c *clientCredentials) Invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// fetch gets a new token, bounded by the context of the request that needs
+// it. c.mu must be held.
+func (c *clientCredentials) fetch(req *http.Request) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.scopes) > 0 {
+		form.Set("scope", strings.Join(c.scopes, " "))
+	}
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create token request: %w", err)
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.Header.Set("Accept", "application/json")
+	tokenReq.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+
+	resp, err := c.httpClient.Do(tokenReq)
+	if err != nil {
+		return fmt.Errorf("failed to fetch legacy API token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("failed to fetch legacy API token: token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode legacy API token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("token endpoint returned no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return fmt.Errorf("unsupported token type %q", token.TokenType)
+	}
+
+	c.token = token.AccessToken
+	c.expires = time.Time{}
+	if token.ExpiresIn > 0 {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		if lifetime > 2*tokenExpiryMargin {
+			lifetime -= tokenExpiryMargin
+		} else {
+			lifetime /= 2
+		}
+		c.expires = time.Now().Add(lifetime)
+	}
+	return nil
+}
+
+// newTransport returns the transport for legacy API calls, presenting a
+// client certificate when mutual TLS is configured.
+func newTransport(auth config.LegacyAuth) (http.RoundTripper, error) {
+	if auth.TLSCertFile == "" && auth.TLSKeyFile == "" && auth.TLSCAFile == "" {
+		return http.DefaultTransport, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if auth.TLSCertFile != "" || auth.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(auth.TLSCertFile, auth.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load legacy API client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if auth.TLSCAFile != "" {
+		pem, err := os.ReadFile(auth.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read legacy API CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", auth.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
@@ -0,0 +1,236 @@
+package legacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// tokenServer is an OAuth2 token endpoint that hands out token-1, token-2,
+// ... each valid for expiresIn seconds.
+type tokenServer struct {
+	expiresIn int
+
+	mu      sync.Mutex
+	fetches int
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != "factory" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "labor.write" {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	s.fetches++
+	token := fmt.Sprintf("token-%d", s.fetches)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"access_token": token, "token_type": "Bearer", "expires_in": s.expiresIn})
+}
+
+func (s *tokenServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func newTokenServer(t *testing.T, expiresIn int) (*tokenServer, string) {
+	t.Helper()
+	srv := &tokenServer{expiresIn: expiresIn}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return srv, ts.URL
+}
+
+func oauthConfig(tokenURL string) config.LegacyAuth {
+	return config.LegacyAuth{
+		Scheme:       "oauth2",
+		TokenURL:     tokenURL,
+		ClientID:     "factory",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"labor.write"},
+	}
+}
+
+// authorize runs auth on a new request and returns its Authorization header.
+func authorize(t *testing.T, auth Authenticator) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if err := auth.Authenticate(req); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	return req.Header.Get("Authorization")
+}
+
+func TestClientCredentialsRefresh(t *testing.T) {
+	tokens, tokenURL := newTokenServer(t, 3600)
+	auth, err := newAuthenticator(oauthConfig(tokenURL), http.DefaultClient)
+	if err != nil {
+		t.Fatalf("newAuthenticator: %v", err)
+	}
+	c := auth.(*clientCredentials)
+
+	if got := authorize(t, c); got != "Bearer token-1" {
+		t.Fatalf("Authorization = %q, want Bearer token-1", got)
+	}
+	if got := authorize(t, c); got != "Bearer token-1" || tokens.count() != 1 {
+		t.Fatalf("Authorization = %q after %d fetches, want the cached token-1", got, tokens.count())
+	}
+
+	// At expiry a new token is fetched
+	c.expires = time.Now().Add(-time.Millisecond)
+	if got := authorize(t, c); got != "Bearer token-2" {
+		t.Fatalf("Authorization after expiry = %q, want Bearer token-2", got)
+	}
+
+	// After a 401 the token is dropped, expired or not
+	c.Invalidate("token-2")
+	if got := authorize(t, c); got != "Bearer token-3" || tokens.count() != 3 {
+		t.Fatalf("Authorization after Invalidate = %q after %d fetches, want Bearer token-3", got, tokens.count())
+	}
+
+	// A 401 for a token that was already replaced keeps the new one
+	c.Invalidate("token-2")
+	if got := authorize(t, c); got != "Bearer token-3" || tokens.count() != 3 {
+		t.Fatalf("Authorization after a stale Invalidate = %q after %d fetches, want the cached token-3", got, tokens.count())
+	}
+}
+
+func TestClientCredentialsExpiry(t *testing.T) {
+	tests := []struct {
+		expiresIn int
+		want      time.Duration // zero if the token never expires
+	}{
+		{3600, 3600*time.Second - tokenExpiryMargin},
+		{40, 20 * time.Second}, // too short for the margin: refreshed halfway
+		{0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.expiresIn), func(t *testing.T) {
+			_, tokenURL := newTokenServer(t, tt.expiresIn)
+			auth, err := newAuthenticator(oauthConfig(tokenURL), http.DefaultClient)
+			if err != nil {
+				t.Fatalf("newAuthenticator: %v", err)
+			}
+			c := auth.(*clientCredentials)
+			authorize(t, c)
+
+			if tt.want == 0 {
+				if !c.expires.IsZero() {
+					t.Fatalf("expires = %s, want a token that never expires", c.expires)
+				}
+				return
+			}
+			if got := time.Until(c.expires); got > tt.want || got < tt.want-time.Second {
+				t.Fatalf("token refreshed in %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportHoursRetriesAfter401(t *testing.T) {
+	tokens, tokenURL := newTokenServer(t, 3600)
+
+	var mu sync.Mutex
+	accepted := "token-2" // the legacy API only accepts this token
+	var seen []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer "+accepted {
+			http.Error(w, "token revoked", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"status": "accepted"}`))
+	}))
+	t.Cleanup(api.Close)
+
+	client, err := NewLegacyAPIClient(&config.Config{
+		LegacyAPIURL: api.URL,
+		LegacyAuth:   oauthConfig(tokenURL),
+		Timeouts:     config.Timeouts{LegacyAPI: 5 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("NewLegacyAPIClient: %v", err)
+	}
+
+	if err := client.ReportHours(context.Background(), testReport); err != nil {
+		t.Fatalf("ReportHours() = %v, want success with a fresh token", err)
+	}
+	mu.Lock()
+	if len(seen) != 2 || seen[0] != "Bearer token-1" || seen[1] != "Bearer token-2" || tokens.count() != 2 {
+		t.Fatalf("requests = %v after %d token fetches, want token-1 then token-2", seen, tokens.count())
+	}
+	// A 401 with a fresh token is the legacy API's final answer
+	accepted, seen = "none", nil
+	mu.Unlock()
+
+	err = client.ReportHours(context.Background(), testReport)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("ReportHours() = %v, want a 401", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 2 {
+		t.Fatalf("sent %d requests, want one retry after the 401", len(seen))
+	}
+}
+
+func TestConcurrent401sFetchOneToken(t *testing.T) {
+	tokens, tokenURL := newTokenServer(t, 3600)
+
+	// Both requests with token-1 are answered together, once both arrived
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			arrived.Done()
+			arrived.Wait()
+			http.Error(w, "token revoked", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"status": "accepted"}`))
+	}))
+	t.Cleanup(api.Close)
+
+	client, err := NewLegacyAPIClient(&config.Config{
+		LegacyAPIURL: api.URL,
+		LegacyAuth:   oauthConfig(tokenURL),
+		Timeouts:     config.Timeouts{LegacyAPI: 5 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("NewLegacyAPIClient: %v", err)
+	}
+	// Fetch token-1 up front so both requests start with it
+	authorize(t, client.auth)
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.ReportHours(context.Background(), testReport); err != nil {
+				t.Errorf("ReportHours() = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The second 401 must not drop the token fetched after the first
+	if n := tokens.count(); n != 2 {
+		t.Fatalf("%d token fetches, want 2", n)
+	}
+}
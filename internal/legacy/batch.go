@@ -0,0 +1,74 @@
+package legacy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// BulkRequest is the body of a bulk labor cost request.
+type BulkRequest struct {
+	Reports []model.LaborCostReport `json:"reports"`
+}
+
+// BulkResponse is the legacy API's answer to a bulk request: one result per
+// report, in request order.
+type BulkResponse struct {
+	Results []BulkResult `json:"results"`
+}
+
+// BulkResult is the outcome of one report in a bulk request. Status is an
+// HTTP status code, classified like the status of a single report.
+type BulkResult struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReportHoursBatch posts reports to LEGACY_BULK_API_URL in one request and
+// returns one error per report, in order. If the request as a whole fails,
+// every report gets its error.
+func (l *LegacyAPIClient) ReportHoursBatch(ctx context.Context, reports []model.LaborCostReport) []error {
+	errs := make([]error, len(reports))
+	fail := func(err error) []error {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	jsonData, err := json.Marshal(BulkRequest{Reports: reports})
+	if err != nil {
+		return fail(fmt.Errorf("failed to marshal reports: %w", err))
+	}
+
+	// Each report carries its own idempotency key in the body
+	body, err := l.call(ctx, l.config.LegacyBulkAPIURL, jsonData, "")
+	if err != nil {
+		return fail(err)
+	}
+
+	var resp BulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fail(fmt.Errorf("failed to decode legacy API bulk response: %w", err))
+	}
+
+	accepted := 0
+	for i := range reports {
+		if i >= len(resp.Results) {
+			errs[i] = fmt.Errorf("legacy API returned no result for report %d of %d", i+1, len(reports))
+			continue
+		}
+		result := resp.Results[i]
+		if result.Status >= 200 && result.Status < 300 {
+			accepted++
+			continue
+		}
+		errs[i] = &APIError{StatusCode: result.Status, Body: result.Error}
+	}
+
+	log.Printf("Reported %d of %d labor cost reports to legacy API in bulk", accepted, len(reports))
+	return errs
+}
@@ -0,0 +1,98 @@
+package legacy
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// CircuitOpenError is returned without calling the legacy API while the
+// circuit breaker is open. The worker postpones the message until RetryAfter
+// without using up one of its attempts.
+type CircuitOpenError struct {
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("legacy API circuit open until %s", e.Until.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) RetryAfter() time.Duration {
+	return time.Until(e.Until)
+}
+
+// breaker stops calls to the legacy API after threshold consecutive failures.
+// Once openFor has passed it lets a single trial call through: success closes
+// the circuit again, failure reopens it for another openFor.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	openFor   time.Duration
+	failures  int
+	openUntil time.Time // zero while closed
+	probing   bool      // a trial call is in flight
+}
+
+// newBreaker returns a breaker that never opens if threshold is 0.
+func newBreaker(threshold int, openFor time.Duration) *breaker {
+	return &breaker{threshold: threshold, openFor: openFor}
+}
+
+// allow returns nil if a call may go ahead and a *CircuitOpenError if not.
+// Every allowed call must be followed by success, failure or abandon.
+func (b *breaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return &CircuitOpenError{Until: b.openUntil}
+	}
+	if b.probing {
+		// Don't pile onto a system that may still be down; ask again
+		// once the trial has had time to finish
+		return &CircuitOpenError{Until: now.Add(b.openFor)}
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.openUntil.IsZero() {
+		log.Println("Legacy API circuit closed")
+	}
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.threshold > 0 && (b.probing || b.failures >= b.threshold) {
+		b.openUntil = time.Now().Add(b.openFor)
+		b.probing = false
+		log.Printf("Legacy API circuit open for %s after %d consecutive failures", b.openFor, b.failures)
+	}
+}
+
+// abandon is for calls that ended without telling us anything about the
+// legacy system, e.g. because the worker was shutting down.
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
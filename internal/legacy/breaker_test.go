@@ -0,0 +1,84 @@
+package legacy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	const openFor = 50 * time.Millisecond
+	client, srv := newTestClient(t, 2, openFor)
+	ctx := context.Background()
+
+	assertOpen := func(t *testing.T) {
+		t.Helper()
+		before := srv.count()
+		var open *CircuitOpenError
+		if err := client.ReportHours(ctx, testReport); !errors.As(err, &open) {
+			t.Fatalf("ReportHours() = %v, want a *CircuitOpenError", err)
+		}
+		if srv.count() != before {
+			t.Fatal("an open circuit let a request through")
+		}
+	}
+
+	// Closed: failures below the threshold, and rejected reports, don't open
+	// the circuit
+	srv.script(response{status: http.StatusInternalServerError}, response{status: http.StatusUnprocessableEntity}, response{status: http.StatusInternalServerError})
+	for range 3 {
+		if err := client.ReportHours(ctx, testReport); err == nil {
+			t.Fatal("ReportHours() succeeded, want the scripted failure")
+		}
+	}
+	if err := client.ReportHours(ctx, testReport); err != nil {
+		t.Fatalf("ReportHours() = %v, want the circuit still closed", err)
+	}
+
+	// Open: two consecutive failures
+	srv.script(response{status: http.StatusBadGateway}, response{status: http.StatusServiceUnavailable})
+	client.ReportHours(ctx, testReport)
+	client.ReportHours(ctx, testReport)
+	assertOpen(t)
+
+	// Half-open: one trial call after openFor; while it is in flight other
+	// calls are still refused
+	time.Sleep(openFor)
+	if err := client.breaker.allow(); err != nil {
+		t.Fatalf("allow() after openFor = %v, want a trial call", err)
+	}
+	assertOpen(t)
+	client.breaker.abandon()
+
+	// A failed trial opens the circuit again
+	srv.script(response{status: http.StatusInternalServerError})
+	if err := client.ReportHours(ctx, testReport); err == nil {
+		t.Fatal("trial call succeeded, want the scripted failure")
+	}
+	assertOpen(t)
+
+	// A successful trial closes it
+	time.Sleep(openFor)
+	if err := client.ReportHours(ctx, testReport); err != nil {
+		t.Fatalf("trial call = %v", err)
+	}
+	srv.script(response{status: http.StatusInternalServerError})
+	if err := client.ReportHours(ctx, testReport); err == nil {
+		t.Fatal("ReportHours() succeeded, want the scripted failure")
+	}
+	if err := client.ReportHours(ctx, testReport); err != nil {
+		t.Fatalf("ReportHours() = %v, want the circuit closed after one failure", err)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(0, time.Minute)
+	for range 10 {
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() = %v, want a disabled breaker to never open", err)
+		}
+		b.failure()
+	}
+}
@@ -1,52 +1,165 @@
 package legacy
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/omaaartamer/factory-checkin-api/internal/model"
 	"github.com/omaaartamer/factory-checkin-api/pkg/config"
 )
 
+// How much of an error response is kept for the error message, and how much
+// of a successful one is read.
+const (
+	maxErrorBody    = 1024
+	maxResponseBody = 1 << 20
+)
+
 type LegacyAPIClient struct {
 	config     *config.Config
 	httpClient *http.Client
+	auth       Authenticator
+	breaker    *breaker
 }
 
-func NewLegacyAPIClient(cfg *config.Config) *LegacyAPIClient {
-	return &LegacyAPIClient{
-		config: cfg,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+// NewLegacyAPIClient fails if the configured credentials are incomplete or
+// the mutual TLS certificates can't be loaded.
+func NewLegacyAPIClient(cfg *config.Config) (*LegacyAPIClient, error) {
+	transport, err := newTransport(cfg.LegacyAuth)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeouts.LegacyAPI,
 	}
-}
 
-func (l *LegacyAPIClient) ReportHours(employeeID string, hoursWorked float64, date string) error {
-	report := model.LaborCostReport{
-		EmployeeID:  employeeID,
-		HoursWorked: hoursWorked,
-		Date:        date,
+	auth, err := newAuthenticator(cfg.LegacyAuth, httpClient)
+	if err != nil {
+		return nil, err
 	}
 
+	return &LegacyAPIClient{
+		config:     cfg,
+		httpClient: httpClient,
+		auth:       auth,
+		breaker:    newBreaker(cfg.LegacyBreakerFailures, time.Duration(cfg.LegacyBreakerOpenSeconds)*time.Second),
+	}, nil
+}
+
+// ReportHours posts a labor cost report to LEGACY_API_URL with its
+// idempotency key in the Idempotency-Key header. Errors the legacy API won't
+// recover from are *APIError values whose Permanent method returns true.
+// A successful status with a body that isn't JSON is a retryable error and
+// counts as a failure towards the circuit breaker.
+// A 429 or 503 with a Retry-After header is a *ThrottledError, and while the
+// legacy API is failing the call returns *CircuitOpenError without being
+// attempted; the worker postpones the report for both.
+func (l *LegacyAPIClient) ReportHours(ctx context.Context, report model.LaborCostReport) error {
 	jsonData, err := json.Marshal(report)
 	if err != nil {
 		return fmt.Errorf("failed to marshal report: %w", err)
 	}
 
-	// Mock the legacy API call (in real system, this would call the actual legacy system)
-	log.Printf("MOCK LEGACY API CALL:")
-	log.Printf("URL: %s", l.config.LegacyAPIURL)
-	log.Printf("Employee: %s", employeeID)
-	log.Printf("Hours: %.2f", hoursWorked)
-	log.Printf("Date: %s", date)
-	log.Printf("Payload: %s", string(jsonData))
-
-	// Simulate network delay
-	time.Sleep(500 * time.Millisecond)
+	if _, err := l.call(ctx, l.config.LegacyAPIURL, jsonData, report.IdempotencyKey); err != nil {
+		return err
+	}
 
+	log.Printf("Reported %.2f hours for employee %s on %s to legacy API", report.HoursWorked, report.EmployeeID, report.Date)
 	return nil
 }
+
+func truncate(body []byte, n int) string {
+	if len(body) > n {
+		body = body[:n]
+	}
+	return string(body)
+}
+
+// call posts body to url through the circuit breaker and returns the
+// response body. The Idempotency-Key header is only sent if idempotencyKey
+// is set. A response body that isn't JSON is an error.
+func (l *LegacyAPIClient) call(ctx context.Context, url string, body []byte, idempotencyKey string) ([]byte, error) {
+	if err := l.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := l.post(ctx, url, body, idempotencyKey)
+	// A body that isn't JSON means something other than the legacy API
+	// answered, e.g. a proxy error page, so it counts against the breaker
+	if err == nil && len(bytes.TrimSpace(resp)) > 0 && !json.Valid(resp) {
+		err = fmt.Errorf("legacy API returned a malformed response: %q", truncate(resp, maxErrorBody))
+		resp = nil
+	}
+
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		l.breaker.success()
+	case errors.Is(err, context.Canceled):
+		l.breaker.abandon()
+	case errors.As(err, &apiErr) && apiErr.Permanent():
+		// The legacy API is up, it just doesn't like this request
+		l.breaker.success()
+	default:
+		l.breaker.failure()
+	}
+	return resp, err
+}
+
+// post sends body to url. If the legacy API rejects cached credentials, they
+// are dropped and the request is sent once more with fresh ones.
+func (l *LegacyAPIClient) post(ctx context.Context, url string, body []byte, idempotencyKey string) ([]byte, error) {
+	resp, authorization, err := l.send(ctx, url, body, idempotencyKey)
+
+	var apiErr *APIError
+	if invalidator, ok := l.auth.(tokenInvalidator); ok && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		invalidator.Invalidate(strings.TrimPrefix(authorization, "Bearer "))
+		resp, _, err = l.send(ctx, url, body, idempotencyKey)
+	}
+	return resp, err
+}
+
+// send makes one request and also returns the Authorization header it was
+// sent with.
+func (l *LegacyAPIClient) send(ctx context.Context, url string, body []byte, idempotencyKey string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create legacy API request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if err := l.auth.Authenticate(req); err != nil {
+		return nil, "", err
+	}
+	authorization := req.Header.Get("Authorization")
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, authorization, fmt.Errorf("legacy API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, authorization, responseError(resp, strings.TrimSpace(string(msg)))
+	}
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if err != nil {
+		return nil, authorization, fmt.Errorf("failed to read legacy API response: %w", err)
+	}
+	// Drain the rest so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	return respBody, authorization, nil
+}
@@ -0,0 +1,183 @@
+package legacy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+var testReport = model.LaborCostReport{
+	EmployeeID:     "EMP001",
+	HoursWorked:    8,
+	Date:           "2024-01-15",
+	IdempotencyKey: "work-session-1",
+}
+
+// response is one scripted answer of a scriptedServer.
+type response struct {
+	status     int
+	retryAfter string
+}
+
+// scriptedServer answers requests with its scripted responses in order, and
+// with 200 once they run out.
+type scriptedServer struct {
+	mu        sync.Mutex
+	responses []response
+	requests  int
+}
+
+func (s *scriptedServer) script(responses ...response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, responses...)
+}
+
+func (s *scriptedServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *scriptedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	resp := response{status: http.StatusOK}
+	if len(s.responses) > 0 {
+		resp = s.responses[0]
+		s.responses = s.responses[1:]
+	}
+	s.mu.Unlock()
+
+	if resp.retryAfter != "" {
+		w.Header().Set("Retry-After", resp.retryAfter)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	w.Write([]byte(`{"status": "accepted"}`))
+}
+
+// newTestClient returns a client for a scriptedServer whose breaker opens
+// after breakerFailures failures for openFor.
+func newTestClient(t *testing.T, breakerFailures int, openFor time.Duration) (*LegacyAPIClient, *scriptedServer) {
+	t.Helper()
+	srv := &scriptedServer{}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	client, err := NewLegacyAPIClient(&config.Config{
+		LegacyAPIURL: ts.URL,
+		Timeouts:     config.Timeouts{LegacyAPI: 5 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("NewLegacyAPIClient: %v", err)
+	}
+	client.breaker = newBreaker(breakerFailures, openFor)
+	return client, srv
+}
+
+func TestReportHoursClassification(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusUnauthorized, true},
+		{http.StatusNotFound, true},
+		{http.StatusConflict, true},
+		{http.StatusUnprocessableEntity, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusBadGateway, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			client, srv := newTestClient(t, 0, 0)
+			srv.script(response{status: tt.status})
+
+			err := client.ReportHours(context.Background(), testReport)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Fatalf("ReportHours() = %v, want an *APIError with status %d", err, tt.status)
+			}
+			if apiErr.Permanent() != tt.permanent {
+				t.Errorf("Permanent() = %v, want %v", apiErr.Permanent(), tt.permanent)
+			}
+			var throttled *ThrottledError
+			if errors.As(err, &throttled) {
+				t.Errorf("ReportHours() = %v, a throttle without a Retry-After header", err)
+			}
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		client, _ := newTestClient(t, 0, 0)
+		if err := client.ReportHours(context.Background(), testReport); err != nil {
+			t.Fatalf("ReportHours() = %v", err)
+		}
+	})
+}
+
+func TestReportHoursRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		want       time.Duration
+	}{
+		{"429 in seconds", http.StatusTooManyRequests, "120", 120 * time.Second},
+		{"503 in seconds", http.StatusServiceUnavailable, "30", 30 * time.Second},
+		{"503 as a date", http.StatusServiceUnavailable, time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 90 * time.Second},
+		{"date in the past", http.StatusTooManyRequests, time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+		{"capped", http.StatusTooManyRequests, "86400", maxRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, srv := newTestClient(t, 0, 0)
+			srv.script(response{status: tt.status, retryAfter: tt.retryAfter})
+
+			err := client.ReportHours(context.Background(), testReport)
+			var throttled *ThrottledError
+			if !errors.As(err, &throttled) {
+				t.Fatalf("ReportHours() = %v, want a *ThrottledError", err)
+			}
+			// HTTP dates have a resolution of one second
+			if got := throttled.RetryAfter(); got < tt.want-2*time.Second || got > tt.want {
+				t.Errorf("RetryAfter() = %s, want about %s", got, tt.want)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || apiErr.Permanent() {
+				t.Errorf("ReportHours() = %v, want a retryable *APIError with status %d", err, tt.status)
+			}
+		})
+	}
+
+	t.Run("ignored on other statuses", func(t *testing.T) {
+		client, srv := newTestClient(t, 0, 0)
+		srv.script(response{status: http.StatusInternalServerError, retryAfter: "60"})
+
+		var throttled *ThrottledError
+		if err := client.ReportHours(context.Background(), testReport); errors.As(err, &throttled) {
+			t.Fatalf("ReportHours() = %v, want a plain *APIError", err)
+		}
+	})
+
+	t.Run("unparsable", func(t *testing.T) {
+		client, srv := newTestClient(t, 0, 0)
+		srv.script(response{status: http.StatusServiceUnavailable, retryAfter: "soon"})
+
+		var throttled *ThrottledError
+		if err := client.ReportHours(context.Background(), testReport); errors.As(err, &throttled) {
+			t.Fatalf("ReportHours() = %v, want a plain *APIError", err)
+		}
+	})
+}
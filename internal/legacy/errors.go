@@ -0,0 +1,77 @@
+package legacy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Longest Retry-After that is honoured, so a misconfigured legacy API can't
+// park reports for days.
+const maxRetryAfter = time.Hour
+
+// APIError is an unsuccessful response from the legacy API.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("legacy API returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("legacy API returned %d: %s", e.StatusCode, e.Body)
+}
+
+// Permanent reports whether the legacy API rejected the report itself (4xx),
+// so sending it again would fail the same way. Request timeouts and rate
+// limiting are the exception, as are all server errors.
+func (e *APIError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// ThrottledError is a 429 or 503 response with a Retry-After header. The
+// worker postpones the message by RetryAfter without using up an attempt.
+type ThrottledError struct {
+	*APIError
+	Wait time.Duration
+}
+
+func (e *ThrottledError) Unwrap() error { return e.APIError }
+
+func (e *ThrottledError) RetryAfter() time.Duration { return e.Wait }
+
+// responseError returns the error for an unsuccessful response.
+func responseError(resp *http.Response, body string) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Body: body}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return apiErr
+	}
+	wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return apiErr
+	}
+	return &ThrottledError{APIError: apiErr, Wait: wait}
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		wait = at.Sub(now)
+	} else {
+		return 0, false
+	}
+	return min(max(wait, 0), maxRetryAfter), true
+}
@@ -0,0 +1,170 @@
+package legacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/payload"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/internal/worker"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// RegisterHandlers registers the worker handler that reports labor cost
+// reports to the legacy system, one at a time or, with LEGACY_BATCH_SIZE
+// above 1, in bulk. Deliveries are recorded in repo's legacy delivery ledger,
+// and reports the legacy system already acknowledged are not sent again.
+func RegisterHandlers(reg *worker.Registry, repo repository.Repository, cfg *config.Config) error {
+	client, err := NewLegacyAPIClient(cfg)
+	if err != nil {
+		return err
+	}
+	h := &laborCostHandler{client: client, repo: repo, dbTimeout: cfg.Timeouts.Database}
+
+	registration := worker.Registration{
+		Type:        payload.TypeLaborCostReport,
+		Handler:     h.handle,
+		Timeout:     cfg.Timeouts.LegacyAPI,
+		Concurrency: 4,
+	}
+	if cfg.LegacyBatchSize > 1 {
+		if cfg.LegacyBatchWindowMs <= 0 {
+			return fmt.Errorf("LEGACY_BATCH_WINDOW_MS must be positive when batching")
+		}
+		registration.Handler = nil
+		registration.BatchHandler = h.handleBatch
+		registration.BatchSize = cfg.LegacyBatchSize
+		registration.BatchWindow = time.Duration(cfg.LegacyBatchWindowMs) * time.Millisecond
+	}
+	reg.Register(registration)
+	return nil
+}
+
+type laborCostHandler struct {
+	client    *LegacyAPIClient
+	repo      repository.Repository
+	dbTimeout time.Duration
+}
+
+func (h *laborCostHandler) handle(ctx context.Context, msg *model.QueueMessage) error {
+	report, err := decodeReport(msg)
+	if err != nil {
+		return err
+	}
+
+	if delivered, err := h.delivered(ctx, report); err != nil || delivered {
+		return err
+	}
+
+	err = h.client.ReportHours(ctx, report)
+	h.record(msg, report, err)
+	return err
+}
+
+// handleBatch sends a batch of reports in one bulk request. Reports already
+// acknowledged, and messages whose payload can't be decoded, are settled on
+// their own and left out of the request.
+func (h *laborCostHandler) handleBatch(ctx context.Context, msgs []*model.QueueMessage) []error {
+	errs := make([]error, len(msgs))
+	reports := make([]model.LaborCostReport, 0, len(msgs))
+	sent := make([]int, 0, len(msgs)) // index in msgs of each report
+
+	for i, msg := range msgs {
+		report, err := decodeReport(msg)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if delivered, err := h.delivered(ctx, report); err != nil || delivered {
+			errs[i] = err
+			continue
+		}
+		reports = append(reports, report)
+		sent = append(sent, i)
+	}
+
+	if len(reports) > 0 {
+		for j, err := range h.client.ReportHoursBatch(ctx, reports) {
+			i := sent[j]
+			h.record(msgs[i], reports[j], err)
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// decodeReport decodes a labor cost report. Reports queued before schema
+// version 2 have no idempotency key; their message ID is just as stable.
+func decodeReport(msg *model.QueueMessage) (model.LaborCostReport, error) {
+	report, err := payload.Decode[model.LaborCostReport](msg)
+	if err != nil {
+		return report, worker.Permanent(err)
+	}
+	if report.IdempotencyKey == "" {
+		report.IdempotencyKey = "message-" + msg.ID
+	}
+	return report, nil
+}
+
+// delivered reports whether the legacy system already acknowledged the
+// report, e.g. because the queue redelivered a message whose acknowledgement
+// was lost.
+func (h *laborCostHandler) delivered(ctx context.Context, report model.LaborCostReport) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.dbTimeout)
+	defer cancel()
+
+	delivery, err := h.repo.GetLegacyDelivery(ctx, report.IdempotencyKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to check legacy delivery ledger: %w", err)
+	}
+	if delivery != nil && delivery.Status == "acknowledged" {
+		log.Printf("Labor cost report %s was already acknowledged, not sending it again", report.IdempotencyKey)
+		return true, nil
+	}
+	return false, nil
+}
+
+// record writes the outcome of a delivery attempt to the ledger. Calls that
+// never reached the legacy system are not attempts and aren't recorded. A
+// failure to record is only logged: the idempotency key still stops the
+// legacy system from counting a resent report twice.
+func (h *laborCostHandler) record(msg *model.QueueMessage, report model.LaborCostReport, sendErr error) {
+	var open *CircuitOpenError
+	if errors.As(sendErr, &open) || errors.Is(sendErr, context.Canceled) {
+		return
+	}
+
+	delivery := &model.LegacyDelivery{
+		IdempotencyKey: report.IdempotencyKey,
+		MessageID:      msg.ID,
+		EmployeeID:     report.EmployeeID,
+		HoursWorked:    report.HoursWorked,
+		WorkDate:       report.Date,
+		Status:         "acknowledged",
+	}
+	if report.SessionID != 0 {
+		delivery.SessionID = &report.SessionID
+	}
+	if sendErr != nil {
+		reason := sendErr.Error()
+		delivery.LastError = &reason
+		delivery.Status = "failed"
+		var apiErr *APIError
+		if errors.As(sendErr, &apiErr) && apiErr.Permanent() {
+			delivery.Status = "rejected"
+		}
+	}
+
+	// The handler's context may be about to expire; the record must still
+	// be written
+	ctx, cancel := context.WithTimeout(context.Background(), h.dbTimeout)
+	defer cancel()
+
+	if err := h.repo.RecordLegacyDelivery(ctx, delivery); err != nil {
+		log.Printf("Failed to record legacy delivery %s: %v", report.IdempotencyKey, err)
+	}
+}
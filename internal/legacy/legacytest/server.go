@@ -0,0 +1,304 @@
+// Package legacytest is a fake legacy labor-cost API for tests and local
+// runs. Server stores the reports it accepts so tests can assert on them,
+// ignores reports whose idempotency key it has already seen like the real
+// API, and can be scripted to add latency, fail with an error status, answer
+// with a malformed body or drop the connection.
+package legacytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/legacy"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// Paths served by Server, matching the default LEGACY_API_URL and
+// LEGACY_BULK_API_URL.
+const (
+	ReportPath = "/api/labor-cost"
+	BulkPath   = "/api/labor-cost/bulk"
+)
+
+// Fault changes how Server answers report requests. Faults are applied in
+// the order they were scripted, each to Times requests; a fault with Times 0
+// applies to every request until the faults are cleared.
+type Fault struct {
+	Latency time.Duration
+	// Status answers with this error status instead of handling the request
+	Status int
+	// Malformed answers with a body that isn't valid JSON, with Status or 200
+	Malformed bool
+	// Drop closes the connection without answering
+	Drop  bool
+	Times int
+}
+
+// Server is an http.Handler for the legacy API. The zero value is not
+// usable; create one with NewServer.
+type Server struct {
+	mux *http.ServeMux
+
+	mu         sync.Mutex
+	reports    []model.LaborCostReport
+	seen       map[string]bool // idempotency keys already accepted
+	requests   int
+	duplicates int
+	faults     []Fault
+}
+
+func NewServer() *Server {
+	s := &Server{seen: make(map[string]bool)}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST "+ReportPath, s.withFaults(s.report))
+	s.mux.HandleFunc("POST "+BulkPath, s.withFaults(s.bulk))
+
+	// Control endpoints for runs outside a Go test
+	s.mux.HandleFunc("GET /mock/reports", s.listReports)
+	s.mux.HandleFunc("DELETE /mock/reports", s.reset)
+	s.mux.HandleFunc("POST /mock/faults", s.scriptFaults)
+	s.mux.HandleFunc("DELETE /mock/faults", s.clearFaults)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Script appends faults to the ones still pending.
+func (s *Server) Script(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, faults...)
+}
+
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Reports returns the reports accepted so far, in the order they arrived.
+// Duplicates are not included.
+func (s *Server) Reports() []model.LaborCostReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]model.LaborCostReport(nil), s.reports...)
+}
+
+// Requests returns how many report requests were received, including the
+// ones answered by a fault.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Duplicates returns how many reports were ignored because their
+// idempotency key had already been accepted.
+func (s *Server) Duplicates() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.duplicates
+}
+
+// Reset forgets every report, idempotency key, count and fault.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports = nil
+	s.seen = make(map[string]bool)
+	s.requests = 0
+	s.duplicates = 0
+	s.faults = nil
+}
+
+// nextFault counts a report request and returns the fault that applies to
+// it, if any.
+func (s *Server) nextFault() (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	if len(s.faults) == 0 {
+		return Fault{}, false
+	}
+	fault := s.faults[0]
+	if fault.Times > 0 {
+		s.faults[0].Times--
+		if s.faults[0].Times == 0 {
+			s.faults = s.faults[1:]
+		}
+	}
+	return fault, true
+}
+
+func (s *Server) withFaults(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fault, ok := s.nextFault()
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		switch {
+		case fault.Drop:
+			if hijacker, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hijacker.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			// Can't drop the connection - the closest thing is an error
+			http.Error(w, "connection dropped", http.StatusBadGateway)
+		case fault.Malformed:
+			status := fault.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"results": [{"status": 2`)
+		case fault.Status != 0:
+			writeJSON(w, fault.Status, map[string]string{"error": http.StatusText(fault.Status)})
+		default:
+			next(w, r)
+		}
+	}
+}
+
+func (s *Server) report(w http.ResponseWriter, r *http.Request) {
+	var report model.LaborCostReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if report.IdempotencyKey == "" {
+		report.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+
+	status, reason := s.accept(report)
+	if reason != "" {
+		writeJSON(w, status, map[string]string{"error": reason})
+		return
+	}
+	writeJSON(w, status, map[string]string{"status": "accepted"})
+}
+
+func (s *Server) bulk(w http.ResponseWriter, r *http.Request) {
+	var req legacy.BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+
+	resp := legacy.BulkResponse{Results: make([]legacy.BulkResult, len(req.Reports))}
+	for i, report := range req.Reports {
+		status, reason := s.accept(report)
+		resp.Results[i] = legacy.BulkResult{Status: status, Error: reason}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// accept validates and stores one report, returning the status to answer
+// with and, if it was refused, why.
+func (s *Server) accept(report model.LaborCostReport) (int, string) {
+	if report.EmployeeID == "" || report.Date == "" {
+		return http.StatusUnprocessableEntity, "employee_id and date are required"
+	}
+	if report.HoursWorked < 0 {
+		return http.StatusUnprocessableEntity, "hours_worked must not be negative"
+	}
+	if _, err := time.Parse("2006-01-02", report.Date); err != nil {
+		return http.StatusUnprocessableEntity, "date must be in YYYY-MM-DD format"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key := report.IdempotencyKey; key != "" {
+		if s.seen[key] {
+			s.duplicates++
+			return http.StatusOK, ""
+		}
+		s.seen[key] = true
+	}
+	s.reports = append(s.reports, report)
+	return http.StatusOK, ""
+}
+
+func (s *Server) listReports(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"reports":    append([]model.LaborCostReport{}, s.reports...),
+		"requests":   s.requests,
+		"duplicates": s.duplicates,
+	})
+}
+
+func (s *Server) reset(w http.ResponseWriter, r *http.Request) {
+	s.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// faultRequest is the JSON form of a Fault.
+type faultRequest struct {
+	LatencyMs int  `json:"latency_ms"`
+	Status    int  `json:"status"`
+	Malformed bool `json:"malformed"`
+	Drop      bool `json:"drop"`
+	Times     int  `json:"times"`
+}
+
+func (s *Server) scriptFaults(w http.ResponseWriter, r *http.Request) {
+	var req []faultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expected a JSON array of faults: " + err.Error()})
+		return
+	}
+
+	faults := make([]Fault, len(req))
+	for i, f := range req {
+		if f.Status != 0 && (f.Status < 100 || f.Status > 599) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid status %d", f.Status)})
+			return
+		}
+		faults[i] = Fault{
+			Latency:   time.Duration(f.LatencyMs) * time.Millisecond,
+			Status:    f.Status,
+			Malformed: f.Malformed,
+			Drop:      f.Drop,
+			Times:     f.Times,
+		}
+	}
+	s.Script(faults...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) clearFaults(w http.ResponseWriter, r *http.Request) {
+	s.ClearFaults()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package legacytest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/legacy"
+	"github.com/omaaartamer/factory-checkin-api/internal/legacy/legacytest"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// newClient returns a client for a new fake server. configure, if given, can
+// change the client's config before it is created.
+func newClient(t *testing.T, configure ...func(*config.Config)) (*legacy.LegacyAPIClient, *legacytest.Server) {
+	t.Helper()
+	fake := legacytest.NewServer()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		LegacyAPIURL:     server.URL + legacytest.ReportPath,
+		LegacyBulkAPIURL: server.URL + legacytest.BulkPath,
+		Timeouts:         config.Timeouts{LegacyAPI: 5 * time.Second},
+	}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	client, err := legacy.NewLegacyAPIClient(cfg)
+	if err != nil {
+		t.Fatalf("NewLegacyAPIClient: %v", err)
+	}
+	return client, fake
+}
+
+func report(key string) model.LaborCostReport {
+	return model.LaborCostReport{EmployeeID: "EMP001", HoursWorked: 8, Date: "2024-01-15", IdempotencyKey: key}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault legacytest.Fault
+		check func(t *testing.T, err error)
+	}{
+		{"malformed", legacytest.Fault{Malformed: true, Times: 1}, func(t *testing.T, err error) {
+			var apiErr *legacy.APIError
+			if err == nil || errors.As(err, &apiErr) {
+				t.Fatalf("ReportHours() = %v, want a malformed response error", err)
+			}
+		}},
+		{"status", legacytest.Fault{Status: http.StatusBadGateway, Times: 1}, func(t *testing.T, err error) {
+			var apiErr *legacy.APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+				t.Fatalf("ReportHours() = %v, want a 502", err)
+			}
+		}},
+		{"drop", legacytest.Fault{Drop: true, Times: 1}, func(t *testing.T, err error) {
+			var apiErr *legacy.APIError
+			if err == nil || errors.As(err, &apiErr) {
+				t.Fatalf("ReportHours() = %v, want a connection error", err)
+			}
+		}},
+		{"latency", legacytest.Fault{Latency: 50 * time.Millisecond, Times: 1}, func(t *testing.T, err error) {
+			if err != nil {
+				t.Fatalf("ReportHours() = %v, want a slow success", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newClient(t)
+			fake.Script(tt.fault)
+
+			tt.check(t, client.ReportHours(context.Background(), report("work-session-1")))
+
+			// The fault was used up: the report goes through on the retry
+			if err := client.ReportHours(context.Background(), report("work-session-1")); err != nil {
+				t.Fatalf("retry = %v", err)
+			}
+			if got := fake.Reports(); len(got) != 1 || fake.Requests() != 2 {
+				t.Fatalf("server has %d reports after %d requests, want 1 after 2", len(got), fake.Requests())
+			}
+		})
+	}
+}
+
+func TestBulkMalformed(t *testing.T) {
+	client, fake := newClient(t)
+	fake.Script(legacytest.Fault{Malformed: true, Times: 1})
+
+	reports := []model.LaborCostReport{report("work-session-1"), report("work-session-2")}
+	for i, err := range client.ReportHoursBatch(context.Background(), reports) {
+		if err == nil {
+			t.Fatalf("report %d succeeded, want every report to fail on a malformed bulk response", i)
+		}
+	}
+	for i, err := range client.ReportHoursBatch(context.Background(), reports) {
+		if err != nil {
+			t.Fatalf("report %d on retry = %v", i, err)
+		}
+	}
+	if got := fake.Reports(); len(got) != 2 {
+		t.Fatalf("server has %d reports, want 2", len(got))
+	}
+}
+
+func TestMalformedOpensBreaker(t *testing.T) {
+	client, fake := newClient(t, func(cfg *config.Config) {
+		cfg.LegacyBreakerFailures = 2
+		cfg.LegacyBreakerOpenSeconds = 60
+	})
+	fake.Script(legacytest.Fault{Malformed: true, Times: 2})
+
+	if err := client.ReportHours(context.Background(), report("work-session-1")); err == nil {
+		t.Fatal("ReportHours() succeeded on a malformed response")
+	}
+	errs := client.ReportHoursBatch(context.Background(), []model.LaborCostReport{report("work-session-2")})
+	if errs[0] == nil {
+		t.Fatal("ReportHoursBatch() succeeded on a malformed response")
+	}
+
+	// Two malformed responses in a row open the breaker
+	var open *legacy.CircuitOpenError
+	if err := client.ReportHours(context.Background(), report("work-session-1")); !errors.As(err, &open) {
+		t.Fatalf("ReportHours() = %v, want the breaker open", err)
+	}
+	if fake.Requests() != 2 {
+		t.Fatalf("server got %d requests, want none after the breaker opened", fake.Requests())
+	}
+}
+
+func TestDuplicatesIgnored(t *testing.T) {
+	client, fake := newClient(t)
+	for range 2 {
+		if err := client.ReportHours(context.Background(), report("work-session-1")); err != nil {
+			t.Fatalf("ReportHours() = %v", err)
+		}
+	}
+	if len(fake.Reports()) != 1 || fake.Duplicates() != 1 {
+		t.Fatalf("server has %d reports and %d duplicates, want 1 and 1", len(fake.Reports()), fake.Duplicates())
+	}
+}
@@ -0,0 +1,79 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func migrationFS(files ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range files {
+		fsys["migrations/"+name] = &fstest.MapFile{Data: []byte("-- " + name)}
+	}
+	return fsys
+}
+
+func TestLoadOrdersByVersion(t *testing.T) {
+	migrations, err := Load(migrationFS(
+		"0010_later.up.sql",
+		"0002_second.up.sql",
+		"0002_second.down.sql",
+		"0001_first.up.sql",
+		"0001_first.down.sql",
+	))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	var got []int64
+	for _, m := range migrations {
+		got = append(got, m.Version)
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 10 {
+		t.Fatalf("versions = %v, want [1 2 10]", got)
+	}
+	if m := migrations[1]; m.Name != "second" || m.Up != "-- 0002_second.up.sql" || m.Down != "-- 0002_second.down.sql" {
+		t.Fatalf("migration 2 = %+v, want both scripts paired by version", m)
+	}
+	if migrations[2].Down != "" {
+		t.Fatalf("migration 10 down = %q, want none", migrations[2].Down)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"conflicting names", []string{"0001_first.up.sql", "0001_other.down.sql"}, "conflicting names"},
+		{"duplicate version", []string{"0001_first.up.sql", "01_first.up.sql"}, "more than one up script"},
+		{"missing up script", []string{"0001_first.up.sql", "0002_second.down.sql"}, "2_second has no up script"},
+		{"invalid name", []string{"0001-first.up.sql"}, "invalid migration file name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(migrationFS(tt.files...))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(embedded)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Fatalf("migration %d_%s out of sequence, want version %d", m.Version, m.Name, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
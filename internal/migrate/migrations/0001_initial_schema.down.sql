@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS work_sessions;
+DROP TABLE IF EXISTS checkin_events;
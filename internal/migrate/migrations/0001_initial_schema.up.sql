@@ -0,0 +1,48 @@
+-- Baseline schema. Uses IF NOT EXISTS so databases created by the old
+-- startup createTables step are adopted without changes.
+CREATE TABLE IF NOT EXISTS checkin_events (
+    id SERIAL PRIMARY KEY,
+    employee_id VARCHAR(50) NOT NULL,
+    event_type VARCHAR(20) NOT NULL CHECK (event_type IN ('checkin', 'checkout')),
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS work_sessions (
+    id SERIAL PRIMARY KEY,
+    employee_id VARCHAR(50) NOT NULL,
+    checkin_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    checkout_time TIMESTAMP WITH TIME ZONE,
+    hours_worked DECIMAL(5,2),
+    status VARCHAR(20) DEFAULT 'active' CHECK (status IN ('active', 'completed')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Racing swipes before per-employee locking could leave an employee with
+-- several active sessions, which the unique index below forbids. Closing
+-- them here would drop them from payroll without a checkout time, so stop
+-- and name the employees instead; someone has to decide what they worked.
+DO $$
+DECLARE
+    conflicting TEXT;
+BEGIN
+    SELECT string_agg(employee_id || ' (' || active || ' active sessions)', ', ' ORDER BY employee_id)
+    INTO conflicting
+    FROM (
+        SELECT employee_id, COUNT(*) AS active
+        FROM work_sessions
+        WHERE status = 'active'
+        GROUP BY employee_id
+        HAVING COUNT(*) > 1
+    ) duplicates;
+
+    IF conflicting IS NOT NULL THEN
+        RAISE EXCEPTION 'employees with more than one active work session: %. Check out or delete the extra sessions by hand, then apply migrations again', conflicting;
+    END IF;
+END
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_employee_events ON checkin_events(employee_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_employee_sessions ON work_sessions(employee_id, status);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_one_active_session ON work_sessions(employee_id) WHERE status = 'active';
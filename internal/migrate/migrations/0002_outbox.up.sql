@@ -0,0 +1,18 @@
+-- Queue messages written in the same transaction as the change that produced
+-- them. The outbox relay publishes unsent rows and stamps sent_at; a relay
+-- holding a row while it publishes it sets locked_until so no other relay
+-- picks the row up before then.
+CREATE TABLE outbox_messages (
+    id BIGSERIAL PRIMARY KEY,
+    message_id VARCHAR(64) NOT NULL,
+    message_type VARCHAR(50) NOT NULL,
+    body JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP WITH TIME ZONE,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_outbox_unsent ON outbox_messages(id) WHERE sent_at IS NULL;
+CREATE INDEX idx_outbox_sent_at ON outbox_messages(sent_at) WHERE sent_at IS NOT NULL;
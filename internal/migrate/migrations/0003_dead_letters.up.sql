@@ -0,0 +1,13 @@
+-- Messages that exhausted their retries, with the reason for the last
+-- failure, kept for inspection and replay.
+CREATE TABLE dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    message_id VARCHAR(64) NOT NULL,
+    message_type VARCHAR(50) NOT NULL,
+    body JSONB NOT NULL,
+    reason TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_dead_letters_type ON dead_letters(message_type, id);
@@ -0,0 +1,18 @@
+-- Jobs for the Postgres queue backend (QUEUE_BACKEND=postgres). A claimed job
+-- is hidden until locked_until and identified by its receipt until it is
+-- completed, retried or failed; failed jobs stay for inspection.
+CREATE TABLE queue_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    message_id VARCHAR(64) NOT NULL,
+    message_type VARCHAR(50) NOT NULL,
+    body JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    process_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    receipt VARCHAR(64),
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_queue_jobs_due ON queue_jobs(process_at, id) WHERE status = 'pending';
+CREATE UNIQUE INDEX idx_queue_jobs_receipt ON queue_jobs(receipt) WHERE receipt IS NOT NULL;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS legacy_deliveries;
@@ -0,0 +1,20 @@
+-- Ledger of labor cost reports delivered to the legacy system, keyed by the
+-- report's idempotency key, so a report redelivered by the queue after the
+-- legacy system acknowledged it is not sent again. A report is 'queued' while
+-- it waits for its first delivery attempt.
+CREATE TABLE legacy_deliveries (
+    idempotency_key VARCHAR(128) PRIMARY KEY,
+    message_id VARCHAR(64) NOT NULL,
+    employee_id VARCHAR(50) NOT NULL,
+    session_id INTEGER,
+    hours_worked DOUBLE PRECISION NOT NULL,
+    work_date VARCHAR(10) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('queued', 'acknowledged', 'failed', 'rejected')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    first_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    acknowledged_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_legacy_deliveries_session ON legacy_deliveries(session_id);
@@ -0,0 +1,2 @@
+-- Reconciliation looks up the sessions completed in a date range.
+CREATE INDEX IF NOT EXISTS idx_completed_sessions ON work_sessions(checkout_time) WHERE status = 'completed';
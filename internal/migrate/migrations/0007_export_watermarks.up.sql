@@ -0,0 +1,7 @@
+-- How far each flat-file exporter has got: sessions completed before
+-- exported_until have been written to a file.
+CREATE TABLE export_watermarks (
+    name VARCHAR(50) PRIMARY KEY,
+    exported_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,7 @@
+-- Per-employee settings, such as the language of their emails. Employees
+-- without a row use the defaults.
+CREATE TABLE employee_preferences (
+    employee_id VARCHAR(50) PRIMARY KEY,
+    locale VARCHAR(35) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
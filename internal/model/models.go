@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -25,16 +26,48 @@ type WorkSession struct {
 	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
 }
 
-// QueueMessage represents a message in our processing queue
+// QueueMessage represents a message in our processing queue. Payload is
+// encoded and decoded by the payload package according to Type and
+// SchemaVersion; messages from before payloads were versioned have no
+// SchemaVersion.
 type QueueMessage struct {
-	ID          string                 `json:"id"`
-	Type        string                 `json:"type"` // "labor_cost_report" or "email_notification"
-	Payload     map[string]interface{} `json:"payload"`
-	Attempts    int                    `json:"attempts"`
-	MaxAttempts int                    `json:"max_attempts"`
-	CreatedAt   time.Time              `json:"created_at"`
-	ProcessAt   time.Time              `json:"process_at"`
-	Status      string                 `json:"status"` // "pending", "processing", "completed", "failed"
+	ID            string          `json:"id"`
+	Type          string          `json:"type"` // "labor_cost_report" or "email_notification"
+	SchemaVersion int             `json:"schema_version,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	MaxAttempts   int             `json:"max_attempts"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ProcessAt     time.Time       `json:"process_at"`
+	Status        string          `json:"status"` // "pending", "processing", "completed", "failed"
+
+	// DeadLetterReason is set on a message the worker gave up on but couldn't
+	// store a dead letter for. When it comes back the worker only retries
+	// storing the dead letter; the message is not processed again.
+	DeadLetterReason string `json:"dead_letter_reason,omitempty"`
+
+	// Receipt identifies this delivery to the queue that dequeued the message.
+	// It is never serialized.
+	Receipt string `json:"-"`
+}
+
+// OutboxMessage represents a queue message stored alongside the business
+// change that produced it, waiting to be published by the outbox relay
+type OutboxMessage struct {
+	ID        int64        `json:"id"`
+	Message   QueueMessage `json:"message"`
+	Attempts  int          `json:"attempts"`
+	LastError *string      `json:"last_error,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	SentAt    *time.Time   `json:"sent_at,omitempty"`
+}
+
+// DeadLetter represents a message that exhausted its retries
+type DeadLetter struct {
+	ID       int64        `json:"id"`
+	Message  QueueMessage `json:"message"`
+	Reason   string       `json:"reason"`
+	FailedAt time.Time    `json:"failed_at"`
 }
 
 // CheckinRequest represents the API request for check-in/check-out
@@ -51,16 +84,85 @@ type CheckinResponse struct {
 	HoursWorked *float64  `json:"hours_worked,omitempty"`
 }
 
-// LaborCostReport represents the data sent to legacy system
+// LaborCostReport represents the data sent to legacy system. It is also the
+// payload of labor_cost_report messages; SessionID and IdempotencyKey were
+// added in schema version 2.
 type LaborCostReport struct {
-	EmployeeID  string  `json:"employee_id"`
-	HoursWorked float64 `json:"hours_worked"`
-	Date        string  `json:"date"`
+	EmployeeID     string  `json:"employee_id"`
+	HoursWorked    float64 `json:"hours_worked"`
+	Date           string  `json:"date"`
+	SessionID      int     `json:"session_id,omitempty"`
+	IdempotencyKey string  `json:"idempotency_key,omitempty"`
+}
+
+// LegacyDelivery records the delivery of one labor cost report to the legacy
+// system. Status is "queued" while a report re-enqueued by reconciliation
+// waits for its first attempt, "acknowledged" once the legacy system
+// accepted it, "failed" while it is being retried and "rejected" if the
+// legacy system refused it for good.
+type LegacyDelivery struct {
+	IdempotencyKey string     `json:"idempotency_key" db:"idempotency_key"`
+	MessageID      string     `json:"message_id" db:"message_id"`
+	EmployeeID     string     `json:"employee_id" db:"employee_id"`
+	SessionID      *int       `json:"session_id,omitempty" db:"session_id"`
+	HoursWorked    float64    `json:"hours_worked" db:"hours_worked"`
+	WorkDate       string     `json:"work_date" db:"work_date"`
+	Status         string     `json:"status" db:"status"`
+	Attempts       int        `json:"attempts" db:"attempts"`
+	LastError      *string    `json:"last_error,omitempty" db:"last_error"`
+	FirstAttemptAt time.Time  `json:"first_attempt_at" db:"first_attempt_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty" db:"acknowledged_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
 }
 
-// EmailNotification represents email data
+// ReportDiscrepancy is a completed work session whose labor cost report the
+// legacy system hasn't acknowledged. DeliveryStatus is nil if no delivery was
+// ever attempted or queued, otherwise "queued", "failed" or "rejected" as in
+// LegacyDelivery.
+type ReportDiscrepancy struct {
+	SessionID      int       `json:"session_id" db:"session_id"`
+	EmployeeID     string    `json:"employee_id" db:"employee_id"`
+	CheckoutTime   time.Time `json:"checkout_time" db:"checkout_time"`
+	HoursWorked    float64   `json:"hours_worked" db:"hours_worked"`
+	DeliveryStatus *string   `json:"delivery_status,omitempty" db:"delivery_status"`
+	Attempts       int       `json:"attempts" db:"attempts"`
+	LastError      *string   `json:"last_error,omitempty" db:"last_error"`
+	Requeued       bool      `json:"requeued" db:"-"`
+}
+
+// ReconciliationReport compares the sessions completed between From and To
+// (inclusive dates) with the labor cost reports the legacy system
+// acknowledged.
+type ReconciliationReport struct {
+	From            string              `json:"from"`
+	To              string              `json:"to"`
+	SessionsChecked int                 `json:"sessions_checked"`
+	Discrepancies   []ReportDiscrepancy `json:"discrepancies"`
+	Requeued        int                 `json:"requeued"`
+	GeneratedAt     time.Time           `json:"generated_at"`
+}
+
+// EmailNotification represents email data. It is also the payload of
+// email_notification messages; CheckinTime and CheckoutTime were added in
+// schema version 2.
 type EmailNotification struct {
-	EmployeeID  string  `json:"employee_id"`
-	HoursWorked float64 `json:"hours_worked"`
-	Date        string  `json:"date"`
+	EmployeeID   string     `json:"employee_id"`
+	HoursWorked  float64    `json:"hours_worked"`
+	Date         string     `json:"date"`
+	CheckinTime  *time.Time `json:"checkin_time,omitempty"`
+	CheckoutTime *time.Time `json:"checkout_time,omitempty"`
+}
+
+// EmployeePreferences holds per-employee settings. Locale selects the
+// language of the employee's emails, e.g. "en" or "de-AT".
+type EmployeePreferences struct {
+	EmployeeID string    `json:"employee_id" db:"employee_id"`
+	Locale     string    `json:"locale" db:"locale"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// PreferencesRequest represents the API request to change an employee's
+// preferences
+type PreferencesRequest struct {
+	Locale string `json:"locale" binding:"required"`
 }
@@ -0,0 +1,214 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// How often sent outbox rows older than the retention period are deleted.
+const purgeInterval = time.Hour
+
+// How many queue timeouts a claimed batch is leased for. Publishing stops
+// while at least one is left, so the lease can't run out mid-publish.
+const leaseQueueTimeouts = 3
+
+// The longest a message that failed to publish is held back. The delay starts
+// at the poll interval and doubles with each failed attempt.
+const maxFailedDelay = 5 * time.Minute
+
+// Relay publishes messages from the transactional outbox to the queue.
+//
+// Rows are claimed (leased) in one transaction, published with no
+// transaction open, and marked sent in a second one, so a slow broker never
+// holds up the checkouts writing to the outbox. If the process dies after
+// publishing but before marking, the lease runs out and the rows are
+// published again, so delivery is at-least-once: consumers must tolerate
+// duplicates.
+type Relay struct {
+	repo      repository.Repository
+	queue     queue.Queue
+	interval  time.Duration
+	batchSize int
+	retention time.Duration
+	timeouts  config.Timeouts
+	lease     time.Duration
+	started   atomic.Bool
+	stopOnce  sync.Once
+	stopChan  chan bool
+	doneChan  chan bool
+}
+
+func NewRelay(repo repository.Repository, q queue.Queue, cfg *config.Config) *Relay {
+	return &Relay{
+		repo:      repo,
+		queue:     q,
+		interval:  time.Duration(cfg.OutboxPollIntervalMs) * time.Millisecond,
+		batchSize: cfg.OutboxBatchSize,
+		retention: time.Duration(cfg.OutboxRetentionHours) * time.Hour,
+		timeouts:  cfg.Timeouts,
+		lease:     leaseQueueTimeouts * cfg.Timeouts.Queue,
+		stopChan:  make(chan bool),
+		doneChan:  make(chan bool),
+	}
+}
+
+func (r *Relay) Start() {
+	if r.started.Swap(true) {
+		return
+	}
+	log.Println("Outbox relay started - publishing pending messages...")
+
+	go func() {
+		defer close(r.doneChan)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		lastPurge := time.Now()
+
+		for {
+			select {
+			case <-r.stopChan:
+				// Publish what was written right before shutdown
+				r.drain()
+				log.Println("Outbox relay stopped")
+				return
+			case <-ticker.C:
+				r.drain()
+
+				if time.Since(lastPurge) >= purgeInterval {
+					r.purgeSent()
+					lastPurge = time.Now()
+				}
+			}
+		}
+	}()
+}
+
+// Stop signals the relay to stop and waits for it to publish the messages
+// still pending. It returns straight away if the relay was never started.
+func (r *Relay) Stop() {
+	if !r.started.Load() {
+		return
+	}
+	r.stopOnce.Do(func() { close(r.stopChan) })
+	<-r.doneChan
+}
+
+// RelayPending publishes one batch of unsent outbox messages and returns how
+// many were published. Publishing stops at the first failure so an
+// unavailable broker isn't hammered. The failed message is held back for a
+// while so the next pass starts with the messages behind it, and a message
+// the broker keeps refusing can't hold up the rest. Each publish is bounded
+// by the queue timeout.
+func (r *Relay) RelayPending(ctx context.Context) (int, error) {
+	var pending []model.OutboxMessage
+	err := r.repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		var err error
+		pending, err = tx.ClaimOutboxMessages(ctx, r.batchSize, r.lease)
+		return err
+	})
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+	leaseEnd := time.Now().Add(r.lease)
+
+	var sent, unpublished []int64
+	var failed model.OutboxMessage
+	var failure error
+	for i, item := range pending {
+		if time.Until(leaseEnd) < r.timeouts.Queue {
+			// Someone else may claim these soon; leave them to the next pass
+			for _, rest := range pending[i:] {
+				unpublished = append(unpublished, rest.ID)
+			}
+			break
+		}
+
+		msg := item.Message
+		if err := r.publish(ctx, &msg); err != nil {
+			log.Printf("Failed to publish outbox message %d (%s): %v", item.ID, msg.Type, err)
+			failed, failure = item, err
+			for _, rest := range pending[i+1:] {
+				unpublished = append(unpublished, rest.ID)
+			}
+			break
+		}
+		sent = append(sent, item.ID)
+	}
+
+	// Record what was published even if ctx was cancelled meanwhile, or it
+	// is published again once the lease runs out
+	markCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.timeouts.Database)
+	defer cancel()
+
+	err = r.repo.WithinTransaction(markCtx, func(tx repository.Tx) error {
+		for _, id := range sent {
+			if err := tx.MarkOutboxSent(markCtx, id); err != nil {
+				return err
+			}
+		}
+		if failure != nil {
+			if err := tx.MarkOutboxFailed(markCtx, failed.ID, failure.Error(), r.failedDelay(failed.Attempts)); err != nil {
+				return err
+			}
+		}
+		return tx.ReleaseOutboxMessages(markCtx, unpublished)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark %d published outbox messages: %w", len(sent), err)
+	}
+	return len(sent), nil
+}
+
+// drain keeps relaying while full batches come back. It runs to completion
+// even while stopping, so it isn't tied to a cancellable context.
+func (r *Relay) drain() {
+	for {
+		sent, err := r.RelayPending(context.Background())
+		if err != nil {
+			log.Printf("Outbox relay error: %v", err)
+		}
+		if err != nil || sent < r.batchSize {
+			return
+		}
+	}
+}
+
+// failedDelay returns how long to hold back a message that failed to publish
+// after attempts earlier attempts.
+func (r *Relay) failedDelay(attempts int) time.Duration {
+	delay := r.interval
+	for i := 0; i < attempts && delay < maxFailedDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxFailedDelay)
+}
+
+func (r *Relay) publish(ctx context.Context, msg *model.QueueMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Queue)
+	defer cancel()
+	return r.queue.Enqueue(ctx, msg)
+}
+
+func (r *Relay) purgeSent() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeouts.Database)
+	defer cancel()
+
+	purged, err := r.repo.PurgeSentOutbox(ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		log.Printf("Failed to purge sent outbox messages: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d sent outbox messages", purged)
+	}
+}
@@ -0,0 +1,171 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// gatedQueue blocks each Enqueue until the test lets it through, and can be
+// told to fail instead.
+type gatedQueue struct {
+	*queue.MemoryQueue
+	entered chan struct{}
+	release chan error
+}
+
+func (q *gatedQueue) Enqueue(ctx context.Context, msg *model.QueueMessage) error {
+	q.entered <- struct{}{}
+	if err := <-q.release; err != nil {
+		return err
+	}
+	return q.MemoryQueue.Enqueue(ctx, msg)
+}
+
+func newTestRelay(repo repository.Repository, q queue.Queue) *Relay {
+	cfg := config.Load()
+	cfg.OutboxBatchSize = 10
+	return NewRelay(repo, q, cfg)
+}
+
+func writeOutbox(t *testing.T, repo repository.Repository, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		msg, err := queue.CreateLaborCostMessage(i+1, "E1", 8, "2024-01-15")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.WithinTransaction(context.Background(), func(tx repository.Tx) error {
+			return tx.CreateOutboxMessage(context.Background(), msg)
+		})
+		if err != nil {
+			t.Fatalf("CreateOutboxMessage: %v", err)
+		}
+	}
+}
+
+func TestRelayPublishesOutsideTransaction(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	q := &gatedQueue{MemoryQueue: queue.NewMemoryQueue(), entered: make(chan struct{}), release: make(chan error)}
+	relay := newTestRelay(repo, q)
+	writeOutbox(t, repo, 1)
+
+	result := make(chan int)
+	go func() {
+		sent, err := relay.RelayPending(ctx)
+		if err != nil {
+			t.Errorf("RelayPending: %v", err)
+		}
+		result <- sent
+	}()
+	<-q.entered
+
+	// A checkout must not wait for the publish in progress
+	done := make(chan error)
+	go func() {
+		done <- repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+			return tx.CreateCheckinEvent(ctx, &model.CheckinEvent{EmployeeID: "E2", EventType: "checkin", Timestamp: time.Now()})
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WithinTransaction: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("transaction blocked behind a publish")
+	}
+
+	q.release <- nil
+	if sent := <-result; sent != 1 {
+		t.Fatalf("sent %d messages, want 1", sent)
+	}
+	if pending, _ := repo.CountPendingOutbox(ctx); pending != 0 {
+		t.Fatalf("%d outbox messages still pending", pending)
+	}
+	if got := len(q.Pending()); got != 1 {
+		t.Fatalf("%d messages queued, want 1", got)
+	}
+}
+
+func TestRelayHoldsBackFailedMessage(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	q := &gatedQueue{MemoryQueue: queue.NewMemoryQueue(), entered: make(chan struct{}, 4), release: make(chan error, 4)}
+	relay := newTestRelay(repo, q)
+	relay.interval = 50 * time.Millisecond
+	writeOutbox(t, repo, 3)
+
+	q.release <- nil
+	q.release <- errors.New("broker unavailable")
+	sent, err := relay.RelayPending(ctx)
+	if err != nil {
+		t.Fatalf("RelayPending: %v", err)
+	}
+	if sent != 1 {
+		t.Fatalf("sent %d messages, want 1", sent)
+	}
+
+	// The next pass skips the failed message and publishes the one after it
+	q.release <- nil
+	sent, err = relay.RelayPending(ctx)
+	if err != nil || sent != 1 {
+		t.Fatalf("second pass sent %d messages (%v), want 1", sent, err)
+	}
+	if pending, _ := repo.CountPendingOutbox(ctx); pending != 1 {
+		t.Fatalf("%d outbox messages pending, want the failed one", pending)
+	}
+
+	// Once its delay has passed the failed message is published
+	time.Sleep(relay.failedDelay(0))
+	q.release <- nil
+	sent, err = relay.RelayPending(ctx)
+	if err != nil || sent != 1 {
+		t.Fatalf("third pass sent %d messages (%v), want 1", sent, err)
+	}
+	if pending, _ := repo.CountPendingOutbox(ctx); pending != 0 {
+		t.Fatalf("%d outbox messages still pending", pending)
+	}
+}
+
+func TestRelayFailedDelay(t *testing.T) {
+	relay := newTestRelay(repository.NewMemoryRepository(), queue.NewMemoryQueue())
+	relay.interval = time.Second
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{20, maxFailedDelay},
+	}
+	for _, tt := range tests {
+		if got := relay.failedDelay(tt.attempts); got != tt.want {
+			t.Errorf("failedDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRelayStopWithoutStart(t *testing.T) {
+	relay := newTestRelay(repository.NewMemoryRepository(), queue.NewMemoryQueue())
+
+	stopped := make(chan struct{})
+	go func() {
+		relay.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked on a relay that was never started")
+	}
+}
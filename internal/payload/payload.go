@@ -0,0 +1,137 @@
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// ErrUnknownType is returned for a message type with no registered schema.
+var ErrUnknownType = errors.New("unknown message type")
+
+// ErrInvalid is returned when a payload cannot be decoded or is missing
+// required fields. Delivering it again will not help.
+var ErrInvalid = errors.New("invalid payload")
+
+// Decoder decodes a payload written at one schema version into the message
+// type's current Go type, filling in anything older versions lacked.
+type Decoder func(data json.RawMessage) (any, error)
+
+type schema struct {
+	latest   int
+	decoders map[int]Decoder
+}
+
+// Registry knows how to read every schema version of every message type.
+//
+// Versions of a message type must only add fields. That lets a release read
+// payloads from a newer one during a rollout: a version newer than any it
+// knows is read with its newest decoder, which ignores the fields it does
+// not know about.
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]*schema
+}
+
+func NewRegistry() *Registry {
+	return &Registry{schemas: make(map[string]*schema)}
+}
+
+// Register adds the decoder for one schema version of a message type. The
+// newest version registered for a type is the one Encode writes.
+func (r *Registry) Register(msgType string, version int, decode Decoder) {
+	if version < 1 {
+		panic(fmt.Sprintf("payload: invalid schema version %d for %s", version, msgType))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.schemas[msgType]
+	if !ok {
+		s = &schema{decoders: make(map[int]Decoder)}
+		r.schemas[msgType] = s
+	}
+	if _, dup := s.decoders[version]; dup {
+		panic(fmt.Sprintf("payload: schema version %d for %s registered twice", version, msgType))
+	}
+	s.decoders[version] = decode
+	s.latest = max(s.latest, version)
+}
+
+// Encode marshals v as the newest schema version of msgType and returns it
+// with that version. The result is decoded again before it is returned, so a
+// payload that consumers would reject is caught when it is produced.
+func (r *Registry) Encode(msgType string, v any) (json.RawMessage, int, error) {
+	decode, version, err := r.decoder(msgType, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to encode %s payload: %w", msgType, err)
+	}
+	if _, err := decode(data); err != nil {
+		return nil, 0, fmt.Errorf("%s payload (schema v%d): %w", msgType, version, err)
+	}
+	return data, version, nil
+}
+
+// Decode returns msg's payload as its type's current Go type. A message
+// without a schema version predates versioning and is read as version 1.
+func (r *Registry) Decode(msg *model.QueueMessage) (any, error) {
+	version := msg.SchemaVersion
+	if version == 0 {
+		version = 1
+	}
+
+	decode, _, err := r.decoder(msg.Type, version)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := decode(msg.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%s payload (schema v%d): %w", msg.Type, version, err)
+	}
+	return v, nil
+}
+
+// decoder returns the decoder for version, or for the newest version if
+// version is 0 or newer than any registered, along with the version it
+// decodes.
+func (r *Registry) decoder(msgType string, version int) (Decoder, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.schemas[msgType]
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: %s", ErrUnknownType, msgType)
+	}
+	if version == 0 || version > s.latest {
+		version = s.latest
+	}
+	decode, ok := s.decoders[version]
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: %s has no schema version %d", ErrInvalid, msgType, version)
+	}
+	return decode, version, nil
+}
+
+// As decodes msg's payload with r and checks that it is a T.
+func As[T any](r *Registry, msg *model.QueueMessage) (T, error) {
+	var zero T
+	v, err := r.Decode(msg)
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("%s payload decoded as %T, not %T", msg.Type, v, zero)
+	}
+	return typed, nil
+}
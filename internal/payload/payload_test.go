@@ -0,0 +1,168 @@
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+func TestDecode(t *testing.T) {
+	checkin := time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)
+	checkout := checkin.Add(8 * time.Hour)
+
+	tests := []struct {
+		name    string
+		msgType string
+		version int
+		payload string
+		want    any
+		wantErr error
+	}{
+		{
+			name:    "labor cost v1 has no session",
+			msgType: TypeLaborCostReport, version: 1,
+			payload: `{"employee_id":"E1","hours_worked":8,"date":"2024-01-15"}`,
+			want:    model.LaborCostReport{EmployeeID: "E1", HoursWorked: 8, Date: "2024-01-15"},
+		},
+		{
+			name:    "unversioned messages are read as v1",
+			msgType: TypeLaborCostReport, version: 0,
+			payload: `{"employee_id":"E1","hours_worked":8,"date":"2024-01-15"}`,
+			want:    model.LaborCostReport{EmployeeID: "E1", HoursWorked: 8, Date: "2024-01-15"},
+		},
+		{
+			name:    "labor cost v2",
+			msgType: TypeLaborCostReport, version: 2,
+			payload: `{"employee_id":"E1","hours_worked":8,"date":"2024-01-15","session_id":7,"idempotency_key":"work-session-7"}`,
+			want:    model.LaborCostReport{EmployeeID: "E1", HoursWorked: 8, Date: "2024-01-15", SessionID: 7, IdempotencyKey: "work-session-7"},
+		},
+		{
+			name:    "labor cost v2 needs an idempotency key",
+			msgType: TypeLaborCostReport, version: 2,
+			payload: `{"employee_id":"E1","hours_worked":8,"date":"2024-01-15","session_id":7}`,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "versions newer than known are read with the newest decoder",
+			msgType: TypeLaborCostReport, version: 9,
+			payload: `{"employee_id":"E1","hours_worked":8,"date":"2024-01-15","session_id":7,"idempotency_key":"work-session-7","shift":"night"}`,
+			want:    model.LaborCostReport{EmployeeID: "E1", HoursWorked: 8, Date: "2024-01-15", SessionID: 7, IdempotencyKey: "work-session-7"},
+		},
+		{
+			name:    "email v1 has no session times",
+			msgType: TypeEmailNotification, version: 1,
+			payload: `{"employee_id":"E1","hours_worked":8,"date":"2024-01-15"}`,
+			want:    model.EmailNotification{EmployeeID: "E1", HoursWorked: 8, Date: "2024-01-15"},
+		},
+		{
+			name:    "email v2",
+			msgType: TypeEmailNotification, version: 2,
+			payload: `{"employee_id":"E1","hours_worked":8,"date":"2024-01-15","checkin_time":"2024-01-15T08:00:00Z","checkout_time":"2024-01-15T16:00:00Z"}`,
+			want:    model.EmailNotification{EmployeeID: "E1", HoursWorked: 8, Date: "2024-01-15", CheckinTime: &checkin, CheckoutTime: &checkout},
+		},
+		{
+			name:    "email v2 needs session times",
+			msgType: TypeEmailNotification, version: 2,
+			payload: `{"employee_id":"E1","hours_worked":8,"date":"2024-01-15"}`,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "missing employee",
+			msgType: TypeEmailNotification, version: 1,
+			payload: `{"hours_worked":8,"date":"2024-01-15"}`,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "negative hours",
+			msgType: TypeLaborCostReport, version: 1,
+			payload: `{"employee_id":"E1","hours_worked":-1,"date":"2024-01-15"}`,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "not JSON",
+			msgType: TypeLaborCostReport, version: 1,
+			payload: `"E1"`,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "unknown type",
+			msgType: "payroll_run", version: 1,
+			payload: `{}`,
+			wantErr: ErrUnknownType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &model.QueueMessage{Type: tt.msgType, SchemaVersion: tt.version, Payload: json.RawMessage(tt.payload)}
+			got, err := Default.Decode(msg)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Decode: got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Fatalf("Decode = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestEncodeWritesNewestVersion(t *testing.T) {
+	report := model.LaborCostReport{EmployeeID: "E1", HoursWorked: 8, Date: "2024-01-15", SessionID: 7, IdempotencyKey: "work-session-7"}
+	data, version, err := Encode(TypeLaborCostReport, report)
+	if err != nil || version != 2 {
+		t.Fatalf("Encode: version %d, %v; want version 2", version, err)
+	}
+
+	got, err := Decode[model.LaborCostReport](&model.QueueMessage{Type: TypeLaborCostReport, SchemaVersion: version, Payload: data})
+	if err != nil || got != report {
+		t.Fatalf("round trip = %+v (%v), want %+v", got, err, report)
+	}
+
+	// Payloads consumers would reject are caught when produced
+	report.IdempotencyKey = ""
+	if _, _, err := Encode(TypeLaborCostReport, report); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Encode without idempotency key: got %v, want ErrInvalid", err)
+	}
+}
+
+func TestDecodeWrongType(t *testing.T) {
+	msg := &model.QueueMessage{Type: TypeEmailNotification, SchemaVersion: 1, Payload: json.RawMessage(`{"employee_id":"E1","hours_worked":8,"date":"2024-01-15"}`)}
+	if _, err := Decode[model.LaborCostReport](msg); err == nil {
+		t.Fatal("decoding an email notification as a labor cost report succeeded")
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	decode := func(data json.RawMessage) (any, error) { return nil, nil }
+	tests := []struct {
+		name     string
+		register func(r *Registry)
+	}{
+		{"version zero", func(r *Registry) { r.Register("t", 0, decode) }},
+		{"duplicate version", func(r *Registry) {
+			r.Register("t", 1, decode)
+			r.Register("t", 1, decode)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Register did not panic")
+				}
+			}()
+			tt.register(NewRegistry())
+		})
+	}
+}
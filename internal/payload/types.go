@@ -0,0 +1,104 @@
+package payload
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// Message types
+const (
+	TypeLaborCostReport   = "labor_cost_report"
+	TypeEmailNotification = "email_notification"
+)
+
+// Default holds the schemas of every message type the service produces.
+var Default = NewRegistry()
+
+func init() {
+	Default.Register(TypeLaborCostReport, 1, decodeLaborCostReportV1)
+	Default.Register(TypeLaborCostReport, 2, decodeLaborCostReportV2)
+	Default.Register(TypeEmailNotification, 1, decodeEmailNotificationV1)
+	Default.Register(TypeEmailNotification, 2, decodeEmailNotificationV2)
+}
+
+// Encode encodes v as the newest schema version of msgType in Default.
+func Encode(msgType string, v any) (json.RawMessage, int, error) {
+	return Default.Encode(msgType, v)
+}
+
+// Decode decodes msg's payload with Default.
+func Decode[T any](msg *model.QueueMessage) (T, error) {
+	return As[T](Default, msg)
+}
+
+func decodeLaborCostReportV1(data json.RawMessage) (any, error) {
+	var report model.LaborCostReport
+	if err := unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	if err := checkHours(report.EmployeeID, report.HoursWorked, report.Date); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Version 2 identifies the work session and carries an idempotency key, so
+// the legacy system can recognise a report it has already received.
+func decodeLaborCostReportV2(data json.RawMessage) (any, error) {
+	v, err := decodeLaborCostReportV1(data)
+	if err != nil {
+		return nil, err
+	}
+	if v.(model.LaborCostReport).IdempotencyKey == "" {
+		return nil, fmt.Errorf("%w: missing idempotency_key", ErrInvalid)
+	}
+	return v, nil
+}
+
+func decodeEmailNotificationV1(data json.RawMessage) (any, error) {
+	var notification model.EmailNotification
+	if err := unmarshal(data, &notification); err != nil {
+		return nil, err
+	}
+	if err := checkHours(notification.EmployeeID, notification.HoursWorked, notification.Date); err != nil {
+		return nil, err
+	}
+	return notification, nil
+}
+
+// Version 2 carries the session's check-in and check-out times for the email
+// to show.
+func decodeEmailNotificationV2(data json.RawMessage) (any, error) {
+	v, err := decodeEmailNotificationV1(data)
+	if err != nil {
+		return nil, err
+	}
+	notification := v.(model.EmailNotification)
+	if notification.CheckinTime == nil || notification.CheckoutTime == nil {
+		return nil, fmt.Errorf("%w: missing checkin_time or checkout_time", ErrInvalid)
+	}
+	return notification, nil
+}
+
+func unmarshal(data json.RawMessage, v any) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return nil
+}
+
+// checkHours validates the fields shared by every worked-hours payload.
+func checkHours(employeeID string, hoursWorked float64, date string) error {
+	if employeeID == "" {
+		return fmt.Errorf("%w: missing employee_id", ErrInvalid)
+	}
+	if hoursWorked < 0 {
+		return fmt.Errorf("%w: negative hours_worked", ErrInvalid)
+	}
+	if date == "" {
+		return fmt.Errorf("%w: missing date", ErrInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// TypeMalformed is given to deliveries whose body isn't a valid message. The
+// worker dead-letters them like any other permanent failure, so they show up
+// in the dead-letter API instead of only in the broker's dead-letter queue.
+const TypeMalformed = "malformed"
+
+// MalformedPayload is the payload of a TypeMalformed message.
+type MalformedPayload struct {
+	Body  string `json:"body"`  // the delivery as received
+	Error string `json:"error"` // why it couldn't be decoded
+}
+
+// malformedMessage wraps a body that failed to decode. It is delivered once:
+// retrying would fail the same way.
+func malformedMessage(body []byte, cause error) *model.QueueMessage {
+	data, err := json.Marshal(MalformedPayload{Body: string(body), Error: cause.Error()})
+	if err != nil {
+		// Marshalling two strings can't fail
+		panic(fmt.Sprintf("failed to encode malformed message: %v", err))
+	}
+
+	now := time.Now()
+	return &model.QueueMessage{
+		ID:          NewMessageID(),
+		Type:        TypeMalformed,
+		Payload:     data,
+		Attempts:    1,
+		MaxAttempts: 1,
+		CreatedAt:   now,
+		ProcessAt:   now,
+		Status:      "processing",
+	}
+}
+
+// DecodeMalformed returns the message a TypeMalformed message wraps, if its
+// body can be decoded now, e.g. after an upgrade.
+func DecodeMalformed(msg *model.QueueMessage) (*model.QueueMessage, error) {
+	var p MalformedPayload
+	if err := json.Unmarshal(msg.Payload, &p); err != nil {
+		return nil, fmt.Errorf("failed to decode malformed message %s: %w", msg.ID, err)
+	}
+
+	var original model.QueueMessage
+	if err := json.Unmarshal([]byte(p.Body), &original); err != nil {
+		return nil, fmt.Errorf("message %s is still malformed: %w", msg.ID, err)
+	}
+	return &original, nil
+}
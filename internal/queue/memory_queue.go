@@ -0,0 +1,343 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// FailedMessage is a message the memory queue was told to fail, with the
+// reason it was given.
+type FailedMessage struct {
+	Message  model.QueueMessage
+	Reason   string
+	FailedAt time.Time
+}
+
+// MemoryQueue is an in-process Queue for tests and the server's demo mode.
+// It behaves like the broker-backed queues: messages are delivered no
+// earlier than their ProcessAt, stay in flight until acknowledged, and pass
+// through JSON on the way in so the worker sees the same payload types it
+// would get from RabbitMQ. The inspection helpers (Messages, Pending,
+// Completed, Failed) let tests assert on what was queued and what became of
+// it.
+type MemoryQueue struct {
+	mu          sync.Mutex
+	history     []model.QueueMessage          // every message enqueued, in order
+	pending     []model.QueueMessage          // waiting for delivery, in order
+	inFlight    map[string]model.QueueMessage // delivered copies by receipt, as stored
+	completed   []model.QueueMessage
+	failed      []FailedMessage
+	nextReceipt int
+	changed     chan struct{} // closed and replaced whenever the queue changes
+	stop        chan struct{} // set while a consumer is running
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		inFlight: make(map[string]model.QueueMessage),
+		changed:  make(chan struct{}),
+	}
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, msg *model.QueueMessage) error {
+	// Set defaults
+	if msg.Status == "" {
+		msg.Status = "pending"
+	}
+	if msg.MaxAttempts == 0 {
+		msg.MaxAttempts = 5
+	}
+
+	stored, err := roundTrip(msg)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.history = append(q.history, stored)
+	q.pending = append(q.pending, stored)
+	q.signal()
+
+	log.Printf("📤 Enqueued message: %s (Type: %s)", msg.ID, msg.Type)
+	return nil
+}
+
+func (q *MemoryQueue) Dequeue(ctx context.Context) (*model.QueueMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, _ := q.take(time.Now())
+	return msg, nil
+}
+
+// Consume delivers due messages as they arrive or fall due, with at most
+// prefetch in flight.
+func (q *MemoryQueue) Consume(prefetch int) (<-chan *model.QueueMessage, error) {
+	q.mu.Lock()
+	if q.stop != nil {
+		q.mu.Unlock()
+		return nil, fmt.Errorf("already consuming")
+	}
+	stop := make(chan struct{})
+	q.stop = stop
+	q.mu.Unlock()
+
+	messages := make(chan *model.QueueMessage)
+	go func() {
+		defer close(messages)
+		for {
+			q.mu.Lock()
+			var msg *model.QueueMessage
+			wait := time.Duration(-1)
+			if len(q.inFlight) < prefetch {
+				msg, wait = q.take(time.Now())
+			}
+			changed := q.changed
+			q.mu.Unlock()
+
+			if msg != nil {
+				select {
+				case messages <- msg:
+				case <-stop:
+					q.Requeue(context.Background(), msg)
+					return
+				}
+				continue
+			}
+
+			// Nothing to deliver: wait for a change or the next message to fall due
+			var due <-chan time.Time
+			var timer *time.Timer
+			if wait >= 0 {
+				timer = time.NewTimer(wait)
+				due = timer.C
+			}
+			select {
+			case <-changed:
+			case <-due:
+			case <-stop:
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
+func (q *MemoryQueue) StopConsuming() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stop != nil {
+		close(q.stop)
+		q.stop = nil
+	}
+	return nil
+}
+
+// take removes the first due message and marks it in flight. If none is due
+// it returns how long until the next one is, or -1 if nothing is pending.
+// q.mu must be held.
+func (q *MemoryQueue) take(now time.Time) (*model.QueueMessage, time.Duration) {
+	wait := time.Duration(-1)
+	for i, stored := range q.pending {
+		if until := stored.ProcessAt.Sub(now); until > 0 {
+			if wait < 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+		q.nextReceipt++
+		receipt := strconv.Itoa(q.nextReceipt)
+		q.inFlight[receipt] = stored
+
+		msg := copyMessage(stored)
+		msg.Status = "processing"
+		msg.Attempts++
+		msg.Receipt = receipt
+		return &msg, 0
+	}
+	return nil, wait
+}
+
+func (q *MemoryQueue) MarkCompleted(ctx context.Context, msg *model.QueueMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.settle(msg); err != nil {
+		return err
+	}
+	done := copyMessage(*msg)
+	done.Status = "completed"
+	q.completed = append(q.completed, done)
+	return nil
+}
+
+func (q *MemoryQueue) MarkFailed(ctx context.Context, msg *model.QueueMessage, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.settle(msg); err != nil {
+		return err
+	}
+	msg.Status = "failed"
+	q.failed = append(q.failed, FailedMessage{
+		Message:  copyMessage(*msg),
+		Reason:   reason,
+		FailedAt: time.Now(),
+	})
+	return nil
+}
+
+func (q *MemoryQueue) Retry(ctx context.Context, msg *model.QueueMessage) error {
+	msg.Status = "pending"
+	stored, err := roundTrip(msg)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.settle(msg); err != nil {
+		return err
+	}
+	q.pending = append(q.pending, stored)
+	return nil
+}
+
+// Requeue puts the message back at the front of the queue as it was stored,
+// so no attempt is used up.
+func (q *MemoryQueue) Requeue(ctx context.Context, msg *model.QueueMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stored, err := q.settle(msg)
+	if err != nil {
+		return err
+	}
+	q.pending = append([]model.QueueMessage{stored}, q.pending...)
+	return nil
+}
+
+// settle removes the in-flight delivery behind msg and wakes the consumer,
+// which may have been waiting for a free prefetch slot. q.mu must be held.
+func (q *MemoryQueue) settle(msg *model.QueueMessage) (model.QueueMessage, error) {
+	stored, ok := q.inFlight[msg.Receipt]
+	if !ok {
+		return model.QueueMessage{}, fmt.Errorf("message %s: %w", msg.ID, ErrUnknownDelivery)
+	}
+	delete(q.inFlight, msg.Receipt)
+	q.signal()
+	return stored, nil
+}
+
+// signal wakes everyone waiting for the queue to change. q.mu must be held.
+func (q *MemoryQueue) signal() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *MemoryQueue) PurgeDeadLetters(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	purged := len(q.failed)
+	q.failed = nil
+	return purged, nil
+}
+
+// GetPendingCount counts messages waiting for delivery, including those
+// scheduled for later.
+func (q *MemoryQueue) GetPendingCount(ctx context.Context) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *MemoryQueue) Close() error {
+	return q.StopConsuming()
+}
+
+// Messages returns every message enqueued so far, in order. Retries and
+// requeues don't count as enqueues.
+func (q *MemoryQueue) Messages() []model.QueueMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return copyMessages(q.history)
+}
+
+// Pending returns the messages waiting for delivery.
+func (q *MemoryQueue) Pending() []model.QueueMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return copyMessages(q.pending)
+}
+
+// Completed returns the messages acknowledged with MarkCompleted.
+func (q *MemoryQueue) Completed() []model.QueueMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return copyMessages(q.completed)
+}
+
+// Failed returns the messages given up on with MarkFailed.
+func (q *MemoryQueue) Failed() []FailedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	failed := make([]FailedMessage, len(q.failed))
+	for i, f := range q.failed {
+		failed[i] = f
+		failed[i].Message = copyMessage(f.Message)
+	}
+	return failed
+}
+
+// roundTrip returns msg as it would look after a trip through a broker.
+func roundTrip(msg *model.QueueMessage) (model.QueueMessage, error) {
+	var out model.QueueMessage
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return out, fmt.Errorf("failed to marshal message: %w", err)
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return out, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return out, nil
+}
+
+// copyMessage copies msg deeply enough that the copy's payload can be
+// changed without affecting the original. Messages stored by the queue have
+// been through roundTrip, so this cannot fail.
+func copyMessage(msg model.QueueMessage) model.QueueMessage {
+	receipt := msg.Receipt
+	out, _ := roundTrip(&msg)
+	out.Receipt = receipt
+	return out
+}
+
+func copyMessages(messages []model.QueueMessage) []model.QueueMessage {
+	out := make([]model.QueueMessage, len(messages))
+	for i, msg := range messages {
+		out[i] = copyMessage(msg)
+	}
+	return out
+}
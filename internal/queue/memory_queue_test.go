@@ -0,0 +1,225 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+func newTestMessage(processAt time.Time) *model.QueueMessage {
+	return &model.QueueMessage{
+		ID:        NewMessageID(),
+		Type:      "test_message",
+		CreatedAt: time.Now(),
+		ProcessAt: processAt,
+	}
+}
+
+func mustEnqueue(t *testing.T, q *MemoryQueue, msg *model.QueueMessage) {
+	t.Helper()
+	if err := q.Enqueue(context.Background(), msg); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+}
+
+func mustDequeue(t *testing.T, q *MemoryQueue) *model.QueueMessage {
+	t.Helper()
+	msg, err := q.Dequeue(context.Background())
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	if msg == nil {
+		t.Fatal("Dequeue returned no message")
+	}
+	return msg
+}
+
+func receive(t *testing.T, messages <-chan *model.QueueMessage) *model.QueueMessage {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		if msg == nil {
+			t.Fatal("consumer channel closed")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a delivery")
+		return nil
+	}
+}
+
+func TestMemoryQueueDelayedDelivery(t *testing.T) {
+	q := NewMemoryQueue()
+	later := newTestMessage(time.Now().Add(100 * time.Millisecond))
+	now := newTestMessage(time.Now())
+	mustEnqueue(t, q, later)
+	mustEnqueue(t, q, now)
+
+	got := mustDequeue(t, q)
+	if got.ID != now.ID {
+		t.Fatalf("Dequeue = %s, want the due message %s first", got.ID, now.ID)
+	}
+	if err := q.MarkCompleted(context.Background(), got); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if msg, err := q.Dequeue(context.Background()); err != nil || msg != nil {
+		t.Fatalf("Dequeue before ProcessAt = %v, %v, want nothing", msg, err)
+	}
+	if n := q.GetPendingCount(context.Background()); n != 1 {
+		t.Fatalf("GetPendingCount = %d, want the scheduled message counted", n)
+	}
+
+	messages, err := q.Consume(1)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	defer q.StopConsuming()
+
+	got = receive(t, messages)
+	if got.ID != later.ID {
+		t.Fatalf("delivered %s, want %s", got.ID, later.ID)
+	}
+	if time.Now().Before(later.ProcessAt) {
+		t.Fatalf("delivered at %v, before its ProcessAt %v", time.Now(), later.ProcessAt)
+	}
+	if got.Status != "processing" || got.Attempts != 1 || got.Receipt == "" {
+		t.Fatalf("delivery = %+v, want processing, attempt 1 and a receipt", got)
+	}
+}
+
+func TestMemoryQueueRetry(t *testing.T) {
+	q := NewMemoryQueue()
+	mustEnqueue(t, q, newTestMessage(time.Now()))
+
+	msg := mustDequeue(t, q)
+	msg.ProcessAt = time.Now().Add(time.Hour)
+	if err := q.Retry(context.Background(), msg); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if msg, _ := q.Dequeue(context.Background()); msg != nil {
+		t.Fatalf("Dequeue = %s, want the retry held until its ProcessAt", msg.ID)
+	}
+	pending := q.Pending()
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].Status != "pending" {
+		t.Fatalf("Pending = %+v, want the retry with one attempt used", pending)
+	}
+
+	if err := q.MarkCompleted(context.Background(), msg); !errors.Is(err, ErrUnknownDelivery) {
+		t.Fatalf("MarkCompleted after Retry = %v, want ErrUnknownDelivery", err)
+	}
+}
+
+func TestMemoryQueueRequeue(t *testing.T) {
+	q := NewMemoryQueue()
+	first := newTestMessage(time.Now())
+	second := newTestMessage(time.Now())
+	mustEnqueue(t, q, first)
+	mustEnqueue(t, q, second)
+
+	msg := mustDequeue(t, q)
+	if err := q.Requeue(context.Background(), msg); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+
+	again := mustDequeue(t, q)
+	if again.ID != first.ID {
+		t.Fatalf("Dequeue after Requeue = %s, want %s back at the front", again.ID, first.ID)
+	}
+	if again.Attempts != 1 {
+		t.Fatalf("Attempts = %d, want the requeue not to use up an attempt", again.Attempts)
+	}
+	if again.Receipt == msg.Receipt {
+		t.Fatal("redelivery reused the old receipt")
+	}
+	if err := q.Requeue(context.Background(), msg); !errors.Is(err, ErrUnknownDelivery) {
+		t.Fatalf("Requeue of a settled delivery = %v, want ErrUnknownDelivery", err)
+	}
+}
+
+func TestMemoryQueueConsumePrefetch(t *testing.T) {
+	q := NewMemoryQueue()
+	for i := 0; i < 3; i++ {
+		mustEnqueue(t, q, newTestMessage(time.Now()))
+	}
+
+	messages, err := q.Consume(2)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if _, err := q.Consume(2); err == nil {
+		t.Fatal("second Consume succeeded, want an error")
+	}
+
+	first := receive(t, messages)
+	receive(t, messages)
+	select {
+	case msg := <-messages:
+		t.Fatalf("delivered %s with two messages unacknowledged and prefetch 2", msg.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := q.MarkCompleted(context.Background(), first); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	receive(t, messages)
+
+	if err := q.StopConsuming(); err != nil {
+		t.Fatalf("StopConsuming: %v", err)
+	}
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Fatal("delivered a message after StopConsuming")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("consumer channel not closed after StopConsuming")
+	}
+}
+
+func TestMemoryQueueInspection(t *testing.T) {
+	q := NewMemoryQueue()
+	done := newTestMessage(time.Now())
+	failed := newTestMessage(time.Now())
+	waiting := newTestMessage(time.Now().Add(time.Hour))
+	for _, msg := range []*model.QueueMessage{done, failed, waiting} {
+		mustEnqueue(t, q, msg)
+	}
+
+	if err := q.MarkCompleted(context.Background(), mustDequeue(t, q)); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if err := q.MarkFailed(context.Background(), mustDequeue(t, q), "rejected"); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+
+	if got := q.Messages(); len(got) != 3 || got[0].ID != done.ID || got[1].ID != failed.ID || got[2].ID != waiting.ID {
+		t.Fatalf("Messages = %+v, want all three in enqueue order", got)
+	}
+	if got := q.Pending(); len(got) != 1 || got[0].ID != waiting.ID {
+		t.Fatalf("Pending = %+v, want only the scheduled message", got)
+	}
+	if got := q.Completed(); len(got) != 1 || got[0].ID != done.ID || got[0].Status != "completed" {
+		t.Fatalf("Completed = %+v, want the acknowledged message", got)
+	}
+	got := q.Failed()
+	if len(got) != 1 || got[0].Message.ID != failed.ID || got[0].Reason != "rejected" || got[0].Message.Status != "failed" {
+		t.Fatalf("Failed = %+v, want the failed message and its reason", got)
+	}
+
+	// The helpers return copies
+	q.Messages()[0].ID = "changed"
+	if q.Messages()[0].ID != done.ID {
+		t.Fatal("changing the result of Messages changed the queue")
+	}
+
+	purged, err := q.PurgeDeadLetters(context.Background())
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeadLetters = %d, %v, want 1", purged, err)
+	}
+	if got := q.Failed(); len(got) != 0 {
+		t.Fatalf("Failed after purge = %+v, want none", got)
+	}
+}
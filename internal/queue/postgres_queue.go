@@ -0,0 +1,213 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// PostgresQueue keeps jobs in the queue_jobs table, for sites that run
+// without RabbitMQ.
+//
+// Dequeue claims the oldest due job with FOR UPDATE SKIP LOCKED, so several
+// workers can poll the same table, and hides it for the visibility timeout.
+// A job that is not completed, retried, failed or requeued in that time -
+// e.g. because its worker crashed - becomes visible again and its old receipt
+// stops working.
+type PostgresQueue struct {
+	db                *sqlx.DB
+	visibilityTimeout time.Duration
+}
+
+// NewPostgresQueue uses the queue_jobs table in db, which migration 0004
+// creates.
+func NewPostgresQueue(db *sqlx.DB, visibilityTimeout time.Duration) *PostgresQueue {
+	return &PostgresQueue{
+		db:                db,
+		visibilityTimeout: visibilityTimeout,
+	}
+}
+
+func (q *PostgresQueue) Enqueue(ctx context.Context, msg *model.QueueMessage) error {
+	// Set defaults
+	if msg.Status == "" {
+		msg.Status = "pending"
+	}
+	if msg.MaxAttempts == 0 {
+		msg.MaxAttempts = 5
+	}
+	if msg.ProcessAt.IsZero() {
+		msg.ProcessAt = time.Now()
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	query := `
+		INSERT INTO queue_jobs (message_id, message_type, body, process_at)
+		VALUES ($1, $2, $3, $4)`
+
+	if _, err := q.db.ExecContext(ctx, query, msg.ID, msg.Type, body, msg.ProcessAt); err != nil {
+		return fmt.Errorf("failed to enqueue message: %w", err)
+	}
+
+	log.Printf("📤 Enqueued message: %s (Type: %s)", msg.ID, msg.Type)
+	return nil
+}
+
+func (q *PostgresQueue) Dequeue(ctx context.Context) (*model.QueueMessage, error) {
+	receipt := NewMessageID()
+
+	query := `
+		UPDATE queue_jobs
+		SET locked_until = NOW() + $1::float8 * INTERVAL '1 second', receipt = $2
+		WHERE id = (
+			SELECT id FROM queue_jobs
+			WHERE status = 'pending'
+			  AND process_at <= NOW()
+			  AND (locked_until IS NULL OR locked_until <= NOW())
+			ORDER BY process_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING body`
+
+	var body []byte
+	err := q.db.GetContext(ctx, &body, query, q.visibilityTimeout.Seconds(), receipt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil // No message available
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	var msg model.QueueMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		// The worker dead-letters it; retrying would fail the same way
+		log.Printf("Received malformed job: %v", err)
+		malformed := malformedMessage(body, err)
+		malformed.Receipt = receipt
+		return malformed, nil
+	}
+
+	msg.Status = "processing"
+	msg.Attempts++
+	msg.Receipt = receipt
+
+	log.Printf("Received message: %s (Type: %s, Attempt: %d)", msg.ID, msg.Type, msg.Attempts)
+	return &msg, nil
+}
+
+func (q *PostgresQueue) MarkCompleted(ctx context.Context, msg *model.QueueMessage) error {
+	result, err := q.db.ExecContext(ctx, `DELETE FROM queue_jobs WHERE receipt = $1`, msg.Receipt)
+	if err := settled(msg, result, err); err != nil {
+		return err
+	}
+	log.Printf("Message completed: %s", msg.ID)
+	return nil
+}
+
+// Retry stores the message with its new attempt count and makes it visible
+// again at msg.ProcessAt.
+func (q *PostgresQueue) Retry(ctx context.Context, msg *model.QueueMessage) error {
+	msg.Status = "pending"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	query := `
+		UPDATE queue_jobs
+		SET body = $2, process_at = $3, locked_until = NULL, receipt = NULL
+		WHERE receipt = $1`
+
+	result, err := q.db.ExecContext(ctx, query, msg.Receipt, body, msg.ProcessAt)
+	return settled(msg, result, err)
+}
+
+// Requeue makes the message visible again straight away. The stored copy
+// still has the previous attempt count, so no attempt is used up.
+func (q *PostgresQueue) Requeue(ctx context.Context, msg *model.QueueMessage) error {
+	result, err := q.db.ExecContext(ctx, `UPDATE queue_jobs SET locked_until = NULL, receipt = NULL WHERE receipt = $1`, msg.Receipt)
+	return settled(msg, result, err)
+}
+
+// MarkFailed keeps the job as failed rather than deleting it, like the
+// RabbitMQ dead-letter queue.
+func (q *PostgresQueue) MarkFailed(ctx context.Context, msg *model.QueueMessage, reason string) error {
+	msg.Status = "failed"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	result, err := q.fail(ctx, msg.Receipt, body, reason)
+	if err := settled(msg, result, err); err != nil {
+		return err
+	}
+	log.Printf("Message failed: %s (%s)", msg.ID, reason)
+	return nil
+}
+
+func (q *PostgresQueue) fail(ctx context.Context, receipt string, body []byte, reason string) (sql.Result, error) {
+	query := `
+		UPDATE queue_jobs
+		SET status = 'failed', body = $2, last_error = $3, locked_until = NULL, receipt = NULL
+		WHERE receipt = $1`
+
+	return q.db.ExecContext(ctx, query, receipt, body, reason)
+}
+
+func (q *PostgresQueue) PurgeDeadLetters(ctx context.Context) (int, error) {
+	result, err := q.db.ExecContext(ctx, `DELETE FROM queue_jobs WHERE status = 'failed'`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge failed jobs: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
+
+// GetPendingCount counts jobs waiting to be claimed, including those
+// scheduled for later.
+func (q *PostgresQueue) GetPendingCount(ctx context.Context) int {
+	query := `
+		SELECT COUNT(*) FROM queue_jobs
+		WHERE status = 'pending' AND (locked_until IS NULL OR locked_until <= NOW())`
+
+	var count int
+	if err := q.db.GetContext(ctx, &count, query); err != nil {
+		log.Printf("Failed to count pending jobs: %v", err)
+		return 0
+	}
+	return count
+}
+
+// Close does nothing: the database belongs to the repository.
+func (q *PostgresQueue) Close() error {
+	return nil
+}
+
+// settled checks that an update by receipt found its job. If it didn't, the
+// visibility timeout expired and the job may already be with another worker.
+func settled(msg *model.QueueMessage, result sql.Result, err error) error {
+	if err != nil {
+		return fmt.Errorf("failed to update message %s: %w", msg.ID, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update message %s: %w", msg.ID, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("message %s: %w", msg.ID, ErrUnknownDelivery)
+	}
+	return nil
+}
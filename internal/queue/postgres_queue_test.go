@@ -0,0 +1,299 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/omaaartamer/factory-checkin-api/internal/migrate"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// openTestQueue returns a queue on the database in TEST_DATABASE_URL. Dequeue
+// claims any due job, so each test gets its own schema, dropped afterwards.
+func openTestQueue(t *testing.T, visibilityTimeout time.Duration) (*PostgresQueue, *sqlx.DB) {
+	t.Helper()
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	admin, err := sqlx.Connect("postgres", databaseURL)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "queue_test_" + NewMessageID()[:12]
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	db, err := sqlx.Connect("postgres", withSearchPath(t, databaseURL, schema))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.NewMigrator(db.DB)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	return NewPostgresQueue(db, visibilityTimeout), db
+}
+
+// withSearchPath adds search_path to a URL or key=value connection string.
+func withSearchPath(t *testing.T, databaseURL, schema string) string {
+	t.Helper()
+	if !strings.Contains(databaseURL, "://") {
+		return databaseURL + " search_path=" + schema
+	}
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		t.Fatalf("TEST_DATABASE_URL: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func enqueueJob(t *testing.T, q *PostgresQueue, msg *model.QueueMessage) {
+	t.Helper()
+	if err := q.Enqueue(context.Background(), msg); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+}
+
+// claimJob dequeues a job and fails the test if there is none.
+func claimJob(t *testing.T, q *PostgresQueue) *model.QueueMessage {
+	t.Helper()
+	msg, err := q.Dequeue(context.Background())
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	if msg == nil {
+		t.Fatal("Dequeue returned no job")
+	}
+	return msg
+}
+
+func assertNoJob(t *testing.T, q *PostgresQueue) {
+	t.Helper()
+	msg, err := q.Dequeue(context.Background())
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	if msg != nil {
+		t.Fatalf("Dequeue = %s, want no visible job", msg.ID)
+	}
+}
+
+func TestPostgresQueueSkipsLockedJobs(t *testing.T) {
+	q, db := openTestQueue(t, time.Minute)
+	ctx := context.Background()
+	first := newTestMessage(time.Now().Add(-time.Second))
+	second := newTestMessage(time.Now())
+	enqueueJob(t, q, first)
+	enqueueJob(t, q, second)
+
+	// Another worker is in the middle of claiming the first job
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT id FROM queue_jobs WHERE message_id = $1 FOR UPDATE`, first.ID); err != nil {
+		t.Fatalf("lock job: %v", err)
+	}
+
+	claimCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	msg, err := q.Dequeue(claimCtx)
+	if err != nil {
+		t.Fatalf("Dequeue while the first job is locked: %v", err)
+	}
+	if msg == nil || msg.ID != second.ID {
+		t.Fatalf("Dequeue = %+v, want %s, skipping the locked job", msg, second.ID)
+	}
+}
+
+func TestPostgresQueueConcurrentClaims(t *testing.T) {
+	q, _ := openTestQueue(t, time.Minute)
+	const jobs = 10
+	for i := 0; i < jobs; i++ {
+		enqueueJob(t, q, newTestMessage(time.Now()))
+	}
+
+	var (
+		mu      sync.Mutex
+		claimed = make(map[string]int)
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg, err := q.Dequeue(context.Background())
+			if err != nil {
+				t.Errorf("Dequeue: %v", err)
+				return
+			}
+			if msg == nil {
+				return
+			}
+			mu.Lock()
+			claimed[msg.ID]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("job %s claimed %d times", id, n)
+		}
+	}
+	if len(claimed) != jobs {
+		t.Errorf("%d jobs claimed, want all %d", len(claimed), jobs)
+	}
+}
+
+func TestPostgresQueueVisibilityTimeout(t *testing.T) {
+	q, _ := openTestQueue(t, 200*time.Millisecond)
+	ctx := context.Background()
+	job := newTestMessage(time.Now())
+	enqueueJob(t, q, job)
+
+	lost := claimJob(t, q)
+	if lost.Status != "processing" || lost.Attempts != 1 || lost.Receipt == "" {
+		t.Fatalf("claimed %+v, want processing, attempt 1 and a receipt", lost)
+	}
+	assertNoJob(t, q)
+
+	// The worker never settles it, so it becomes visible again
+	time.Sleep(300 * time.Millisecond)
+	again := claimJob(t, q)
+	if again.ID != job.ID || again.Receipt == lost.Receipt {
+		t.Fatalf("claimed %+v, want %s again with a new receipt", again, job.ID)
+	}
+	if err := q.MarkCompleted(ctx, lost); !errors.Is(err, ErrUnknownDelivery) {
+		t.Fatalf("MarkCompleted with the expired receipt = %v, want ErrUnknownDelivery", err)
+	}
+	if err := q.MarkCompleted(ctx, again); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	assertNoJob(t, q)
+}
+
+func TestPostgresQueueProcessAt(t *testing.T) {
+	q, _ := openTestQueue(t, time.Minute)
+	ctx := context.Background()
+	later := newTestMessage(time.Now().Add(time.Hour))
+	now := newTestMessage(time.Now())
+	enqueueJob(t, q, later)
+	enqueueJob(t, q, now)
+
+	if got := claimJob(t, q); got.ID != now.ID {
+		t.Fatalf("Dequeue = %s, want the due job %s", got.ID, now.ID)
+	}
+	assertNoJob(t, q)
+	if n := q.GetPendingCount(ctx); n != 1 {
+		t.Fatalf("GetPendingCount = %d, want the scheduled job counted", n)
+	}
+}
+
+func TestPostgresQueueRetry(t *testing.T) {
+	q, _ := openTestQueue(t, time.Minute)
+	ctx := context.Background()
+	enqueueJob(t, q, newTestMessage(time.Now()))
+
+	msg := claimJob(t, q)
+	msg.ProcessAt = time.Now().Add(time.Hour)
+	if err := q.Retry(ctx, msg); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	assertNoJob(t, q)
+	if err := q.MarkCompleted(ctx, msg); !errors.Is(err, ErrUnknownDelivery) {
+		t.Fatalf("MarkCompleted after Retry = %v, want ErrUnknownDelivery", err)
+	}
+
+	// Brought forward, the retry keeps the attempt it used
+	enqueueJob(t, q, newTestMessage(time.Now()))
+	msg = claimJob(t, q)
+	msg.ProcessAt = time.Now()
+	if err := q.Retry(ctx, msg); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if again := claimJob(t, q); again.ID != msg.ID || again.Attempts != 2 {
+		t.Fatalf("claimed %+v, want %s on attempt 2", again, msg.ID)
+	}
+}
+
+func TestPostgresQueueRequeue(t *testing.T) {
+	q, _ := openTestQueue(t, time.Minute)
+	ctx := context.Background()
+	enqueueJob(t, q, newTestMessage(time.Now()))
+
+	msg := claimJob(t, q)
+	if err := q.Requeue(ctx, msg); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	again := claimJob(t, q)
+	if again.ID != msg.ID || again.Attempts != 1 {
+		t.Fatalf("claimed %+v, want %s without an attempt used up", again, msg.ID)
+	}
+	if err := q.Requeue(ctx, msg); !errors.Is(err, ErrUnknownDelivery) {
+		t.Fatalf("Requeue with the old receipt = %v, want ErrUnknownDelivery", err)
+	}
+}
+
+func TestPostgresQueuePendingCount(t *testing.T) {
+	q, _ := openTestQueue(t, time.Minute)
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		enqueueJob(t, q, newTestMessage(time.Now().Add(time.Duration(i)*time.Millisecond)))
+	}
+	enqueueJob(t, q, newTestMessage(time.Now().Add(time.Hour)))
+	if n := q.GetPendingCount(ctx); n != 5 {
+		t.Fatalf("GetPendingCount = %d, want 5", n)
+	}
+
+	claimed := claimJob(t, q)
+	if err := q.MarkCompleted(ctx, claimJob(t, q)); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if err := q.MarkFailed(ctx, claimJob(t, q), "rejected"); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	// One claimed, one completed and one failed: two left
+	if n := q.GetPendingCount(ctx); n != 2 {
+		t.Fatalf("GetPendingCount = %d, want 2", n)
+	}
+
+	if err := q.Requeue(ctx, claimed); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	if n := q.GetPendingCount(ctx); n != 3 {
+		t.Fatalf("GetPendingCount after Requeue = %d, want 3", n)
+	}
+	purged, err := q.PurgeDeadLetters(ctx)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeadLetters = %d, %v, want 1", purged, err)
+	}
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/payload"
+)
+
+// ErrUnknownDelivery is returned when acknowledging a message the queue has
+// no outstanding delivery for, e.g. one that was already acknowledged or
+// whose delivery was lost with its connection. The broker will deliver such a
+// message again.
+var ErrUnknownDelivery = errors.New("unknown or expired delivery")
+
+// ErrUnavailable is returned while the queue has lost its broker connection
+// and is reconnecting.
+var ErrUnavailable = errors.New("queue unavailable")
+
+// Queue interface - same as before for compatibility
+type Queue interface {
+	Enqueue(ctx context.Context, msg *model.QueueMessage) error
+	Dequeue(ctx context.Context) (*model.QueueMessage, error)
+	// MarkCompleted acknowledges a dequeued message so it is not delivered
+	// again.
+	MarkCompleted(ctx context.Context, msg *model.QueueMessage) error
+	// MarkFailed moves a message that exhausted its retries to the dead-letter
+	// queue, recording why it failed.
+	MarkFailed(ctx context.Context, msg *model.QueueMessage, reason string) error
+	// Retry re-enqueues a message that failed processing so it is delivered
+	// again no earlier than msg.ProcessAt.
+	Retry(ctx context.Context, msg *model.QueueMessage) error
+	// Requeue gives a dequeued message back to the queue unprocessed, without
+	// using up an attempt, e.g. when the worker shuts down mid-message.
+	Requeue(ctx context.Context, msg *model.QueueMessage) error
+	GetPendingCount(ctx context.Context) int
+	Close() error
+}
+
+// Consumer is implemented by queues that can push messages to the worker
+// instead of being polled with Dequeue.
+type Consumer interface {
+	// Consume starts delivering due messages on the returned channel, with at
+	// most prefetch of them unacknowledged at any time. The channel is closed
+	// once StopConsuming has been called and every message already received
+	// has been handed over.
+	Consume(prefetch int) (<-chan *model.QueueMessage, error)
+	StopConsuming() error
+}
+
+// HealthChecker is implemented by queues that can lose their connection.
+// Healthy returns nil while the queue is usable and the reason otherwise.
+type HealthChecker interface {
+	Healthy() error
+}
+
+// DeadLetterPurger is implemented by queues that keep their own copy of
+// dead-lettered messages.
+type DeadLetterPurger interface {
+	PurgeDeadLetters(ctx context.Context) (int, error)
+}
+
+// Helper functions - same as before for compatibility
+func CreateLaborCostMessage(sessionID int, employeeID string, hoursWorked float64, date string) (*model.QueueMessage, error) {
+	return newMessage(payload.TypeLaborCostReport, model.LaborCostReport{
+		EmployeeID:     employeeID,
+		HoursWorked:    hoursWorked,
+		Date:           date,
+		SessionID:      sessionID,
+		IdempotencyKey: LaborCostIdempotencyKey(sessionID),
+	}, 5)
+}
+
+// LaborCostIdempotencyKey returns the idempotency key of the labor cost
+// report for a work session. Every report for the same session - retried,
+// replayed or queued again - carries the same key.
+func LaborCostIdempotencyKey(sessionID int) string {
+	return fmt.Sprintf("work-session-%d", sessionID)
+}
+
+func CreateEmailMessage(employeeID string, hoursWorked float64, date string, checkinTime, checkoutTime time.Time) (*model.QueueMessage, error) {
+	return newMessage(payload.TypeEmailNotification, model.EmailNotification{
+		EmployeeID:   employeeID,
+		HoursWorked:  hoursWorked,
+		Date:         date,
+		CheckinTime:  &checkinTime,
+		CheckoutTime: &checkoutTime,
+	}, 3)
+}
+
+func newMessage(msgType string, v any, maxAttempts int) (*model.QueueMessage, error) {
+	data, version, err := payload.Encode(msgType, v)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &model.QueueMessage{
+		ID:            NewMessageID(),
+		Type:          msgType,
+		SchemaVersion: version,
+		Payload:       data,
+		MaxAttempts:   maxAttempts,
+		CreatedAt:     now,
+		ProcessAt:     now,
+		Status:        "pending",
+	}, nil
+}
+
+// NewMessageID returns a random identifier for a queue message. IDs are
+// assigned when a message is created, so the same message keeps its ID across
+// the outbox, retries and redeliveries.
+func NewMessageID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails if the OS entropy source is broken
+		panic(fmt.Sprintf("failed to generate message ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrNotConfirmed is returned when the broker did not take responsibility for
+// a published message: it nacked it, returned it as unroutable, or did not
+// confirm it in time. The message may or may not have been stored, so the
+// caller should publish it again.
+var ErrNotConfirmed = errors.New("publish not confirmed by broker")
+
+// Header carrying a per-publish token, used to match returned messages to the
+// publish they came from.
+const publishIDHeader = "x-publish-id"
+
+// publisher publishes on a channel in confirm mode. Publishes are serialised
+// so each one can wait for its own confirmation, which also makes the
+// returned messages of a publish arrive before its confirmation.
+type publisher struct {
+	mu       sync.Mutex
+	channel  amqpChannel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	timeout  time.Duration
+	sequence uint64 // delivery tag of the last publish
+}
+
+// newPublisher puts ch into confirm mode.
+func newPublisher(ch amqpChannel, timeout time.Duration) (*publisher, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	return &publisher{
+		channel:  ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 16)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 16)),
+		timeout:  timeout,
+	}, nil
+}
+
+// publish sends a mandatory message and waits until the broker has confirmed
+// it, the confirm timeout passes or ctx is done.
+func (p *publisher) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	publishID := NewMessageID()
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	msg.Headers[publishIDHeader] = publishID
+
+	if err := p.channel.Publish(exchange, routingKey, true, false, msg); err != nil {
+		return err
+	}
+	p.sequence++
+
+	timeout := time.NewTimer(p.timeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case confirm, ok := <-p.confirms:
+			if !ok {
+				return fmt.Errorf("channel closed before confirmation: %w", ErrUnavailable)
+			}
+			if confirm.DeliveryTag < p.sequence {
+				continue // late confirmation of a publish that timed out
+			}
+			if returned := p.returned(publishID); returned != nil {
+				return fmt.Errorf("%w: returned as %s", ErrNotConfirmed, returned.ReplyText)
+			}
+			if !confirm.Ack {
+				return fmt.Errorf("%w: nacked", ErrNotConfirmed)
+			}
+			return nil
+		case <-timeout.C:
+			return fmt.Errorf("%w: no confirmation after %s", ErrNotConfirmed, p.timeout)
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrNotConfirmed, ctx.Err())
+		}
+	}
+}
+
+// returned drains the returned messages received so far and reports the one
+// for publishID, if any. Returns of earlier publishes are only logged.
+func (p *publisher) returned(publishID string) *amqp.Return {
+	var match *amqp.Return
+	for {
+		select {
+		case r, ok := <-p.returns:
+			if !ok {
+				return match
+			}
+			if id, _ := r.Headers[publishIDHeader].(string); id == publishID {
+				match = &r
+				continue
+			}
+			log.Printf("Message %s was returned by the broker: %s", r.MessageId, r.ReplyText)
+		default:
+			return match
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func newTestPublisher(t *testing.T, ch *fakeChannel, timeout time.Duration) *publisher {
+	t.Helper()
+	p, err := newPublisher(ch, timeout)
+	if err != nil {
+		t.Fatalf("newPublisher: %v", err)
+	}
+	return p
+}
+
+func publishTest(p *publisher, id string) error {
+	return p.publish(context.Background(), "", taskQueueName, amqp.Publishing{MessageId: id, Body: []byte("{}")})
+}
+
+func TestPublisherConfirmed(t *testing.T) {
+	ch := newFakeChannel()
+	p := newTestPublisher(t, ch, time.Second)
+
+	if err := publishTest(p, "m1"); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if len(ch.published) != 1 || ch.published[0].key != taskQueueName {
+		t.Fatalf("published %+v, want one message to %s", ch.published, taskQueueName)
+	}
+	if _, ok := ch.published[0].msg.Headers[publishIDHeader].(string); !ok {
+		t.Fatalf("published without a %s header", publishIDHeader)
+	}
+}
+
+func TestPublisherNacked(t *testing.T) {
+	ch := newFakeChannel()
+	ch.nack = true
+	p := newTestPublisher(t, ch, time.Second)
+
+	err := publishTest(p, "m1")
+	if !errors.Is(err, ErrNotConfirmed) || !strings.Contains(err.Error(), "nacked") {
+		t.Fatalf("publish = %v, want a nack reported as ErrNotConfirmed", err)
+	}
+}
+
+func TestPublisherReturned(t *testing.T) {
+	ch := newFakeChannel()
+	ch.unroutable = true
+	p := newTestPublisher(t, ch, time.Second)
+
+	// The broker acks a returned message, so the return must win
+	err := publishTest(p, "m1")
+	if !errors.Is(err, ErrNotConfirmed) || !strings.Contains(err.Error(), "NO_ROUTE") {
+		t.Fatalf("publish = %v, want the return reported as ErrNotConfirmed", err)
+	}
+
+	// A leftover return from another publish doesn't fail this one
+	ch.unroutable = false
+	p.returns <- amqp.Return{ReplyText: "NO_ROUTE", Headers: amqp.Table{publishIDHeader: "earlier"}}
+	if err := publishTest(p, "m2"); err != nil {
+		t.Fatalf("publish after a stray return: %v", err)
+	}
+}
+
+func TestPublisherConfirmTimeout(t *testing.T) {
+	ch := newFakeChannel()
+	ch.withholdConfirms = true
+	p := newTestPublisher(t, ch, 20*time.Millisecond)
+
+	start := time.Now()
+	err := publishTest(p, "m1")
+	if !errors.Is(err, ErrNotConfirmed) || !strings.Contains(err.Error(), "no confirmation") {
+		t.Fatalf("publish = %v, want a timeout reported as ErrNotConfirmed", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("gave up after %v, before the confirm timeout", elapsed)
+	}
+
+	// The late confirmation of the first publish is skipped, not taken as
+	// the confirmation of the next one
+	ch.withholdConfirms = false
+	ch.nack = true
+	p.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	if err := publishTest(p, "m2"); !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("publish = %v, want its own nack rather than the late ack", err)
+	}
+}
+
+func TestPublisherCancelled(t *testing.T) {
+	ch := newFakeChannel()
+	ch.withholdConfirms = true
+	p := newTestPublisher(t, ch, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := p.publish(ctx, "", taskQueueName, amqp.Publishing{MessageId: "m1"})
+	if !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("publish = %v, want ErrNotConfirmed", err)
+	}
+}
+
+func TestPublisherChannelClosed(t *testing.T) {
+	ch := newFakeChannel()
+	ch.withholdConfirms = true
+	p := newTestPublisher(t, ch, time.Minute)
+
+	close(p.confirms)
+	if err := publishTest(p, "m1"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("publish = %v, want ErrUnavailable", err)
+	}
+}
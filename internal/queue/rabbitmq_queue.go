@@ -1,67 +1,342 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/omaaartamer/factory-checkin-api/internal/model"
 	"github.com/streadway/amqp"
 )
 
-// Queue interface - same as before for compatibility
-type Queue interface {
-	Enqueue(msg *model.QueueMessage) error
-	Dequeue() (*model.QueueMessage, error)
-	MarkCompleted(messageID string) error
-	MarkFailed(messageID string) error
-	GetPendingCount() int
-	Close() error
+const (
+	taskQueueName      = "factory_checkin_tasks"
+	deadLetterExchange = taskQueueName + ".dlx"
+	deadLetterQueue    = taskQueueName + ".dead"
+)
+
+// RabbitMQ only expires messages at the head of a queue, so delayed messages
+// are parked in a set of delay queues that each have a single fixed TTL and
+// dead-letter back into the task queue. A message goes to the longest bucket
+// that does not overshoot its ProcessAt; anything still early when it comes
+// back is parked again for the remainder. Messages due sooner than the
+// shortest bucket are published straight to the task queue.
+var delayBuckets = []time.Duration{
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
 }
 
+// Dequeue gives up after skipping this many deliveries that were not ready to
+// process, so a queue full of parked messages can't keep it busy forever.
+const maxDequeueSkips = 100
+
+// Delays between reconnection attempts after the broker connection is lost.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// RabbitMQQueue consumes with manual acknowledgements: a dequeued message
+// stays unacknowledged on the broker until the worker completes, retries,
+// dead-letters or requeues it, so a crash mid-processing redelivers it.
+//
+// When the connection or channel closes unexpectedly the queue reconnects
+// with backoff, declares its topology again and resumes consuming. While it
+// is disconnected, operations fail with ErrUnavailable instead of buffering:
+// publishes come from the outbox, which keeps the message until the broker is
+// back. Deliveries outstanding when the connection dropped are redelivered by
+// the broker, so acknowledging them returns ErrUnknownDelivery.
+//
+// Publishes use mandatory routing and publisher confirms: Enqueue returns nil
+// only once the broker has stored the message.
 type RabbitMQQueue struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	queue   amqp.Queue
+	url            string
+	confirmTimeout time.Duration
+	dial           func(url string) (amqpConnection, error)
+
+	connMu     sync.RWMutex
+	conn       amqpConnection
+	channel    amqpChannel // nil while disconnected
+	publisher  *publisher
+	generation int   // incremented on every reconnect
+	lastErr    error // why the last connection closed
+	closed     chan struct{}
+	closeOnce  sync.Once
+
+	mu          sync.Mutex
+	inFlight    map[string]amqp.Delivery // unacknowledged deliveries by receipt
+	consumer    *consumer
+	consumerTag string
+}
+
+// amqpChannel is the part of *amqp.Channel the queue and its publisher use.
+type amqpChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueInspect(name string) (amqp.Queue, error)
+	QueuePurge(name string, noWait bool) (int, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	Close() error
+}
+
+// amqpConnection is the part of *amqp.Connection the queue uses.
+type amqpConnection interface {
+	Channel() (amqpChannel, error)
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// brokerConnection adapts *amqp.Connection to amqpConnection.
+type brokerConnection struct {
+	*amqp.Connection
+}
+
+func (c brokerConnection) Channel() (amqpChannel, error) {
+	ch, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func dialBroker(url string) (amqpConnection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return brokerConnection{conn}, nil
+}
+
+// consumer carries Consume's state across reconnects: each new channel's
+// deliveries are fed to the same output channel.
+type consumer struct {
+	prefetch int
+	feed     chan consumerFeed
+	stop     chan struct{}
+}
+
+type consumerFeed struct {
+	deliveries <-chan amqp.Delivery
+	generation int
 }
 
-func NewRabbitMQQueue(rabbitMQURL string) (*RabbitMQQueue, error) {
+// NewRabbitMQQueue connects to the broker. Each publish waits up to
+// confirmTimeout for the broker to confirm it.
+func NewRabbitMQQueue(rabbitMQURL string, confirmTimeout time.Duration) (*RabbitMQQueue, error) {
+	return newRabbitMQQueue(rabbitMQURL, confirmTimeout, dialBroker)
+}
+
+func newRabbitMQQueue(rabbitMQURL string, confirmTimeout time.Duration, dial func(string) (amqpConnection, error)) (*RabbitMQQueue, error) {
+	q := &RabbitMQQueue{
+		url:            rabbitMQURL,
+		confirmTimeout: confirmTimeout,
+		dial:           dial,
+		inFlight:       make(map[string]amqp.Delivery),
+		closed:         make(chan struct{}),
+	}
+	if err := q.connect(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// connect dials the broker, declares the topology and starts watching the
+// new connection. Consumption is resumed if Consume was called before.
+func (q *RabbitMQQueue) connect() error {
 	// Connect to RabbitMQ
-	conn, err := amqp.Dial(rabbitMQURL)
+	conn, err := q.dial(q.url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
 	// Create channel
 	ch, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to open channel: %w", err)
+		return fmt.Errorf("failed to open channel: %w", err)
 	}
 
-	// Declare queue
-	q, err := ch.QueueDeclare(
-		"factory_checkin_tasks", // queue name
-		true,                    // durable
-		false,                   // delete when unused
-		false,                   // exclusive
-		false,                   // no-wait
-		nil,                     // arguments
-	)
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chanClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	if err := declareTopology(ch); err != nil {
+		ch.Close()
+		conn.Close()
+		return err
+	}
+
+	publisher, err := newPublisher(ch, q.confirmTimeout)
 	if err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("failed to declare queue: %w", err)
+		return err
 	}
 
-	return &RabbitMQQueue{
-		conn:    conn,
-		channel: ch,
-		queue:   q,
-	}, nil
+	q.connMu.Lock()
+	generation := q.generation + 1
+	q.connMu.Unlock()
+
+	q.mu.Lock()
+	if q.consumer != nil {
+		err = q.startConsumer(ch, generation, q.consumer)
+	}
+	q.mu.Unlock()
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		return err
+	}
+
+	q.connMu.Lock()
+	select {
+	case <-q.closed:
+		// Close was called while reconnecting
+		q.connMu.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	q.conn = conn
+	q.channel = ch
+	q.publisher = publisher
+	q.generation = generation
+	q.lastErr = nil
+	q.connMu.Unlock()
+
+	go q.watch(connClosed, chanClosed)
+	return nil
 }
 
-func (q *RabbitMQQueue) Enqueue(msg *model.QueueMessage) error {
+// watch waits for the connection or its channel to close and reconnects
+// unless the queue itself is being closed.
+func (q *RabbitMQQueue) watch(connClosed, chanClosed chan *amqp.Error) {
+	var reason *amqp.Error
+	select {
+	case reason = <-connClosed:
+	case reason = <-chanClosed:
+	case <-q.closed:
+		return
+	}
+
+	select {
+	case <-q.closed:
+		return
+	default:
+	}
+
+	cause := fmt.Errorf("connection closed")
+	if reason != nil {
+		cause = reason
+	}
+	log.Printf("RabbitMQ connection lost: %v", cause)
+	q.disconnect(cause)
+	q.reconnect()
+}
+
+// disconnect marks the queue unavailable and forgets every outstanding
+// delivery, which the broker will deliver again.
+func (q *RabbitMQQueue) disconnect(cause error) {
+	q.connMu.Lock()
+	conn := q.conn
+	q.conn = nil
+	q.channel = nil
+	q.publisher = nil
+	q.lastErr = cause
+	q.connMu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+
+	q.mu.Lock()
+	q.inFlight = make(map[string]amqp.Delivery)
+	q.consumerTag = ""
+	q.mu.Unlock()
+}
+
+func (q *RabbitMQQueue) reconnect() {
+	delay := minReconnectDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-q.closed:
+			return
+		case <-time.After(delay):
+		}
+
+		err := q.connect()
+		if err == nil {
+			log.Printf("RabbitMQ reconnected after %d attempt(s)", attempt)
+			return
+		}
+
+		log.Printf("RabbitMQ reconnect attempt %d failed: %v", attempt, err)
+		q.connMu.Lock()
+		q.lastErr = err
+		q.connMu.Unlock()
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// currentChannel returns the open channel and its generation.
+func (q *RabbitMQQueue) currentChannel() (amqpChannel, int, error) {
+	q.connMu.RLock()
+	defer q.connMu.RUnlock()
+
+	if q.channel == nil {
+		return nil, 0, q.unavailable()
+	}
+	return q.channel, q.generation, nil
+}
+
+func (q *RabbitMQQueue) currentPublisher() (*publisher, error) {
+	q.connMu.RLock()
+	defer q.connMu.RUnlock()
+
+	if q.publisher == nil {
+		return nil, q.unavailable()
+	}
+	return q.publisher, nil
+}
+
+// unavailable describes why the queue is disconnected. connMu must be held.
+func (q *RabbitMQQueue) unavailable() error {
+	if q.lastErr != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, q.lastErr)
+	}
+	return ErrUnavailable
+}
+
+// Healthy reports whether the queue is connected to the broker.
+func (q *RabbitMQQueue) Healthy() error {
+	_, _, err := q.currentChannel()
+	return err
+}
+
+func (q *RabbitMQQueue) Enqueue(ctx context.Context, msg *model.QueueMessage) error {
 	// Set defaults
 	if msg.Status == "" {
 		msg.Status = "pending"
@@ -70,73 +345,378 @@ func (q *RabbitMQQueue) Enqueue(msg *model.QueueMessage) error {
 		msg.MaxAttempts = 5
 	}
 
+	if err := q.publish(ctx, msg); err != nil {
+		return err
+	}
+
+	log.Printf("📤 Enqueued message: %s (Type: %s)", msg.ID, msg.Type)
+	return nil
+}
+
+// Retry publishes the next attempt before acknowledging the current
+// delivery; if publishing fails the delivery is requeued instead, so the
+// message is never lost.
+func (q *RabbitMQQueue) Retry(ctx context.Context, msg *model.QueueMessage) error {
+	msg.Status = "pending"
+	if err := q.publish(ctx, msg); err != nil {
+		q.nack(msg, true)
+		return err
+	}
+	return q.ack(msg)
+}
+
+// publish sends msg to the task queue, or to a delay queue if its ProcessAt
+// is still in the future.
+func (q *RabbitMQQueue) publish(ctx context.Context, msg *model.QueueMessage) error {
 	// Serialize message
 	body, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	// Publish to queue
-	err = q.channel.Publish(
-		"",           // exchange
-		q.queue.Name, // routing key (queue name)
-		false,        // mandatory
-		false,        // immediate
+	routingKey := taskQueueName
+	if bucket, ok := delayBucketFor(time.Until(msg.ProcessAt)); ok {
+		routingKey = delayQueueName(bucket)
+	}
+
+	publisher, err := q.currentPublisher()
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	// Publish to queue and wait for the broker to confirm it
+	err = publisher.publish(
+		ctx,
+		"",         // exchange
+		routingKey, // routing key (queue name)
 		amqp.Publishing{
 			ContentType:  "application/json",
 			Body:         body,
 			DeliveryMode: amqp.Persistent, // make message persistent
+			MessageId:    msg.ID,
 		},
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to publish message: %w", err)
 	}
-
-	log.Printf("📤 Enqueued message: %s (Type: %s)", msg.ID, msg.Type)
 	return nil
 }
 
-func (q *RabbitMQQueue) Dequeue() (*model.QueueMessage, error) {
-	// Get one message
-	delivery, ok, err := q.channel.Get(q.queue.Name, true) // auto-ack
+func (q *RabbitMQQueue) Dequeue(ctx context.Context) (*model.QueueMessage, error) {
+	ch, generation, err := q.currentChannel()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get message: %w", err)
 	}
 
-	if !ok {
-		return nil, nil // No message available
+	for i := 0; i < maxDequeueSkips; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Get one message, acknowledged later by the worker
+		delivery, ok, err := ch.Get(taskQueueName, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message: %w", err)
+		}
+
+		if !ok {
+			return nil, nil // No message available
+		}
+
+		// Deliveries handled by receive itself leave nothing to process;
+		// look at the next one
+		if msg := q.receive(ctx, delivery, generation); msg != nil {
+			return msg, nil
+		}
+	}
+	return nil, nil
+}
+
+func (q *RabbitMQQueue) Consume(prefetch int) (<-chan *model.QueueMessage, error) {
+	ch, generation, err := q.currentChannel()
+	if err != nil {
+		return nil, err
 	}
 
-	// Deserialize message
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.consumer != nil {
+		return nil, fmt.Errorf("already consuming %s", taskQueueName)
+	}
+
+	c := &consumer{
+		prefetch: prefetch,
+		feed:     make(chan consumerFeed, 1),
+		stop:     make(chan struct{}),
+	}
+	if err := q.startConsumer(ch, generation, c); err != nil {
+		return nil, err
+	}
+	q.consumer = c
+
+	messages := make(chan *model.QueueMessage)
+	go func() {
+		defer close(messages)
+		for {
+			select {
+			case deliveries := <-c.feed:
+				// Ends when the consumer is cancelled or its channel closes
+				for delivery := range deliveries.deliveries {
+					if msg := q.receive(context.Background(), delivery, deliveries.generation); msg != nil {
+						messages <- msg
+					}
+				}
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Consuming %s with prefetch %d", taskQueueName, prefetch)
+	return messages, nil
+}
+
+// startConsumer subscribes c to the task queue on ch. q.mu must be held.
+func (q *RabbitMQQueue) startConsumer(ch amqpChannel, generation int, c *consumer) error {
+	if err := ch.Qos(c.prefetch, 0, false); err != nil {
+		return fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	tag := "factory-checkin-worker-" + NewMessageID()[:8]
+	deliveries, err := ch.Consume(
+		taskQueueName, // queue
+		tag,           // consumer tag
+		false,         // auto-ack
+		false,         // exclusive
+		false,         // no-local
+		false,         // no-wait
+		nil,           // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start consumer: %w", err)
+	}
+
+	q.consumerTag = tag
+	// Drop the feed of a connection that was lost before it was picked up
+	select {
+	case <-c.feed:
+	default:
+	}
+	c.feed <- consumerFeed{deliveries: deliveries, generation: generation}
+	return nil
+}
+
+func (q *RabbitMQQueue) StopConsuming() error {
+	q.mu.Lock()
+	c := q.consumer
+	tag := q.consumerTag
+	q.consumer = nil
+	q.consumerTag = ""
+	q.mu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+	close(c.stop)
+
+	if tag == "" {
+		return nil // disconnected; there is nothing to cancel
+	}
+	ch, _, err := q.currentChannel()
+	if err != nil {
+		return nil
+	}
+	return ch.Cancel(tag, false)
+}
+
+// receive decodes a delivery and tracks it for acknowledgement. Malformed
+// bodies come back as TypeMalformed messages for the worker to dead-letter.
+// It returns nil for messages that are not due yet, which are parked again,
+// and for redeliveries it has published again to record the lost attempt.
+func (q *RabbitMQQueue) receive(ctx context.Context, delivery amqp.Delivery, generation int) *model.QueueMessage {
 	var msg model.QueueMessage
 	if err := json.Unmarshal(delivery.Body, &msg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+		log.Printf("Received malformed message (delivery %d): %v", delivery.DeliveryTag, err)
+		malformed := malformedMessage(delivery.Body, err)
+		q.track(malformed, delivery, generation)
+		return malformed
+	}
+
+	// Not due yet - park it for the remaining delay
+	if _, early := delayBucketFor(time.Until(msg.ProcessAt)); early {
+		if err := q.publish(ctx, &msg); err != nil {
+			log.Printf("Failed to park early message %s: %v", msg.ID, err)
+			delivery.Nack(false, true)
+			return nil
+		}
+		delivery.Ack(false)
+		return nil
 	}
 
-	msg.Status = "processing"
 	msg.Attempts++
 
-	log.Printf("Dequeued message: %s (Type: %s, Attempt: %d)", msg.ID, msg.Type, msg.Attempts)
-	return &msg, nil
+	// A redelivered message was never settled: the worker holding it died or
+	// lost its connection. That used up an attempt, or a message that crashes
+	// the worker would come back forever. The flag carries no count, so the
+	// attempt is recorded by publishing the message again; once none are left
+	// the worker dead-letters it without processing it.
+	if delivery.Redelivered {
+		if msg.MaxAttempts <= 0 || msg.Attempts < msg.MaxAttempts {
+			if err := q.publish(ctx, &msg); err != nil {
+				log.Printf("Failed to record redelivery of message %s: %v", msg.ID, err)
+				delivery.Nack(false, true)
+				return nil
+			}
+			delivery.Ack(false)
+			return nil
+		}
+		if msg.DeadLetterReason == "" {
+			msg.DeadLetterReason = fmt.Sprintf("delivered %d times without being acknowledged", msg.Attempts)
+		}
+	}
+
+	msg.Status = "processing"
+	q.track(&msg, delivery, generation)
+
+	log.Printf("Received message: %s (Type: %s, Attempt: %d)", msg.ID, msg.Type, msg.Attempts)
+	return &msg
 }
 
-func (q *RabbitMQQueue) MarkCompleted(messageID string) error {
-	log.Printf("Message completed: %s", messageID)
-	// With auto-ack, message is already removed from queue
+func (q *RabbitMQQueue) MarkCompleted(ctx context.Context, msg *model.QueueMessage) error {
+	if err := q.ack(msg); err != nil {
+		return err
+	}
+	log.Printf("Message completed: %s", msg.ID)
 	return nil
 }
 
-func (q *RabbitMQQueue) MarkFailed(messageID string) error {
-	log.Printf("Message failed: %s", messageID)
-	// In a production system, you'd implement dead letter queues here
-	// For now, we'll just log the failure
+// Requeue publishes the message again as it was received and acknowledges
+// the delivery, rather than nacking it, so that the broker's redelivered flag
+// only marks deliveries that were never settled. If publishing fails the
+// delivery is nacked instead, and its redelivery counts as an attempt.
+func (q *RabbitMQQueue) Requeue(ctx context.Context, msg *model.QueueMessage) error {
+	again := *msg
+	again.Attempts--
+	again.Status = "pending"
+	if err := q.publish(ctx, &again); err != nil {
+		return q.nack(msg, true)
+	}
+	return q.ack(msg)
+}
+
+func (q *RabbitMQQueue) MarkFailed(ctx context.Context, msg *model.QueueMessage, reason string) error {
+	msg.Status = "failed"
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		q.nack(msg, true)
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	if err := q.publishDeadLetter(ctx, body, reason); err != nil {
+		q.nack(msg, true)
+		return err
+	}
+
+	log.Printf("Message failed: %s moved to dead-letter queue (%s)", msg.ID, reason)
+	return q.ack(msg)
+}
+
+func (q *RabbitMQQueue) publishDeadLetter(ctx context.Context, body []byte, reason string) error {
+	publisher, err := q.currentPublisher()
+	if err != nil {
+		return fmt.Errorf("failed to dead-letter message: %w", err)
+	}
+
+	err = publisher.publish(
+		ctx,
+		deadLetterExchange, // exchange
+		taskQueueName,      // routing key
+		amqp.Publishing{
+			ContentType:  "application/json",
+			Body:         body,
+			DeliveryMode: amqp.Persistent,
+			Headers: amqp.Table{
+				"x-failure-reason": reason,
+				"x-failed-at":      time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to dead-letter message: %w", err)
+	}
 	return nil
 }
 
-func (q *RabbitMQQueue) GetPendingCount() int {
+// track remembers the delivery behind msg until it is acknowledged. Delivery
+// tags restart on every channel, so the receipt includes the connection
+// generation to keep a stale receipt from matching a new delivery.
+func (q *RabbitMQQueue) track(msg *model.QueueMessage, delivery amqp.Delivery, generation int) {
+	msg.Receipt = fmt.Sprintf("%d.%d", generation, delivery.DeliveryTag)
+
+	q.mu.Lock()
+	q.inFlight[msg.Receipt] = delivery
+	q.mu.Unlock()
+}
+
+// settle removes and returns the delivery behind msg.
+func (q *RabbitMQQueue) settle(msg *model.QueueMessage) (amqp.Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delivery, ok := q.inFlight[msg.Receipt]
+	if !ok {
+		return amqp.Delivery{}, fmt.Errorf("message %s: %w", msg.ID, ErrUnknownDelivery)
+	}
+	delete(q.inFlight, msg.Receipt)
+	return delivery, nil
+}
+
+func (q *RabbitMQQueue) ack(msg *model.QueueMessage) error {
+	delivery, err := q.settle(msg)
+	if err != nil {
+		return err
+	}
+	if err := delivery.Ack(false); err != nil {
+		return fmt.Errorf("failed to ack message %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+func (q *RabbitMQQueue) nack(msg *model.QueueMessage, requeue bool) error {
+	delivery, err := q.settle(msg)
+	if err != nil {
+		return err
+	}
+	if err := delivery.Nack(false, requeue); err != nil {
+		return fmt.Errorf("failed to nack message %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+func (q *RabbitMQQueue) PurgeDeadLetters(ctx context.Context) (int, error) {
+	ch, _, err := q.currentChannel()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead-letter queue: %w", err)
+	}
+
+	purged, err := ch.QueuePurge(deadLetterQueue, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead-letter queue: %w", err)
+	}
+	return purged, nil
+}
+
+func (q *RabbitMQQueue) GetPendingCount(ctx context.Context) int {
+	ch, _, err := q.currentChannel()
+	if err != nil {
+		log.Printf("Failed to inspect queue: %v", err)
+		return 0
+	}
+
 	// Inspect queue to get message count
-	info, err := q.channel.QueueInspect(q.queue.Name)
+	info, err := ch.QueueInspect(taskQueueName)
 	if err != nil {
 		log.Printf("Failed to inspect queue: %v", err)
 		return 0
@@ -145,36 +725,84 @@ func (q *RabbitMQQueue) GetPendingCount() int {
 }
 
 func (q *RabbitMQQueue) Close() error {
-	if q.channel != nil {
-		q.channel.Close()
+	q.closeOnce.Do(func() { close(q.closed) })
+
+	q.connMu.Lock()
+	ch, conn := q.channel, q.conn
+	q.channel = nil
+	q.publisher = nil
+	q.conn = nil
+	q.lastErr = fmt.Errorf("queue closed")
+	q.connMu.Unlock()
+
+	if ch != nil {
+		ch.Close()
 	}
-	if q.conn != nil {
-		q.conn.Close()
+	if conn != nil {
+		conn.Close()
 	}
 	return nil
 }
 
-// Helper functions - same as before for compatibility
-func CreateLaborCostMessage(employeeID string, hoursWorked float64, date string) *model.QueueMessage {
-	return &model.QueueMessage{
-		Type: "labor_cost_report",
-		Payload: map[string]interface{}{
-			"employee_id":  employeeID,
-			"hours_worked": hoursWorked,
-			"date":         date,
-		},
-		MaxAttempts: 5,
+// declareTopology declares the task queue, the delay queues that feed it and
+// the dead-letter exchange and queue. It runs on every (re)connect, since the
+// broker may have lost non-durable state or been replaced.
+func declareTopology(ch amqpChannel) error {
+	// Declare queue
+	_, err := ch.QueueDeclare(
+		taskQueueName, // queue name
+		true,          // durable
+		false,         // delete when unused
+		false,         // exclusive
+		false,         // no-wait
+		nil,           // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare queue: %w", err)
+	}
+
+	// Declare delay queues used for scheduled delivery
+	for _, bucket := range delayBuckets {
+		_, err := ch.QueueDeclare(delayQueueName(bucket), true, false, false, false, amqp.Table{
+			"x-message-ttl":             int64(bucket / time.Millisecond),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": taskQueueName,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to declare delay queue: %w", err)
+		}
 	}
+
+	// Declare dead-letter exchange and queue
+	return declareDeadLetterQueue(ch)
 }
 
-func CreateEmailMessage(employeeID string, hoursWorked float64, date string) *model.QueueMessage {
-	return &model.QueueMessage{
-		Type: "email_notification",
-		Payload: map[string]interface{}{
-			"employee_id":  employeeID,
-			"hours_worked": hoursWorked,
-			"date":         date,
-		},
-		MaxAttempts: 3,
+func declareDeadLetterQueue(ch amqpChannel) error {
+	if err := ch.ExchangeDeclare(deadLetterExchange, "direct", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter exchange: %w", err)
+	}
+	if _, err := ch.QueueDeclare(deadLetterQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
+	if err := ch.QueueBind(deadLetterQueue, taskQueueName, deadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue: %w", err)
+	}
+	return nil
+}
+
+func delayQueueName(bucket time.Duration) string {
+	return fmt.Sprintf("%s.delay.%dms", taskQueueName, bucket/time.Millisecond)
+}
+
+// delayBucketFor picks the longest delay bucket that does not exceed delay.
+// It returns false if no bucket is that short, meaning the message is due.
+func delayBucketFor(delay time.Duration) (time.Duration, bool) {
+	var bucket time.Duration
+	for _, b := range delayBuckets {
+		if b > delay {
+			break
+		}
+		bucket = b
 	}
+	return bucket, bucket > 0
 }
@@ -0,0 +1,594 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/streadway/amqp"
+)
+
+// fakeChannel stands in for a broker channel. Publishes are confirmed at
+// once unless the test sets nack, unroutable or withholdConfirms. Methods the
+// tests don't need panic through the embedded nil interface.
+type fakeChannel struct {
+	amqpChannel
+
+	mu         sync.Mutex
+	prefetch   int
+	autoAck    bool
+	consumers  []string
+	cancelled  []string
+	deliveries chan amqp.Delivery
+	declared   []string // queues, exchanges and bindings, in order
+	published  []publishing
+	sequence   uint64
+	confirms   chan amqp.Confirmation
+	returns    chan amqp.Return
+	closed     bool
+
+	nack             bool // nack every publish
+	unroutable       bool // return every publish as unroutable
+	withholdConfirms bool // never confirm
+}
+
+type publishing struct {
+	exchange, key string
+	msg           amqp.Publishing
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{deliveries: make(chan amqp.Delivery)}
+}
+
+func (ch *fakeChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (ch *fakeChannel) NotifyPublish(c chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.confirms = c
+	return c
+}
+
+func (ch *fakeChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.returns = c
+	return c
+}
+
+func (ch *fakeChannel) NotifyClose(c chan *amqp.Error) chan *amqp.Error {
+	return c
+}
+
+func (ch *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.published = append(ch.published, publishing{exchange: exchange, key: key, msg: msg})
+	ch.sequence++
+	if ch.unroutable && ch.returns != nil {
+		ch.returns <- amqp.Return{ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE", MessageId: msg.MessageId, Headers: msg.Headers}
+	}
+	if ch.confirms != nil && !ch.withholdConfirms {
+		ch.confirms <- amqp.Confirmation{DeliveryTag: ch.sequence, Ack: !ch.nack}
+	}
+	return nil
+}
+
+// publishedMessages decodes everything published so far.
+func (ch *fakeChannel) publishedMessages(t *testing.T) []model.QueueMessage {
+	t.Helper()
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	var out []model.QueueMessage
+	for _, p := range ch.published {
+		var msg model.QueueMessage
+		if err := json.Unmarshal(p.msg.Body, &msg); err != nil {
+			t.Fatalf("published body: %v", err)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+func (ch *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.declared = append(ch.declared, "queue "+name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.declared = append(ch.declared, "exchange "+name)
+	return nil
+}
+
+func (ch *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.declared = append(ch.declared, "bind "+name+" to "+exchange)
+	return nil
+}
+
+func (ch *fakeChannel) Close() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.closed = true
+	return nil
+}
+
+func (ch *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.prefetch = prefetchCount
+	return nil
+}
+
+func (ch *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.autoAck = autoAck
+	ch.consumers = append(ch.consumers, consumer)
+	return ch.deliveries, nil
+}
+
+func (ch *fakeChannel) Cancel(consumer string, noWait bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.cancelled = append(ch.cancelled, consumer)
+	return nil
+}
+
+// acknowledger records how each delivery tag was settled.
+type acknowledger struct {
+	mu      sync.Mutex
+	settled map[uint64]string
+}
+
+func newAcknowledger() *acknowledger {
+	return &acknowledger{settled: make(map[uint64]string)}
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	return a.record(tag, "ack")
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		return a.record(tag, "requeue")
+	}
+	return a.record(tag, "nack")
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func (a *acknowledger) record(tag uint64, how string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.settled[tag]; ok {
+		return errors.New("delivery tag settled twice")
+	}
+	a.settled[tag] = how
+	return nil
+}
+
+func (a *acknowledger) get(tag uint64) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.settled[tag]
+}
+
+// newConnectedQueue returns a queue that behaves as if connected over ch.
+// It can't publish unless given a publisher.
+func newConnectedQueue(ch amqpChannel) *RabbitMQQueue {
+	return &RabbitMQQueue{
+		confirmTimeout: time.Second,
+		channel:        ch,
+		generation:     1,
+		inFlight:       make(map[string]amqp.Delivery),
+		closed:         make(chan struct{}),
+	}
+}
+
+// newPublishingQueue returns a connected queue that publishes on ch.
+func newPublishingQueue(t *testing.T, ch *fakeChannel) *RabbitMQQueue {
+	t.Helper()
+	q := newConnectedQueue(ch)
+	publisher, err := newPublisher(ch, time.Second)
+	if err != nil {
+		t.Fatalf("newPublisher: %v", err)
+	}
+	q.publisher = publisher
+	return q
+}
+
+// fakeConnection is a broker connection the test can drop.
+type fakeConnection struct {
+	channel *fakeChannel
+	mu      sync.Mutex
+	notify  chan *amqp.Error
+	closed  bool
+}
+
+func (c *fakeConnection) Channel() (amqpChannel, error) {
+	return c.channel, nil
+}
+
+func (c *fakeConnection) NotifyClose(ch chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify = ch
+	return ch
+}
+
+func (c *fakeConnection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// drop closes the connection from the broker's side, which ends its
+// consumers.
+func (c *fakeConnection) drop(reason string) {
+	c.channel.mu.Lock()
+	close(c.channel.deliveries)
+	c.channel.mu.Unlock()
+
+	c.mu.Lock()
+	notify := c.notify
+	c.mu.Unlock()
+	notify <- &amqp.Error{Code: amqp.ConnectionForced, Reason: reason}
+}
+
+// fakeBroker hands out a new connection on every dial.
+type fakeBroker struct {
+	mu          sync.Mutex
+	connections []*fakeConnection
+}
+
+func (b *fakeBroker) dial(url string) (amqpConnection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	conn := &fakeConnection{channel: newFakeChannel()}
+	b.connections = append(b.connections, conn)
+	return conn, nil
+}
+
+func (b *fakeBroker) connection(i int) *fakeConnection {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if i >= len(b.connections) {
+		return nil
+	}
+	return b.connections[i]
+}
+
+// eventually polls cond until it holds or the test times out.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testDelivery(t *testing.T, ack amqp.Acknowledger, tag uint64, msg *model.QueueMessage) amqp.Delivery {
+	t.Helper()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{Acknowledger: ack, DeliveryTag: tag, Body: body}
+}
+
+func TestDelayBucketFor(t *testing.T) {
+	tests := []struct {
+		delay  time.Duration
+		bucket time.Duration
+		ok     bool
+	}{
+		{-time.Second, 0, false},
+		{0, 0, false},
+		{499 * time.Millisecond, 0, false},
+		{500 * time.Millisecond, 500 * time.Millisecond, true},
+		{750 * time.Millisecond, 500 * time.Millisecond, true},
+		{999 * time.Millisecond, 500 * time.Millisecond, true},
+		{time.Second, time.Second, true},
+		{4 * time.Second, time.Second, true},
+		{90 * time.Second, time.Minute, true},
+		{3 * time.Hour, time.Hour, true},
+	}
+	for _, tt := range tests {
+		bucket, ok := delayBucketFor(tt.delay)
+		if bucket != tt.bucket || ok != tt.ok {
+			t.Errorf("delayBucketFor(%s) = %s, %v; want %s, %v", tt.delay, bucket, ok, tt.bucket, tt.ok)
+		}
+	}
+
+	// No bucket ever delivers a message later than asked
+	for delay := time.Duration(0); delay < 2*time.Hour; delay += 250 * time.Millisecond {
+		if bucket, ok := delayBucketFor(delay); ok && bucket > delay {
+			t.Fatalf("delayBucketFor(%s) = %s, which overshoots", delay, bucket)
+		}
+	}
+}
+
+func TestRabbitMQReceiptSettlement(t *testing.T) {
+	ctx := context.Background()
+	q := newConnectedQueue(newFakeChannel())
+	ack := newAcknowledger()
+
+	receive := func(tag uint64) *model.QueueMessage {
+		t.Helper()
+		msg := q.receive(ctx, testDelivery(t, ack, tag, &model.QueueMessage{ID: NewMessageID(), Type: "test_message"}), 1)
+		if msg == nil {
+			t.Fatalf("delivery %d not received", tag)
+		}
+		return msg
+	}
+
+	completed := receive(1)
+	if completed.Receipt != "1.1" || completed.Attempts != 1 || completed.Status != "processing" {
+		t.Fatalf("received %+v, want receipt 1.1, attempt 1, processing", completed)
+	}
+	if err := q.MarkCompleted(ctx, completed); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if got := ack.get(1); got != "ack" {
+		t.Fatalf("completed delivery settled with %q, want ack", got)
+	}
+	if err := q.MarkCompleted(ctx, completed); !errors.Is(err, ErrUnknownDelivery) {
+		t.Fatalf("second MarkCompleted = %v, want ErrUnknownDelivery", err)
+	}
+
+	// Without a publisher Requeue falls back to a nack
+	requeued := receive(2)
+	if err := q.Requeue(ctx, requeued); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	if got := ack.get(2); got != "requeue" {
+		t.Fatalf("requeued delivery settled with %q, want requeue", got)
+	}
+
+	// A dead letter that can't be published stays on the broker
+	failed := receive(3)
+	if err := q.MarkFailed(ctx, failed, "rejected"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("MarkFailed without a publisher = %v, want ErrUnavailable", err)
+	}
+	if got := ack.get(3); got != "requeue" {
+		t.Fatalf("unpublished dead letter settled with %q, want requeue", got)
+	}
+
+	// Deliveries outstanding when the connection drops are the broker's again
+	lost := receive(4)
+	q.disconnect(errors.New("connection reset"))
+	if err := q.MarkCompleted(ctx, lost); !errors.Is(err, ErrUnknownDelivery) {
+		t.Fatalf("MarkCompleted after a reconnect = %v, want ErrUnknownDelivery", err)
+	}
+	if got := ack.get(4); got != "" {
+		t.Fatalf("delivery from a lost connection settled with %q", got)
+	}
+
+	// Tags restart on a new channel; an old receipt must not settle a new delivery
+	q.channel, q.generation = newFakeChannel(), 2
+	current := q.receive(ctx, testDelivery(t, ack, 5, &model.QueueMessage{ID: NewMessageID()}), 2)
+	stale := *current
+	stale.Receipt = "1.5"
+	if err := q.MarkCompleted(ctx, &stale); !errors.Is(err, ErrUnknownDelivery) {
+		t.Fatalf("MarkCompleted with a stale receipt = %v, want ErrUnknownDelivery", err)
+	}
+	if err := q.MarkCompleted(ctx, current); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+}
+
+func TestRabbitMQReceiveMalformed(t *testing.T) {
+	q := newConnectedQueue(newFakeChannel())
+	ack := newAcknowledger()
+
+	msg := q.receive(context.Background(), amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, Body: []byte("{not json")}, 1)
+	if msg == nil || msg.Type != TypeMalformed || msg.Receipt == "" {
+		t.Fatalf("received %+v, want a tracked malformed message", msg)
+	}
+	if got := ack.get(1); got != "" {
+		t.Fatalf("malformed delivery settled with %q before the worker saw it", got)
+	}
+}
+
+func TestRabbitMQConsumeAcknowledgesManually(t *testing.T) {
+	ch := newFakeChannel()
+	q := newConnectedQueue(ch)
+	ack := newAcknowledger()
+
+	messages, err := q.Consume(3)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if _, err := q.Consume(3); err == nil {
+		t.Fatal("second Consume succeeded, want an error")
+	}
+	ch.mu.Lock()
+	if ch.autoAck || ch.prefetch != 3 || len(ch.consumers) != 1 {
+		t.Fatalf("consumer started with autoAck %v, prefetch %d, %d consumers; want manual acks, prefetch 3, one consumer", ch.autoAck, ch.prefetch, len(ch.consumers))
+	}
+	tag := ch.consumers[0]
+	ch.mu.Unlock()
+
+	ch.deliveries <- testDelivery(t, ack, 7, &model.QueueMessage{ID: "m1", Type: "test_message"})
+	msg := <-messages
+	if msg.ID != "m1" || msg.Receipt != "1.7" {
+		t.Fatalf("consumed %+v, want m1 with receipt 1.7", msg)
+	}
+	if got := ack.get(7); got != "" {
+		t.Fatalf("delivery settled with %q before the worker acknowledged it", got)
+	}
+	if err := q.MarkCompleted(context.Background(), msg); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if got := ack.get(7); got != "ack" {
+		t.Fatalf("delivery settled with %q, want ack", got)
+	}
+
+	if err := q.StopConsuming(); err != nil {
+		t.Fatalf("StopConsuming: %v", err)
+	}
+	ch.mu.Lock()
+	cancelled := ch.cancelled
+	ch.mu.Unlock()
+	if len(cancelled) != 1 || cancelled[0] != tag {
+		t.Fatalf("cancelled consumers %v, want %s", cancelled, tag)
+	}
+
+	// The broker closes the deliveries once the consumer is cancelled
+	close(ch.deliveries)
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Fatal("message delivered after StopConsuming")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("messages channel not closed after StopConsuming")
+	}
+}
+
+func TestRabbitMQRequeuePublishesAgain(t *testing.T) {
+	ctx := context.Background()
+	ch := newFakeChannel()
+	q := newPublishingQueue(t, ch)
+	ack := newAcknowledger()
+
+	msg := q.receive(ctx, testDelivery(t, ack, 1, &model.QueueMessage{ID: "m1", Attempts: 2, MaxAttempts: 5}), 1)
+	if err := q.Requeue(ctx, msg); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	if got := ack.get(1); got != "ack" {
+		t.Fatalf("requeued delivery settled with %q, want ack", got)
+	}
+	published := ch.publishedMessages(t)
+	if len(published) != 1 || published[0].ID != "m1" || published[0].Attempts != 2 {
+		t.Fatalf("published %+v, want m1 with its 2 attempts unchanged", published)
+	}
+}
+
+func TestRabbitMQRedeliveryUsesAnAttempt(t *testing.T) {
+	ctx := context.Background()
+	ch := newFakeChannel()
+	q := newPublishingQueue(t, ch)
+	ack := newAcknowledger()
+
+	// The delivery was lost with a worker: the attempt is recorded by
+	// publishing the message again instead of processing this copy
+	redelivered := testDelivery(t, ack, 1, &model.QueueMessage{ID: "m1", Attempts: 1, MaxAttempts: 3})
+	redelivered.Redelivered = true
+	if msg := q.receive(ctx, redelivered, 1); msg != nil {
+		t.Fatalf("redelivery handed to the worker: %+v", msg)
+	}
+	if got := ack.get(1); got != "ack" {
+		t.Fatalf("redelivery settled with %q, want ack", got)
+	}
+	published := ch.publishedMessages(t)
+	if len(published) != 1 || published[0].Attempts != 2 {
+		t.Fatalf("published %+v, want m1 with 2 attempts", published)
+	}
+
+	// Once no attempts are left the worker is told to dead-letter it
+	last := testDelivery(t, ack, 2, &published[0])
+	last.Redelivered = true
+	msg := q.receive(ctx, last, 1)
+	if msg == nil || msg.Attempts != 3 || msg.DeadLetterReason == "" {
+		t.Fatalf("received %+v, want attempt 3 marked for dead-lettering", msg)
+	}
+	if got := ack.get(2); got != "" {
+		t.Fatalf("last redelivery settled with %q before the worker saw it", got)
+	}
+	if n := len(ch.publishedMessages(t)); n != 1 {
+		t.Fatalf("%d messages published, want no more", n)
+	}
+}
+
+func TestRabbitMQReconnects(t *testing.T) {
+	broker := &fakeBroker{}
+	q, err := newRabbitMQQueue("amqp://test", time.Second, broker.dial)
+	if err != nil {
+		t.Fatalf("newRabbitMQQueue: %v", err)
+	}
+	defer q.Close()
+
+	first := broker.connection(0)
+	if err := q.Healthy(); err != nil {
+		t.Fatalf("Healthy: %v", err)
+	}
+	messages, err := q.Consume(2)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	first.drop("broker restarted")
+	eventually(t, "the queue to report the lost connection", func() bool {
+		return errors.Is(q.Healthy(), ErrUnavailable)
+	})
+	if err := q.Enqueue(context.Background(), &model.QueueMessage{ID: "m1"}); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Enqueue while disconnected = %v, want ErrUnavailable", err)
+	}
+
+	eventually(t, "the queue to reconnect", func() bool { return q.Healthy() == nil })
+	second := broker.connection(1)
+	if second == nil {
+		t.Fatal("reconnected without dialling again")
+	}
+	first.mu.Lock()
+	closed := first.closed
+	first.mu.Unlock()
+	if !closed {
+		t.Fatal("lost connection was not closed")
+	}
+
+	// The new channel gets the same topology and the consumer back
+	first.channel.mu.Lock()
+	wantDeclared := first.channel.declared
+	first.channel.mu.Unlock()
+	ch := second.channel
+	ch.mu.Lock()
+	declared, prefetch, consumers := ch.declared, ch.prefetch, len(ch.consumers)
+	ch.mu.Unlock()
+	if len(declared) == 0 || len(declared) != len(wantDeclared) {
+		t.Fatalf("declared %v after reconnecting, want %v", declared, wantDeclared)
+	}
+	for i := range declared {
+		if declared[i] != wantDeclared[i] {
+			t.Fatalf("declared %v after reconnecting, want %v", declared, wantDeclared)
+		}
+	}
+	if prefetch != 2 || consumers != 1 {
+		t.Fatalf("consumer resumed with prefetch %d and %d consumers, want 2 and 1", prefetch, consumers)
+	}
+
+	ack := newAcknowledger()
+	ch.deliveries <- testDelivery(t, ack, 1, &model.QueueMessage{ID: "m2"})
+	select {
+	case msg := <-messages:
+		if msg.ID != "m2" || msg.Receipt != "2.1" {
+			t.Fatalf("consumed %+v, want m2 with receipt 2.1", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no delivery from the new channel")
+	}
+
+	if err := q.Enqueue(context.Background(), &model.QueueMessage{ID: "m3"}); err != nil {
+		t.Fatalf("Enqueue after reconnecting: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package reconciliation
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/service"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// Job periodically reconciles the recent work sessions with the labor cost
+// reports the legacy system acknowledged, re-enqueueing the reports that were
+// lost on the way (see service.ReconciliationService.Reconcile).
+type Job struct {
+	service  *service.ReconciliationService
+	interval time.Duration
+	lookback int // days before today to include
+	stopChan chan bool
+	doneChan chan bool
+}
+
+func NewJob(svc *service.ReconciliationService, cfg *config.Config) *Job {
+	return &Job{
+		service:  svc,
+		interval: time.Duration(cfg.ReconciliationIntervalMinutes) * time.Minute,
+		lookback: max(cfg.ReconciliationLookbackDays, 0),
+		stopChan: make(chan bool),
+		doneChan: make(chan bool),
+	}
+}
+
+func (j *Job) Start() {
+	if j.interval <= 0 {
+		log.Println("Reconciliation job disabled")
+		close(j.doneChan)
+		return
+	}
+	log.Printf("Reconciliation job started - checking the last %d days every %s...", j.lookback, j.interval)
+
+	go func() {
+		defer close(j.doneChan)
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		// Catch up on whatever was lost while the service was down
+		j.run()
+
+		for {
+			select {
+			case <-j.stopChan:
+				log.Println("Reconciliation job stopped")
+				return
+			case <-ticker.C:
+				j.run()
+			}
+		}
+	}()
+}
+
+// Stop signals the job to stop and waits for a pass in progress to finish.
+func (j *Job) Stop() {
+	close(j.stopChan)
+	<-j.doneChan
+}
+
+func (j *Job) run() {
+	// Reconcile bounds its own queries
+	today := time.Now()
+	report, err := j.service.Reconcile(context.Background(), today.AddDate(0, 0, -j.lookback), today, true)
+	if err != nil {
+		log.Printf("Reconciliation failed: %v", err)
+		return
+	}
+	if len(report.Discrepancies) > 0 {
+		log.Printf("Reconciliation %s to %s: %d of %d sessions unreported, %d re-enqueued",
+			report.From, report.To, len(report.Discrepancies), report.SessionsChecked, report.Requeued)
+	}
+}
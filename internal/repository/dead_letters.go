@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+type deadLetterRow struct {
+	ID          int64     `db:"id"`
+	MessageType string    `db:"message_type"`
+	Body        []byte    `db:"body"`
+	Reason      string    `db:"reason"`
+	FailedAt    time.Time `db:"failed_at"`
+}
+
+func (row deadLetterRow) toModel() (model.DeadLetter, error) {
+	out := model.DeadLetter{
+		ID:       row.ID,
+		Reason:   row.Reason,
+		FailedAt: row.FailedAt,
+	}
+	if err := json.Unmarshal(row.Body, &out.Message); err != nil {
+		return out, fmt.Errorf("failed to decode dead letter %d: %w", row.ID, err)
+	}
+	return out, nil
+}
+
+func deadLettersToModel(rows []deadLetterRow) ([]model.DeadLetter, error) {
+	deadLetters := make([]model.DeadLetter, 0, len(rows))
+	for _, row := range rows {
+		dl, err := row.toModel()
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, dl)
+	}
+	return deadLetters, nil
+}
+
+func (s postgresStore) CreateDeadLetter(ctx context.Context, deadLetter *model.DeadLetter) error {
+	body, err := json.Marshal(deadLetter.Message)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+
+	query := `
+		INSERT INTO dead_letters (message_id, message_type, body, reason, attempts)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, failed_at`
+
+	return s.q.QueryRowxContext(ctx, query, deadLetter.Message.ID, deadLetter.Message.Type, body, deadLetter.Reason, deadLetter.Message.Attempts).
+		Scan(&deadLetter.ID, &deadLetter.FailedAt)
+}
+
+func (s postgresStore) ListDeadLetters(ctx context.Context, filter DeadLetterFilter) ([]model.DeadLetter, int, error) {
+	where := `
+		WHERE ($1::text = '' OR message_type = $1)
+		  AND id > $2
+		  AND ($3::bigint = 0 OR id <= $3)`
+	args := []interface{}{filter.MessageType, filter.AfterID, filter.MaxID}
+
+	var total int
+	if err := sqlx.GetContext(ctx, s.q, &total, `SELECT COUNT(*) FROM dead_letters`+where, args...); err != nil {
+		return nil, 0, err
+	}
+
+	order := "DESC"
+	if filter.OldestFirst {
+		order = "ASC"
+	}
+	var rows []deadLetterRow
+	query := `
+		SELECT id, message_type, body, reason, failed_at
+		FROM dead_letters` + where + `
+		ORDER BY id ` + order + `
+		LIMIT $4 OFFSET $5`
+
+	if err := sqlx.SelectContext(ctx, s.q, &rows, query, append(args, filter.Limit, filter.Offset)...); err != nil {
+		return nil, 0, err
+	}
+
+	deadLetters, err := deadLettersToModel(rows)
+	return deadLetters, total, err
+}
+
+func (s postgresStore) GetDeadLetter(ctx context.Context, id int64) (*model.DeadLetter, error) {
+	var row deadLetterRow
+	query := `
+		SELECT id, message_type, body, reason, failed_at
+		FROM dead_letters
+		WHERE id = $1`
+
+	err := sqlx.GetContext(ctx, s.q, &row, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dl, err := row.toModel()
+	if err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+func (s postgresStore) DeleteDeadLetter(ctx context.Context, id int64) error {
+	result, err := s.q.ExecContext(ctx, `DELETE FROM dead_letters WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("dead letter %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (s postgresStore) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	result, err := s.q.ExecContext(ctx, `DELETE FROM dead_letters`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *memoryState) CreateDeadLetter(ctx context.Context, deadLetter *model.DeadLetter) error {
+	body, err := json.Marshal(deadLetter.Message)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+
+	deadLetter.ID = s.nextDeadID
+	deadLetter.FailedAt = time.Now()
+	s.nextDeadID++
+
+	s.deadLetters = append(s.deadLetters, deadLetterRow{
+		ID:          deadLetter.ID,
+		MessageType: deadLetter.Message.Type,
+		Body:        body,
+		Reason:      deadLetter.Reason,
+		FailedAt:    deadLetter.FailedAt,
+	})
+	return nil
+}
+
+func (s *memoryState) ListDeadLetters(ctx context.Context, filter DeadLetterFilter) ([]model.DeadLetter, int, error) {
+	var matching []deadLetterRow
+	for _, row := range s.deadLetters {
+		if filter.MessageType != "" && row.MessageType != filter.MessageType {
+			continue
+		}
+		if row.ID <= filter.AfterID || (filter.MaxID != 0 && row.ID > filter.MaxID) {
+			continue
+		}
+		matching = append(matching, row)
+	}
+	if !filter.OldestFirst {
+		slices.Reverse(matching)
+	}
+
+	total := len(matching)
+	start := min(filter.Offset, total)
+	end := min(start+filter.Limit, total)
+
+	deadLetters, err := deadLettersToModel(matching[start:end])
+	return deadLetters, total, err
+}
+
+func (s *memoryState) GetDeadLetter(ctx context.Context, id int64) (*model.DeadLetter, error) {
+	for _, row := range s.deadLetters {
+		if row.ID == id {
+			dl, err := row.toModel()
+			if err != nil {
+				return nil, err
+			}
+			return &dl, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryState) DeleteDeadLetter(ctx context.Context, id int64) error {
+	for i, row := range s.deadLetters {
+		if row.ID == id {
+			s.deadLetters = append(s.deadLetters[:i:i], s.deadLetters[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("dead letter %d: %w", id, ErrNotFound)
+}
+
+func (s *memoryState) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	purged := int64(len(s.deadLetters))
+	s.deadLetters = nil
+	return purged, nil
+}
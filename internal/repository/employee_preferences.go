@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+func (s postgresStore) GetEmployeePreferences(ctx context.Context, employeeID string) (*model.EmployeePreferences, error) {
+	var prefs model.EmployeePreferences
+	query := `SELECT employee_id, locale, updated_at FROM employee_preferences WHERE employee_id = $1`
+
+	err := sqlx.GetContext(ctx, s.q, &prefs, query, employeeID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+func (s postgresStore) SetEmployeePreferences(ctx context.Context, prefs *model.EmployeePreferences) error {
+	query := `
+		INSERT INTO employee_preferences (employee_id, locale)
+		VALUES ($1, $2)
+		ON CONFLICT (employee_id) DO UPDATE SET locale = EXCLUDED.locale, updated_at = NOW()
+		RETURNING updated_at`
+
+	return s.q.QueryRowxContext(ctx, query, prefs.EmployeeID, prefs.Locale).Scan(&prefs.UpdatedAt)
+}
+
+func (s postgresStore) SumHoursWorked(ctx context.Context, employeeID string, from, to time.Time) (float64, error) {
+	var total float64
+	query := `
+		SELECT COALESCE(SUM(hours_worked), 0) FROM work_sessions
+		WHERE employee_id = $1 AND status = 'completed' AND checkout_time >= $2 AND checkout_time < $3`
+
+	err := sqlx.GetContext(ctx, s.q, &total, query, employeeID, from, to)
+	return total, err
+}
+
+func (s *memoryState) GetEmployeePreferences(ctx context.Context, employeeID string) (*model.EmployeePreferences, error) {
+	prefs, ok := s.preferences[employeeID]
+	if !ok {
+		return nil, nil
+	}
+	return &prefs, nil
+}
+
+func (s *memoryState) SetEmployeePreferences(ctx context.Context, prefs *model.EmployeePreferences) error {
+	prefs.UpdatedAt = time.Now()
+	s.preferences[prefs.EmployeeID] = *prefs
+	return nil
+}
+
+func (s *memoryState) SumHoursWorked(ctx context.Context, employeeID string, from, to time.Time) (float64, error) {
+	total := 0.0
+	for i := range s.sessions {
+		session := &s.sessions[i]
+		if session.EmployeeID != employeeID || session.Status != "completed" || session.CheckoutTime == nil || session.HoursWorked == nil {
+			continue
+		}
+		if session.CheckoutTime.Before(from) || !session.CheckoutTime.Before(to) {
+			continue
+		}
+		total += *session.HoursWorked
+	}
+	return total, nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+func (s postgresStore) ListCompletedSessions(ctx context.Context, from, to time.Time) ([]model.WorkSession, error) {
+	sessions := []model.WorkSession{}
+	query := `
+		SELECT id, employee_id, checkin_time, checkout_time, hours_worked, status, created_at, updated_at
+		FROM work_sessions
+		WHERE status = 'completed' AND checkout_time >= $1 AND checkout_time < $2
+		ORDER BY checkout_time, id`
+
+	if err := sqlx.SelectContext(ctx, s.q, &sessions, query, from, to); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s postgresStore) GetExportWatermark(ctx context.Context, name string) (*time.Time, error) {
+	var until time.Time
+	err := sqlx.GetContext(ctx, s.q, &until, `SELECT exported_until FROM export_watermarks WHERE name = $1`, name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &until, nil
+}
+
+func (s postgresStore) SetExportWatermark(ctx context.Context, name string, until time.Time) error {
+	query := `
+		INSERT INTO export_watermarks (name, exported_until)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET exported_until = EXCLUDED.exported_until, updated_at = NOW()`
+
+	_, err := s.q.ExecContext(ctx, query, name, until)
+	return err
+}
+
+func (s *memoryState) ListCompletedSessions(ctx context.Context, from, to time.Time) ([]model.WorkSession, error) {
+	sessions := []model.WorkSession{}
+	for i := range s.sessions {
+		session := &s.sessions[i]
+		if session.Status != "completed" || session.CheckoutTime == nil {
+			continue
+		}
+		if session.CheckoutTime.Before(from) || !session.CheckoutTime.Before(to) {
+			continue
+		}
+		sessions = append(sessions, *copySession(session))
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := sessions[i], sessions[j]
+		if !a.CheckoutTime.Equal(*b.CheckoutTime) {
+			return a.CheckoutTime.Before(*b.CheckoutTime)
+		}
+		return a.ID < b.ID
+	})
+	return sessions, nil
+}
+
+func (s *memoryState) GetExportWatermark(ctx context.Context, name string) (*time.Time, error) {
+	until, ok := s.exportWatermarks[name]
+	if !ok {
+		return nil, nil
+	}
+	return &until, nil
+}
+
+func (s *memoryState) SetExportWatermark(ctx context.Context, name string, until time.Time) error {
+	s.exportWatermarks[name] = until
+	return nil
+}
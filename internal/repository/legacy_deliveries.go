@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+func (s postgresStore) GetLegacyDelivery(ctx context.Context, idempotencyKey string) (*model.LegacyDelivery, error) {
+	var delivery model.LegacyDelivery
+	query := `
+		SELECT idempotency_key, message_id, employee_id, session_id, hours_worked, work_date,
+		       status, attempts, last_error, first_attempt_at, acknowledged_at, updated_at
+		FROM legacy_deliveries
+		WHERE idempotency_key = $1`
+
+	err := sqlx.GetContext(ctx, s.q, &delivery, query, idempotencyKey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (s postgresStore) RecordLegacyDelivery(ctx context.Context, delivery *model.LegacyDelivery) error {
+	query := `
+		INSERT INTO legacy_deliveries (idempotency_key, message_id, employee_id, session_id, hours_worked, work_date,
+		                               status, attempts, last_error, acknowledged_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 1, $8, CASE WHEN $7::text = 'acknowledged' THEN NOW() END)
+		ON CONFLICT (idempotency_key) DO UPDATE SET
+			message_id = EXCLUDED.message_id,
+			status = CASE WHEN legacy_deliveries.status = 'acknowledged' THEN legacy_deliveries.status ELSE EXCLUDED.status END,
+			last_error = CASE WHEN legacy_deliveries.status = 'acknowledged' THEN legacy_deliveries.last_error ELSE EXCLUDED.last_error END,
+			acknowledged_at = COALESCE(legacy_deliveries.acknowledged_at, EXCLUDED.acknowledged_at),
+			first_attempt_at = CASE WHEN legacy_deliveries.attempts = 0 THEN NOW() ELSE legacy_deliveries.first_attempt_at END,
+			attempts = legacy_deliveries.attempts + 1,
+			updated_at = NOW()
+		RETURNING status, attempts, last_error, first_attempt_at, acknowledged_at, updated_at`
+
+	return s.q.QueryRowxContext(ctx, query,
+		delivery.IdempotencyKey, delivery.MessageID, delivery.EmployeeID, delivery.SessionID,
+		delivery.HoursWorked, delivery.WorkDate, delivery.Status, delivery.LastError,
+	).Scan(&delivery.Status, &delivery.Attempts, &delivery.LastError, &delivery.FirstAttemptAt, &delivery.AcknowledgedAt, &delivery.UpdatedAt)
+}
+
+func (s postgresStore) MarkLegacyDeliveryQueued(ctx context.Context, delivery *model.LegacyDelivery) (bool, error) {
+	query := `
+		INSERT INTO legacy_deliveries (idempotency_key, message_id, employee_id, session_id, hours_worked, work_date,
+		                               status, attempts)
+		VALUES ($1, $2, $3, $4, $5, $6, 'queued', 0)
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING status, attempts, first_attempt_at, updated_at`
+
+	err := s.q.QueryRowxContext(ctx, query,
+		delivery.IdempotencyKey, delivery.MessageID, delivery.EmployeeID, delivery.SessionID,
+		delivery.HoursWorked, delivery.WorkDate,
+	).Scan(&delivery.Status, &delivery.Attempts, &delivery.FirstAttemptAt, &delivery.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *memoryState) GetLegacyDelivery(ctx context.Context, idempotencyKey string) (*model.LegacyDelivery, error) {
+	delivery, ok := s.legacyDeliveries[idempotencyKey]
+	if !ok {
+		return nil, nil
+	}
+	return copyLegacyDelivery(&delivery), nil
+}
+
+func (s *memoryState) RecordLegacyDelivery(ctx context.Context, delivery *model.LegacyDelivery) error {
+	now := time.Now()
+
+	stored, ok := s.legacyDeliveries[delivery.IdempotencyKey]
+	if !ok || stored.Attempts == 0 {
+		stored.FirstAttemptAt = now
+	}
+	stored.IdempotencyKey = delivery.IdempotencyKey
+	stored.MessageID = delivery.MessageID
+	stored.EmployeeID = delivery.EmployeeID
+	stored.SessionID = delivery.SessionID
+	stored.HoursWorked = delivery.HoursWorked
+	stored.WorkDate = delivery.WorkDate
+	stored.Attempts++
+	stored.UpdatedAt = now
+
+	// An acknowledged delivery stays acknowledged
+	if stored.Status != "acknowledged" {
+		stored.Status = delivery.Status
+		stored.LastError = delivery.LastError
+		if stored.Status == "acknowledged" {
+			stored.AcknowledgedAt = &now
+		}
+	}
+
+	s.legacyDeliveries[delivery.IdempotencyKey] = *copyLegacyDelivery(&stored)
+	*delivery = *copyLegacyDelivery(&stored)
+	return nil
+}
+
+func (s *memoryState) MarkLegacyDeliveryQueued(ctx context.Context, delivery *model.LegacyDelivery) (bool, error) {
+	if _, ok := s.legacyDeliveries[delivery.IdempotencyKey]; ok {
+		return false, nil
+	}
+
+	now := time.Now()
+	stored := *copyLegacyDelivery(delivery)
+	stored.Status = "queued"
+	stored.Attempts = 0
+	stored.LastError = nil
+	stored.AcknowledgedAt = nil
+	stored.FirstAttemptAt = now
+	stored.UpdatedAt = now
+
+	s.legacyDeliveries[delivery.IdempotencyKey] = stored
+	*delivery = *copyLegacyDelivery(&stored)
+	return true, nil
+}
+
+func copyLegacyDelivery(delivery *model.LegacyDelivery) *model.LegacyDelivery {
+	c := *delivery
+	if delivery.SessionID != nil {
+		id := *delivery.SessionID
+		c.SessionID = &id
+	}
+	if delivery.LastError != nil {
+		reason := *delivery.LastError
+		c.LastError = &reason
+	}
+	if delivery.AcknowledgedAt != nil {
+		at := *delivery.AcknowledgedAt
+		c.AcknowledgedAt = &at
+	}
+	return &c
+}
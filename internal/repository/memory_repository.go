@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// MemoryRepository is a thread-safe, in-process Repository used by tests and
+// local runs that should not depend on PostgreSQL. Records are copied on the
+// way in and out so callers can never mutate stored state by accident.
+//
+// Transactions are serialised: WithinTransaction holds the repository lock
+// for the whole callback and works on a copy of the data that replaces the
+// original only on commit. The callback must use the Tx it is given rather
+// than the repository itself.
+//
+// Contexts are only checked before a transaction commits; individual
+// operations are instantaneous.
+type MemoryRepository struct {
+	mu    sync.RWMutex
+	state *memoryState
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		state: &memoryState{
+			nextEventID:   1,
+			nextSessionID: 1,
+			nextOutboxID:  1,
+			nextDeadID:    1,
+
+			legacyDeliveries: make(map[string]model.LegacyDelivery),
+			exportWatermarks: make(map[string]time.Time),
+			preferences:      make(map[string]model.EmployeePreferences),
+		},
+	}
+}
+
+func (r *MemoryRepository) CreateCheckinEvent(ctx context.Context, event *model.CheckinEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.CreateCheckinEvent(ctx, event)
+}
+
+func (r *MemoryRepository) GetActiveSession(ctx context.Context, employeeID string) (*model.WorkSession, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.GetActiveSession(ctx, employeeID)
+}
+
+func (r *MemoryRepository) CreateWorkSession(ctx context.Context, session *model.WorkSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.CreateWorkSession(ctx, session)
+}
+
+func (r *MemoryRepository) UpdateWorkSession(ctx context.Context, session *model.WorkSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.UpdateWorkSession(ctx, session)
+}
+
+func (r *MemoryRepository) CountPendingOutbox(ctx context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.CountPendingOutbox(ctx)
+}
+
+func (r *MemoryRepository) PurgeSentOutbox(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.PurgeSentOutbox(ctx, before)
+}
+
+func (r *MemoryRepository) CreateDeadLetter(ctx context.Context, deadLetter *model.DeadLetter) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.CreateDeadLetter(ctx, deadLetter)
+}
+
+func (r *MemoryRepository) ListDeadLetters(ctx context.Context, filter DeadLetterFilter) ([]model.DeadLetter, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.ListDeadLetters(ctx, filter)
+}
+
+func (r *MemoryRepository) GetDeadLetter(ctx context.Context, id int64) (*model.DeadLetter, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.GetDeadLetter(ctx, id)
+}
+
+func (r *MemoryRepository) DeleteDeadLetter(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.DeleteDeadLetter(ctx, id)
+}
+
+func (r *MemoryRepository) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.PurgeDeadLetters(ctx)
+}
+
+func (r *MemoryRepository) GetLegacyDelivery(ctx context.Context, idempotencyKey string) (*model.LegacyDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.GetLegacyDelivery(ctx, idempotencyKey)
+}
+
+func (r *MemoryRepository) RecordLegacyDelivery(ctx context.Context, delivery *model.LegacyDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.RecordLegacyDelivery(ctx, delivery)
+}
+
+func (r *MemoryRepository) MarkLegacyDeliveryQueued(ctx context.Context, delivery *model.LegacyDelivery) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.MarkLegacyDeliveryQueued(ctx, delivery)
+}
+
+func (r *MemoryRepository) ListUnreportedSessions(ctx context.Context, from, to time.Time) ([]model.ReportDiscrepancy, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.ListUnreportedSessions(ctx, from, to)
+}
+
+func (r *MemoryRepository) ListCompletedSessions(ctx context.Context, from, to time.Time) ([]model.WorkSession, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.ListCompletedSessions(ctx, from, to)
+}
+
+func (r *MemoryRepository) GetExportWatermark(ctx context.Context, name string) (*time.Time, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.GetExportWatermark(ctx, name)
+}
+
+func (r *MemoryRepository) SetExportWatermark(ctx context.Context, name string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.SetExportWatermark(ctx, name, until)
+}
+
+func (r *MemoryRepository) GetEmployeePreferences(ctx context.Context, employeeID string) (*model.EmployeePreferences, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.GetEmployeePreferences(ctx, employeeID)
+}
+
+func (r *MemoryRepository) SetEmployeePreferences(ctx context.Context, prefs *model.EmployeePreferences) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.SetEmployeePreferences(ctx, prefs)
+}
+
+func (r *MemoryRepository) SumHoursWorked(ctx context.Context, employeeID string, from, to time.Time) (float64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.SumHoursWorked(ctx, employeeID, from, to)
+}
+
+func (r *MemoryRepository) WithinTransaction(ctx context.Context, fn func(tx Tx) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	working := r.state.clone()
+	if err := fn(&memoryTx{memoryState: working}); err != nil {
+		return err
+	}
+
+	// Like a database commit, fail if the caller gave up meanwhile
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	r.state = working
+	return nil
+}
+
+func (r *MemoryRepository) Close() error {
+	return nil
+}
+
+// memoryState holds the repository data. It does no locking of its own.
+type memoryState struct {
+	events        []model.CheckinEvent
+	sessions      []model.WorkSession
+	outbox        []outboxRow
+	deadLetters   []deadLetterRow
+	nextEventID   int
+	nextSessionID int
+	nextOutboxID  int64
+	nextDeadID    int64
+
+	legacyDeliveries map[string]model.LegacyDelivery      // by idempotency key
+	exportWatermarks map[string]time.Time                 // by exporter name
+	preferences      map[string]model.EmployeePreferences // by employee ID
+}
+
+func (s *memoryState) clone() *memoryState {
+	c := *s
+	c.events = append([]model.CheckinEvent(nil), s.events...)
+	c.outbox = append([]outboxRow(nil), s.outbox...)
+	c.deadLetters = append([]deadLetterRow(nil), s.deadLetters...)
+	c.legacyDeliveries = make(map[string]model.LegacyDelivery, len(s.legacyDeliveries))
+	for key, delivery := range s.legacyDeliveries {
+		c.legacyDeliveries[key] = delivery
+	}
+	c.exportWatermarks = make(map[string]time.Time, len(s.exportWatermarks))
+	for name, until := range s.exportWatermarks {
+		c.exportWatermarks[name] = until
+	}
+	c.preferences = make(map[string]model.EmployeePreferences, len(s.preferences))
+	for employeeID, prefs := range s.preferences {
+		c.preferences[employeeID] = prefs
+	}
+	c.sessions = make([]model.WorkSession, len(s.sessions))
+	for i := range s.sessions {
+		c.sessions[i] = *copySession(&s.sessions[i])
+	}
+	return &c
+}
+
+func (s *memoryState) CreateCheckinEvent(ctx context.Context, event *model.CheckinEvent) error {
+	event.ID = s.nextEventID
+	event.CreatedAt = time.Now()
+	s.nextEventID++
+
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func (s *memoryState) GetActiveSession(ctx context.Context, employeeID string) (*model.WorkSession, error) {
+	active := s.activeSession(employeeID)
+	if active == nil {
+		return nil, nil
+	}
+	return copySession(active), nil
+}
+
+func (s *memoryState) activeSession(employeeID string) *model.WorkSession {
+	var active *model.WorkSession
+	for i := range s.sessions {
+		session := &s.sessions[i]
+		if session.EmployeeID != employeeID || session.Status != "active" {
+			continue
+		}
+		if active == nil || !session.CreatedAt.Before(active.CreatedAt) {
+			active = session
+		}
+	}
+	return active
+}
+
+func (s *memoryState) CreateWorkSession(ctx context.Context, session *model.WorkSession) error {
+	if session.Status == "active" && s.activeSession(session.EmployeeID) != nil {
+		return fmt.Errorf("employee %s already has an active session: %w", session.EmployeeID, ErrConflict)
+	}
+
+	now := time.Now()
+	session.ID = s.nextSessionID
+	session.CreatedAt = now
+	session.UpdatedAt = now
+	s.nextSessionID++
+
+	s.sessions = append(s.sessions, *copySession(session))
+	return nil
+}
+
+func (s *memoryState) UpdateWorkSession(ctx context.Context, session *model.WorkSession) error {
+	for i := range s.sessions {
+		if s.sessions[i].ID != session.ID {
+			continue
+		}
+		stored := &s.sessions[i]
+		stored.CheckoutTime = copyTime(session.CheckoutTime)
+		stored.HoursWorked = copyFloat(session.HoursWorked)
+		stored.Status = session.Status
+		stored.UpdatedAt = time.Now()
+		session.UpdatedAt = stored.UpdatedAt
+		return nil
+	}
+
+	return fmt.Errorf("work session %d not found", session.ID)
+}
+
+type memoryTx struct {
+	*memoryState
+}
+
+// LockEmployee always succeeds: memory transactions are already serialised.
+func (t *memoryTx) LockEmployee(ctx context.Context, employeeID string) error {
+	return nil
+}
+
+// LockJob always succeeds: memory transactions are already serialised.
+func (t *memoryTx) LockJob(ctx context.Context, job string) error {
+	return nil
+}
+
+func copySession(s *model.WorkSession) *model.WorkSession {
+	c := *s
+	c.CheckoutTime = copyTime(s.CheckoutTime)
+	c.HoursWorked = copyFloat(s.HoursWorked)
+	return &c
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+func copyFloat(f *float64) *float64 {
+	if f == nil {
+		return nil
+	}
+	c := *f
+	return &c
+}
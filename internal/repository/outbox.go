@@ -0,0 +1,240 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+type outboxRow struct {
+	ID        int64      `db:"id"`
+	Body      []byte     `db:"body"`
+	Attempts  int        `db:"attempts"`
+	LastError *string    `db:"last_error"`
+	CreatedAt time.Time  `db:"created_at"`
+	SentAt    *time.Time `db:"sent_at"`
+
+	lockedUntil *time.Time // memory only; Postgres doesn't select it
+}
+
+// parkedUntil is the lease given to outbox messages that can't be decoded, so
+// they are never claimed again.
+var parkedUntil = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+func (row outboxRow) toModel() (model.OutboxMessage, error) {
+	out := model.OutboxMessage{
+		ID:        row.ID,
+		Attempts:  row.Attempts,
+		LastError: row.LastError,
+		CreatedAt: row.CreatedAt,
+		SentAt:    row.SentAt,
+	}
+	if err := json.Unmarshal(row.Body, &out.Message); err != nil {
+		return out, fmt.Errorf("failed to decode outbox message %d: %w", row.ID, err)
+	}
+	return out, nil
+}
+
+func (s postgresStore) CountPendingOutbox(ctx context.Context) (int, error) {
+	var count int
+	err := sqlx.GetContext(ctx, s.q, &count, `SELECT COUNT(*) FROM outbox_messages WHERE sent_at IS NULL`)
+	return count, err
+}
+
+func (s postgresStore) PurgeSentOutbox(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.q.ExecContext(ctx, `DELETE FROM outbox_messages WHERE sent_at IS NOT NULL AND sent_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (t *postgresTx) CreateOutboxMessage(ctx context.Context, msg *model.QueueMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox message: %w", err)
+	}
+
+	query := `
+		INSERT INTO outbox_messages (message_id, message_type, body)
+		VALUES ($1, $2, $3)`
+
+	_, err = t.q.ExecContext(ctx, query, msg.ID, msg.Type, body)
+	return err
+}
+
+func (t *postgresTx) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxMessage, error) {
+	var rows []outboxRow
+	query := `
+		UPDATE outbox_messages
+		SET locked_until = NOW() + $2::float8 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM outbox_messages
+			WHERE sent_at IS NULL
+			  AND (locked_until IS NULL OR locked_until <= NOW())
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, body, attempts, last_error, created_at, sent_at`
+
+	if err := sqlx.SelectContext(ctx, t.q, &rows, query, limit, lease.Seconds()); err != nil {
+		return nil, err
+	}
+	// RETURNING has no order of its own
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+	messages := make([]model.OutboxMessage, 0, len(rows))
+	for _, row := range rows {
+		msg, err := row.toModel()
+		if err != nil {
+			log.Printf("Parking outbox message %d: %v", row.ID, err)
+			_, err = t.q.ExecContext(ctx, `UPDATE outbox_messages SET last_error = $2, locked_until = $3 WHERE id = $1`, row.ID, err.Error(), parkedUntil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to park outbox message %d: %w", row.ID, err)
+			}
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (t *postgresTx) MarkOutboxSent(ctx context.Context, id int64) error {
+	_, err := t.q.ExecContext(ctx, `UPDATE outbox_messages SET sent_at = NOW(), attempts = attempts + 1, locked_until = NULL WHERE id = $1`, id)
+	return err
+}
+
+func (t *postgresTx) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAfter time.Duration) error {
+	query := `
+		UPDATE outbox_messages
+		SET attempts = attempts + 1, last_error = $2, locked_until = NOW() + $3::float8 * INTERVAL '1 second'
+		WHERE id = $1`
+	_, err := t.q.ExecContext(ctx, query, id, reason, retryAfter.Seconds())
+	return err
+}
+
+func (t *postgresTx) ReleaseOutboxMessages(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := t.q.ExecContext(ctx, `UPDATE outbox_messages SET locked_until = NULL WHERE id = ANY($1)`, pq.Array(ids))
+	return err
+}
+
+func (s *memoryState) CountPendingOutbox(ctx context.Context) (int, error) {
+	count := 0
+	for _, row := range s.outbox {
+		if row.SentAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryState) PurgeSentOutbox(ctx context.Context, before time.Time) (int64, error) {
+	kept := s.outbox[:0]
+	var purged int64
+	for _, row := range s.outbox {
+		if row.SentAt != nil && row.SentAt.Before(before) {
+			purged++
+			continue
+		}
+		kept = append(kept, row)
+	}
+	s.outbox = kept
+	return purged, nil
+}
+
+func (t *memoryTx) CreateOutboxMessage(ctx context.Context, msg *model.QueueMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox message: %w", err)
+	}
+
+	// Messages stay encoded, as in Postgres, so stored rows never share
+	// payload maps with callers.
+	t.outbox = append(t.outbox, outboxRow{
+		ID:        t.nextOutboxID,
+		Body:      body,
+		CreatedAt: time.Now(),
+	})
+	t.nextOutboxID++
+	return nil
+}
+
+func (t *memoryTx) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxMessage, error) {
+	now := time.Now()
+	lockedUntil := now.Add(lease)
+
+	var messages []model.OutboxMessage
+	for i := range t.outbox {
+		row := &t.outbox[i]
+		if len(messages) >= limit {
+			break
+		}
+		if row.SentAt != nil || (row.lockedUntil != nil && row.lockedUntil.After(now)) {
+			continue
+		}
+		msg, err := row.toModel()
+		if err != nil {
+			log.Printf("Parking outbox message %d: %v", row.ID, err)
+			reason := err.Error()
+			row.LastError = &reason
+			row.lockedUntil = &parkedUntil
+			continue
+		}
+		row.lockedUntil = &lockedUntil
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (t *memoryTx) MarkOutboxSent(ctx context.Context, id int64) error {
+	row := t.findOutbox(id)
+	if row == nil {
+		return fmt.Errorf("outbox message %d not found", id)
+	}
+	now := time.Now()
+	row.SentAt = &now
+	row.Attempts++
+	row.lockedUntil = nil
+	return nil
+}
+
+func (t *memoryTx) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAfter time.Duration) error {
+	row := t.findOutbox(id)
+	if row == nil {
+		return fmt.Errorf("outbox message %d not found", id)
+	}
+	retryAt := time.Now().Add(retryAfter)
+	row.Attempts++
+	row.LastError = &reason
+	row.lockedUntil = &retryAt
+	return nil
+}
+
+func (t *memoryTx) ReleaseOutboxMessages(ctx context.Context, ids []int64) error {
+	for _, id := range ids {
+		if row := t.findOutbox(id); row != nil {
+			row.lockedUntil = nil
+		}
+	}
+	return nil
+}
+
+func (s *memoryState) findOutbox(id int64) *outboxRow {
+	for i := range s.outbox {
+		if s.outbox[i].ID == id {
+			return &s.outbox[i]
+		}
+	}
+	return nil
+}
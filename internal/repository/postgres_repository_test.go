@@ -0,0 +1,123 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/migrate"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository/repotest"
+)
+
+// TestPostgresRepository runs the conformance suite against the database in
+// TEST_DATABASE_URL, e.g. the one from docker-compose.yml. The suite uses
+// unique employee IDs, so the database doesn't need to be empty.
+func TestPostgresRepository(t *testing.T) {
+	repo := openTestDatabase(t)
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		return repo
+	})
+}
+
+// TestPostgresJobLock checks that a job lock is held until the transaction
+// that took it ends, and refused rather than awaited meanwhile.
+func TestPostgresJobLock(t *testing.T) {
+	repo := openTestDatabase(t)
+	ctx := context.Background()
+	job := repotest.EmployeeID("job")
+
+	err := repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		if err := tx.LockJob(ctx, job); err != nil {
+			return err
+		}
+		return repo.WithinTransaction(ctx, func(other repository.Tx) error {
+			if err := other.LockJob(ctx, job); !errors.Is(err, repository.ErrConflict) {
+				t.Errorf("LockJob while held = %v, want ErrConflict", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithinTransaction: %v", err)
+	}
+
+	err = repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		return tx.LockJob(ctx, job)
+	})
+	if err != nil {
+		t.Fatalf("LockJob after the holder committed = %v", err)
+	}
+}
+
+// TestPostgresOutboxParksUndecodableRows checks that a row that can't be
+// decoded is set aside with its error instead of failing every claim.
+func TestPostgresOutboxParksUndecodableRows(t *testing.T) {
+	repo := openTestDatabase(t)
+	ctx := context.Background()
+
+	var id int64
+	err := repo.DB().GetContext(ctx, &id, `
+		INSERT INTO outbox_messages (message_id, message_type, body)
+		VALUES ($1, 'test', '{"id": 5}')
+		RETURNING id`, repotest.EmployeeID("bad"))
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	for pass := 0; pass < 2; pass++ {
+		err = repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+			claimed, err := tx.ClaimOutboxMessages(ctx, 1000, time.Minute)
+			if err != nil {
+				return err
+			}
+			ids := make([]int64, 0, len(claimed))
+			for _, msg := range claimed {
+				if msg.ID == id {
+					t.Errorf("undecodable outbox row %d was claimed", id)
+				}
+				ids = append(ids, msg.ID)
+			}
+			return tx.ReleaseOutboxMessages(ctx, ids)
+		})
+		if err != nil {
+			t.Fatalf("ClaimOutboxMessages pass %d: %v", pass+1, err)
+		}
+	}
+
+	var lastError *string
+	if err := repo.DB().GetContext(ctx, &lastError, `SELECT last_error FROM outbox_messages WHERE id = $1`, id); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if lastError == nil || !strings.Contains(*lastError, "decode") {
+		t.Fatalf("last_error = %v, want the decode error", lastError)
+	}
+}
+
+// openTestDatabase connects to the database in TEST_DATABASE_URL and
+// migrates it, skipping the test if it isn't set.
+func openTestDatabase(t *testing.T) *repository.PostgresRepository {
+	t.Helper()
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	repo, err := repository.NewPostgresRepository(databaseURL)
+	if err != nil {
+		t.Fatalf("NewPostgresRepository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	migrator, err := migrate.NewMigrator(repo.DB().DB)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	return repo
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+func (s postgresStore) ListUnreportedSessions(ctx context.Context, from, to time.Time) ([]model.ReportDiscrepancy, int, error) {
+	var total int
+	countQuery := `
+		SELECT COUNT(*) FROM work_sessions
+		WHERE status = 'completed' AND checkout_time >= $1 AND checkout_time < $2`
+	if err := sqlx.GetContext(ctx, s.q, &total, countQuery, from, to); err != nil {
+		return nil, 0, err
+	}
+
+	// A session can have deliveries under several keys; the latest one
+	// describes it
+	discrepancies := []model.ReportDiscrepancy{}
+	query := `
+		SELECT s.id AS session_id, s.employee_id, s.checkout_time, COALESCE(s.hours_worked, 0) AS hours_worked,
+		       d.status AS delivery_status, COALESCE(d.attempts, 0) AS attempts, d.last_error
+		FROM work_sessions s
+		LEFT JOIN LATERAL (
+			SELECT status, attempts, last_error FROM legacy_deliveries
+			WHERE session_id = s.id
+			ORDER BY updated_at DESC, idempotency_key DESC
+			LIMIT 1
+		) d ON TRUE
+		WHERE s.status = 'completed' AND s.checkout_time >= $1 AND s.checkout_time < $2
+		  AND NOT EXISTS (
+			SELECT 1 FROM legacy_deliveries a
+			WHERE a.session_id = s.id AND a.status = 'acknowledged'
+		  )
+		ORDER BY s.checkout_time, s.id`
+
+	if err := sqlx.SelectContext(ctx, s.q, &discrepancies, query, from, to); err != nil {
+		return nil, 0, err
+	}
+	return discrepancies, total, nil
+}
+
+func (s *memoryState) ListUnreportedSessions(ctx context.Context, from, to time.Time) ([]model.ReportDiscrepancy, int, error) {
+	// Deliveries are keyed by idempotency key, so index them by session first
+	deliveries := make(map[int][]model.LegacyDelivery)
+	for _, delivery := range s.legacyDeliveries {
+		if delivery.SessionID != nil {
+			deliveries[*delivery.SessionID] = append(deliveries[*delivery.SessionID], delivery)
+		}
+	}
+
+	total := 0
+	discrepancies := []model.ReportDiscrepancy{}
+	for _, session := range s.sessions {
+		if session.Status != "completed" || session.CheckoutTime == nil {
+			continue
+		}
+		if session.CheckoutTime.Before(from) || !session.CheckoutTime.Before(to) {
+			continue
+		}
+		total++
+
+		discrepancy := model.ReportDiscrepancy{
+			SessionID:    session.ID,
+			EmployeeID:   session.EmployeeID,
+			CheckoutTime: *session.CheckoutTime,
+		}
+		if session.HoursWorked != nil {
+			discrepancy.HoursWorked = *session.HoursWorked
+		}
+
+		// Like Postgres, describe the session by its latest delivery
+		var latest *model.LegacyDelivery
+		acknowledged := false
+		for i, delivery := range deliveries[session.ID] {
+			if delivery.Status == "acknowledged" {
+				acknowledged = true
+				break
+			}
+			if latest == nil || delivery.UpdatedAt.After(latest.UpdatedAt) ||
+				(delivery.UpdatedAt.Equal(latest.UpdatedAt) && delivery.IdempotencyKey > latest.IdempotencyKey) {
+				latest = &deliveries[session.ID][i]
+			}
+		}
+		if acknowledged {
+			continue
+		}
+		if latest != nil {
+			stored := copyLegacyDelivery(latest)
+			discrepancy.DeliveryStatus = &stored.Status
+			discrepancy.Attempts = stored.Attempts
+			discrepancy.LastError = stored.LastError
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	sort.Slice(discrepancies, func(i, j int) bool {
+		a, b := discrepancies[i], discrepancies[j]
+		if !a.CheckoutTime.Equal(b.CheckoutTime) {
+			return a.CheckoutTime.Before(b.CheckoutTime)
+		}
+		return a.SessionID < b.SessionID
+	})
+	return discrepancies, total, nil
+}
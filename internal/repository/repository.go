@@ -1,123 +1,292 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/omaaartamer/factory-checkin-api/internal/model"
 )
 
-type Repository struct {
+// ErrConflict is returned when a write collides with a concurrent request for
+// the same employee, e.g. two badge swipes processed at the same time.
+var ErrConflict = errors.New("conflicting concurrent update")
+
+// ErrNotFound is returned when a record addressed by ID does not exist.
+var ErrNotFound = errors.New("record not found")
+
+// Store is the set of queries available both directly on a Repository and
+// inside a transaction.
+type Store interface {
+	CreateCheckinEvent(ctx context.Context, event *model.CheckinEvent) error
+	GetActiveSession(ctx context.Context, employeeID string) (*model.WorkSession, error)
+	// CreateWorkSession returns ErrConflict if the employee already has an
+	// active session.
+	CreateWorkSession(ctx context.Context, session *model.WorkSession) error
+	UpdateWorkSession(ctx context.Context, session *model.WorkSession) error
+	CountPendingOutbox(ctx context.Context) (int, error)
+	// PurgeSentOutbox deletes outbox messages published before the cutoff.
+	PurgeSentOutbox(ctx context.Context, before time.Time) (int64, error)
+
+	CreateDeadLetter(ctx context.Context, deadLetter *model.DeadLetter) error
+	// ListDeadLetters returns a page of dead letters matching filter, newest
+	// first unless filter.OldestFirst is set, along with the total number
+	// matching.
+	ListDeadLetters(ctx context.Context, filter DeadLetterFilter) ([]model.DeadLetter, int, error)
+	// GetDeadLetter returns nil if there is no dead letter with that ID.
+	GetDeadLetter(ctx context.Context, id int64) (*model.DeadLetter, error)
+	// DeleteDeadLetter returns ErrNotFound if the dead letter does not exist.
+	DeleteDeadLetter(ctx context.Context, id int64) error
+	PurgeDeadLetters(ctx context.Context) (int64, error)
+
+	// GetLegacyDelivery returns nil if no delivery was recorded under the key.
+	GetLegacyDelivery(ctx context.Context, idempotencyKey string) (*model.LegacyDelivery, error)
+	// RecordLegacyDelivery records the outcome of one attempt to deliver a
+	// labor cost report, counting the attempt and filling in the stored
+	// fields. An acknowledged delivery stays acknowledged.
+	RecordLegacyDelivery(ctx context.Context, delivery *model.LegacyDelivery) error
+	// MarkLegacyDeliveryQueued records a report that was enqueued again
+	// without being attempted, with status "queued". It returns false and
+	// records nothing if a delivery is already recorded under the key.
+	MarkLegacyDeliveryQueued(ctx context.Context, delivery *model.LegacyDelivery) (bool, error)
+	// ListUnreportedSessions returns the sessions completed in [from, to)
+	// without an acknowledged labor cost report, oldest first, along with the
+	// number of sessions completed in that range. Each session is listed once,
+	// with its most recently updated delivery.
+	ListUnreportedSessions(ctx context.Context, from, to time.Time) ([]model.ReportDiscrepancy, int, error)
+
+	// ListCompletedSessions returns the sessions completed in [from, to),
+	// oldest first.
+	ListCompletedSessions(ctx context.Context, from, to time.Time) ([]model.WorkSession, error)
+	// GetExportWatermark returns nil if the exporter has never run.
+	GetExportWatermark(ctx context.Context, name string) (*time.Time, error)
+	SetExportWatermark(ctx context.Context, name string, until time.Time) error
+
+	// GetEmployeePreferences returns nil if the employee has none.
+	GetEmployeePreferences(ctx context.Context, employeeID string) (*model.EmployeePreferences, error)
+	SetEmployeePreferences(ctx context.Context, prefs *model.EmployeePreferences) error
+	// SumHoursWorked totals the hours of the employee's sessions completed in
+	// [from, to).
+	SumHoursWorked(ctx context.Context, employeeID string, from, to time.Time) (float64, error)
+}
+
+// DeadLetterFilter selects a page of dead letters. An empty MessageType
+// matches every type. AfterID and MaxID, when set, restrict the page to IDs
+// in (AfterID, MaxID], so the table can be walked with an ID cursor while new
+// dead letters are added.
+type DeadLetterFilter struct {
+	MessageType string
+	AfterID     int64
+	MaxID       int64
+	OldestFirst bool
+	Limit       int
+	Offset      int
+}
+
+// Tx is a Store bound to a single transaction.
+type Tx interface {
+	Store
+	// LockEmployee takes an exclusive lock on the employee for the rest of the
+	// transaction. It returns ErrConflict instead of waiting if another
+	// transaction already holds the lock.
+	LockEmployee(ctx context.Context, employeeID string) error
+	// LockJob takes an exclusive lock on a background job, such as a
+	// reconciliation run, for the rest of the transaction, so only one
+	// replica runs it at a time. It returns ErrConflict instead of waiting if
+	// another transaction already holds the lock.
+	LockJob(ctx context.Context, job string) error
+	// CreateOutboxMessage stores msg so that it is published only if the
+	// transaction commits.
+	CreateOutboxMessage(ctx context.Context, msg *model.QueueMessage) error
+	// ClaimOutboxMessages returns up to limit unsent outbox messages, oldest
+	// first, and leases them for lease so they can be published after the
+	// transaction commits. Messages leased by someone else are skipped until
+	// the lease runs out. Messages that can't be decoded are parked instead:
+	// the error goes in last_error and they are never claimed again.
+	ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxMessage, error)
+	MarkOutboxSent(ctx context.Context, id int64) error
+	// MarkOutboxFailed records a failed publish and leases the message for
+	// retryAfter, so the messages behind it are published first.
+	MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAfter time.Duration) error
+	// ReleaseOutboxMessages ends the lease of claimed messages that weren't
+	// published.
+	ReleaseOutboxMessages(ctx context.Context, ids []int64) error
+}
+
+// Repository is the storage contract used by the service layer. It is
+// implemented by PostgresRepository for production and MemoryRepository for
+// tests and local runs.
+type Repository interface {
+	Store
+	// WithinTransaction runs fn in a single transaction, committing if fn
+	// returns nil and rolling back otherwise.
+	WithinTransaction(ctx context.Context, fn func(tx Tx) error) error
+	Close() error
+}
+
+// Namespaces for advisory locks, so they never collide with other advisory
+// locks taken against the same database.
+const (
+	employeeLockNamespace = 4201
+	jobLockNamespace      = 4202
+)
+
+type PostgresRepository struct {
+	postgresStore
 	db *sqlx.DB
 }
 
-func NewRepository(databaseURL string) (*Repository, error) {
+// NewPostgresRepository connects to the database. It does not create or
+// alter tables; the schema is owned by the migrate package.
+func NewPostgresRepository(databaseURL string) (*PostgresRepository, error) {
 	db, err := sqlx.Connect("postgres", databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	repo := &Repository{db: db}
+	return &PostgresRepository{postgresStore: postgresStore{q: db}, db: db}, nil
+}
 
-	// Create tables if they don't exist
-	if err := repo.createTables(); err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
-	}
+// DB exposes the underlying connection pool, e.g. for running migrations.
+func (r *PostgresRepository) DB() *sqlx.DB {
+	return r.db
+}
 
-	return repo, nil
-}
-
-func (r *Repository) createTables() error {
-	// Create checkin_events table
-	createEventsTable := `
-	CREATE TABLE IF NOT EXISTS checkin_events (
-		id SERIAL PRIMARY KEY,
-		employee_id VARCHAR(50) NOT NULL,
-		event_type VARCHAR(20) NOT NULL CHECK (event_type IN ('checkin', 'checkout')),
-		timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
-		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-	);`
-
-	// Create work_sessions table
-	createSessionsTable := `
-	CREATE TABLE IF NOT EXISTS work_sessions (
-		id SERIAL PRIMARY KEY,
-		employee_id VARCHAR(50) NOT NULL,
-		checkin_time TIMESTAMP WITH TIME ZONE NOT NULL,
-		checkout_time TIMESTAMP WITH TIME ZONE,
-		hours_worked DECIMAL(5,2),
-		status VARCHAR(20) DEFAULT 'active' CHECK (status IN ('active', 'completed')),
-		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-	);`
-
-	// Create indexes
-	createIndexes := `
-	CREATE INDEX IF NOT EXISTS idx_employee_events ON checkin_events(employee_id, timestamp);
-	CREATE INDEX IF NOT EXISTS idx_employee_sessions ON work_sessions(employee_id, status);
-	`
-
-	if _, err := r.db.Exec(createEventsTable); err != nil {
-		return err
-	}
-	if _, err := r.db.Exec(createSessionsTable); err != nil {
-		return err
+func (r *PostgresRepository) WithinTransaction(ctx context.Context, fn func(tx Tx) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	if _, err := r.db.Exec(createIndexes); err != nil {
+
+	if err := fn(&postgresTx{postgresStore: postgresStore{q: tx, inTx: true}}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
 		return err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return mapError(fmt.Errorf("failed to commit transaction: %w", err))
+	}
 	return nil
 }
 
-func (r *Repository) CreateCheckinEvent(event *model.CheckinEvent) error {
+func (r *PostgresRepository) Close() error {
+	return r.db.Close()
+}
+
+// postgresStore implements Store on top of either the connection pool or an
+// open transaction.
+type postgresStore struct {
+	q    sqlx.ExtContext
+	inTx bool
+}
+
+func (s postgresStore) CreateCheckinEvent(ctx context.Context, event *model.CheckinEvent) error {
 	query := `
 		INSERT INTO checkin_events (employee_id, event_type, timestamp)
 		VALUES ($1, $2, $3)
 		RETURNING id, created_at`
 
-	return r.db.QueryRow(query, event.EmployeeID, event.EventType, event.Timestamp).
+	return s.q.QueryRowxContext(ctx, query, event.EmployeeID, event.EventType, event.Timestamp).
 		Scan(&event.ID, &event.CreatedAt)
 }
 
-func (r *Repository) GetActiveSession(employeeID string) (*model.WorkSession, error) {
+func (s postgresStore) GetActiveSession(ctx context.Context, employeeID string) (*model.WorkSession, error) {
 	var session model.WorkSession
 	query := `
 		SELECT id, employee_id, checkin_time, checkout_time, hours_worked, status, created_at, updated_at
-		FROM work_sessions 
+		FROM work_sessions
 		WHERE employee_id = $1 AND status = 'active'
-		ORDER BY created_at DESC 
+		ORDER BY created_at DESC
 		LIMIT 1`
 
-	err := r.db.Get(&session, query, employeeID)
+	// Inside a transaction the session is about to be closed, so hold its row
+	// until commit.
+	if s.inTx {
+		query += " FOR UPDATE"
+	}
+
+	err := sqlx.GetContext(ctx, s.q, &session, query, employeeID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	return &session, err
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
 }
 
-func (r *Repository) CreateWorkSession(session *model.WorkSession) error {
+func (s postgresStore) CreateWorkSession(ctx context.Context, session *model.WorkSession) error {
 	query := `
 		INSERT INTO work_sessions (employee_id, checkin_time, status)
 		VALUES ($1, $2, $3)
 		RETURNING id, created_at, updated_at`
 
-	return r.db.QueryRow(query, session.EmployeeID, session.CheckinTime, session.Status).
+	err := s.q.QueryRowxContext(ctx, query, session.EmployeeID, session.CheckinTime, session.Status).
 		Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt)
+	return mapError(err)
 }
 
-func (r *Repository) UpdateWorkSession(session *model.WorkSession) error {
+func (s postgresStore) UpdateWorkSession(ctx context.Context, session *model.WorkSession) error {
 	query := `
-		UPDATE work_sessions 
+		UPDATE work_sessions
 		SET checkout_time = $1, hours_worked = $2, status = $3, updated_at = NOW()
 		WHERE id = $4`
 
-	_, err := r.db.Exec(query, session.CheckoutTime, session.HoursWorked, session.Status, session.ID)
-	return err
+	result, err := s.q.ExecContext(ctx, query, session.CheckoutTime, session.HoursWorked, session.Status, session.ID)
+	if err != nil {
+		return mapError(err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("work session %d not found", session.ID)
+	}
+	return nil
 }
 
-func (r *Repository) Close() error {
-	return r.db.Close()
+type postgresTx struct {
+	postgresStore
+}
+
+func (t *postgresTx) LockEmployee(ctx context.Context, employeeID string) error {
+	var acquired bool
+	query := `SELECT pg_try_advisory_xact_lock($1, hashtext($2))`
+
+	if err := t.q.QueryRowxContext(ctx, query, employeeLockNamespace, employeeID).Scan(&acquired); err != nil {
+		return fmt.Errorf("failed to lock employee %s: %w", employeeID, err)
+	}
+	if !acquired {
+		return fmt.Errorf("employee %s is locked by another request: %w", employeeID, ErrConflict)
+	}
+	return nil
+}
+
+func (t *postgresTx) LockJob(ctx context.Context, job string) error {
+	var acquired bool
+	query := `SELECT pg_try_advisory_xact_lock($1, hashtext($2))`
+
+	if err := t.q.QueryRowxContext(ctx, query, jobLockNamespace, job).Scan(&acquired); err != nil {
+		return fmt.Errorf("failed to lock job %s: %w", job, err)
+	}
+	if !acquired {
+		return fmt.Errorf("job %s is running elsewhere: %w", job, ErrConflict)
+	}
+	return nil
+}
+
+// mapError translates constraint violations into ErrConflict so callers do
+// not need to know about Postgres error codes.
+func mapError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505", "40001", "40P01": // unique_violation, serialization_failure, deadlock_detected
+			return fmt.Errorf("%s: %w", pqErr.Message, ErrConflict)
+		}
+	}
+	return err
 }
@@ -0,0 +1,916 @@
+// Package repotest holds the conformance suite every repository.Repository
+// implementation must pass. Backends call Run from their own tests with a
+// constructor that returns a ready-to-use repository.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/payload"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+)
+
+// Factory returns a repository for a single subtest. Implementations backed
+// by shared infrastructure may return the same database each time; the suite
+// uses unique employee IDs so runs never collide.
+type Factory func(t *testing.T) repository.Repository
+
+var employeeSeq atomic.Int64
+
+// The suite has no deadlines of its own; subtests fail through t instead.
+var ctx = context.Background()
+
+// EmployeeID returns an employee ID that is unique across the process, so
+// suites can share one database without cleaning it between subtests.
+func EmployeeID(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), employeeSeq.Add(1))
+}
+
+// Run executes the full conformance suite against repositories produced by
+// newRepo.
+func Run(t *testing.T, newRepo Factory) {
+	t.Run("CreateCheckinEventAssignsID", func(t *testing.T) {
+		testCreateCheckinEvent(t, newRepo(t))
+	})
+	t.Run("NoActiveSession", func(t *testing.T) {
+		testNoActiveSession(t, newRepo(t))
+	})
+	t.Run("SessionLifecycle", func(t *testing.T) {
+		testSessionLifecycle(t, newRepo(t))
+	})
+	t.Run("ActiveSessionIsPerEmployee", func(t *testing.T) {
+		testActiveSessionIsPerEmployee(t, newRepo(t))
+	})
+	t.Run("UpdateUnknownSession", func(t *testing.T) {
+		testUpdateUnknownSession(t, newRepo(t))
+	})
+	t.Run("ConcurrentWrites", func(t *testing.T) {
+		testConcurrentWrites(t, newRepo(t))
+	})
+	t.Run("SingleActiveSession", func(t *testing.T) {
+		testSingleActiveSession(t, newRepo(t))
+	})
+	t.Run("TransactionCommit", func(t *testing.T) {
+		testTransactionCommit(t, newRepo(t))
+	})
+	t.Run("TransactionRollback", func(t *testing.T) {
+		testTransactionRollback(t, newRepo(t))
+	})
+	t.Run("ConcurrentCheckins", func(t *testing.T) {
+		testConcurrentCheckins(t, newRepo(t))
+	})
+	t.Run("Outbox", func(t *testing.T) {
+		testOutbox(t, newRepo(t))
+	})
+	t.Run("DeadLetters", func(t *testing.T) {
+		testDeadLetters(t, newRepo(t))
+	})
+	t.Run("LegacyDeliveries", func(t *testing.T) {
+		testLegacyDeliveries(t, newRepo(t))
+	})
+	t.Run("UnreportedSessions", func(t *testing.T) {
+		testUnreportedSessions(t, newRepo(t))
+	})
+	t.Run("CompletedSessions", func(t *testing.T) {
+		testCompletedSessions(t, newRepo(t))
+	})
+	t.Run("ExportWatermarks", func(t *testing.T) {
+		testExportWatermarks(t, newRepo(t))
+	})
+	t.Run("EmployeePreferences", func(t *testing.T) {
+		testEmployeePreferences(t, newRepo(t))
+	})
+	t.Run("SumHoursWorked", func(t *testing.T) {
+		testSumHoursWorked(t, newRepo(t))
+	})
+}
+
+func testCreateCheckinEvent(t *testing.T, repo repository.Repository) {
+	first := &model.CheckinEvent{
+		EmployeeID: EmployeeID("event"),
+		EventType:  "checkin",
+		Timestamp:  time.Now(),
+	}
+	if err := repo.CreateCheckinEvent(ctx, first); err != nil {
+		t.Fatalf("CreateCheckinEvent: %v", err)
+	}
+	if first.ID == 0 {
+		t.Fatal("CreateCheckinEvent did not assign an ID")
+	}
+	if first.CreatedAt.IsZero() {
+		t.Fatal("CreateCheckinEvent did not set CreatedAt")
+	}
+
+	second := &model.CheckinEvent{
+		EmployeeID: first.EmployeeID,
+		EventType:  "checkout",
+		Timestamp:  time.Now(),
+	}
+	if err := repo.CreateCheckinEvent(ctx, second); err != nil {
+		t.Fatalf("CreateCheckinEvent: %v", err)
+	}
+	if second.ID == first.ID {
+		t.Fatalf("events share ID %d", first.ID)
+	}
+}
+
+func testNoActiveSession(t *testing.T, repo repository.Repository) {
+	session, err := repo.GetActiveSession(ctx, EmployeeID("nobody"))
+	if err != nil {
+		t.Fatalf("GetActiveSession: %v", err)
+	}
+	if session != nil {
+		t.Fatalf("expected no active session, got %+v", session)
+	}
+}
+
+func testSessionLifecycle(t *testing.T, repo repository.Repository) {
+	employeeID := EmployeeID("lifecycle")
+	checkin := time.Now().Add(-8 * time.Hour).Truncate(time.Second)
+
+	session := &model.WorkSession{
+		EmployeeID:  employeeID,
+		CheckinTime: checkin,
+		Status:      "active",
+	}
+	if err := repo.CreateWorkSession(ctx, session); err != nil {
+		t.Fatalf("CreateWorkSession: %v", err)
+	}
+	if session.ID == 0 {
+		t.Fatal("CreateWorkSession did not assign an ID")
+	}
+
+	active, err := repo.GetActiveSession(ctx, employeeID)
+	if err != nil {
+		t.Fatalf("GetActiveSession: %v", err)
+	}
+	if active == nil {
+		t.Fatal("expected an active session")
+	}
+	if active.ID != session.ID || active.EmployeeID != employeeID || active.Status != "active" {
+		t.Fatalf("unexpected active session %+v", active)
+	}
+	if !active.CheckinTime.Equal(checkin) {
+		t.Fatalf("checkin time = %v, want %v", active.CheckinTime, checkin)
+	}
+	if active.CheckoutTime != nil || active.HoursWorked != nil {
+		t.Fatalf("active session already has checkout data: %+v", active)
+	}
+
+	checkout := checkin.Add(8 * time.Hour)
+	hours := 8.0
+	active.CheckoutTime = &checkout
+	active.HoursWorked = &hours
+	active.Status = "completed"
+	if err := repo.UpdateWorkSession(ctx, active); err != nil {
+		t.Fatalf("UpdateWorkSession: %v", err)
+	}
+
+	after, err := repo.GetActiveSession(ctx, employeeID)
+	if err != nil {
+		t.Fatalf("GetActiveSession: %v", err)
+	}
+	if after != nil {
+		t.Fatalf("completed session still reported as active: %+v", after)
+	}
+}
+
+func testActiveSessionIsPerEmployee(t *testing.T, repo repository.Repository) {
+	alice := EmployeeID("alice")
+	bob := EmployeeID("bob")
+
+	session := &model.WorkSession{EmployeeID: alice, CheckinTime: time.Now(), Status: "active"}
+	if err := repo.CreateWorkSession(ctx, session); err != nil {
+		t.Fatalf("CreateWorkSession: %v", err)
+	}
+
+	active, err := repo.GetActiveSession(ctx, bob)
+	if err != nil {
+		t.Fatalf("GetActiveSession: %v", err)
+	}
+	if active != nil {
+		t.Fatalf("bob sees alice's session: %+v", active)
+	}
+}
+
+func testUpdateUnknownSession(t *testing.T, repo repository.Repository) {
+	now := time.Now()
+	hours := 1.0
+	missing := &model.WorkSession{
+		ID:           -1,
+		EmployeeID:   EmployeeID("missing"),
+		CheckoutTime: &now,
+		HoursWorked:  &hours,
+		Status:       "completed",
+	}
+	if err := repo.UpdateWorkSession(ctx, missing); err == nil {
+		t.Fatal("expected an error updating a session that does not exist")
+	}
+}
+
+func testConcurrentWrites(t *testing.T, repo repository.Repository) {
+	const writers = 20
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		ids = make(map[int]bool)
+	)
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			event := &model.CheckinEvent{
+				EmployeeID: EmployeeID("concurrent"),
+				EventType:  "checkin",
+				Timestamp:  time.Now(),
+			}
+			if err := repo.CreateCheckinEvent(ctx, event); err != nil {
+				t.Errorf("CreateCheckinEvent: %v", err)
+				return
+			}
+			mu.Lock()
+			ids[event.ID] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(ids) != writers {
+		t.Fatalf("got %d distinct event IDs, want %d", len(ids), writers)
+	}
+}
+
+func testSingleActiveSession(t *testing.T, repo repository.Repository) {
+	employeeID := EmployeeID("single")
+
+	first := &model.WorkSession{EmployeeID: employeeID, CheckinTime: time.Now(), Status: "active"}
+	if err := repo.CreateWorkSession(ctx, first); err != nil {
+		t.Fatalf("CreateWorkSession: %v", err)
+	}
+
+	second := &model.WorkSession{EmployeeID: employeeID, CheckinTime: time.Now(), Status: "active"}
+	err := repo.CreateWorkSession(ctx, second)
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("second active session: got %v, want ErrConflict", err)
+	}
+}
+
+func testTransactionCommit(t *testing.T, repo repository.Repository) {
+	employeeID := EmployeeID("commit")
+
+	err := repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		if err := tx.LockEmployee(ctx, employeeID); err != nil {
+			return err
+		}
+		event := &model.CheckinEvent{EmployeeID: employeeID, EventType: "checkin", Timestamp: time.Now()}
+		if err := tx.CreateCheckinEvent(ctx, event); err != nil {
+			return err
+		}
+		session := &model.WorkSession{EmployeeID: employeeID, CheckinTime: event.Timestamp, Status: "active"}
+		if err := tx.CreateWorkSession(ctx, session); err != nil {
+			return err
+		}
+
+		// Writes are visible inside the transaction before commit.
+		active, err := tx.GetActiveSession(ctx, employeeID)
+		if err != nil {
+			return err
+		}
+		if active == nil || active.ID != session.ID {
+			return fmt.Errorf("transaction cannot see its own session: %+v", active)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithinTransaction: %v", err)
+	}
+
+	active, err := repo.GetActiveSession(ctx, employeeID)
+	if err != nil {
+		t.Fatalf("GetActiveSession: %v", err)
+	}
+	if active == nil {
+		t.Fatal("committed session is not visible")
+	}
+}
+
+func testTransactionRollback(t *testing.T, repo repository.Repository) {
+	employeeID := EmployeeID("rollback")
+	errAbort := errors.New("abort")
+
+	err := repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		session := &model.WorkSession{EmployeeID: employeeID, CheckinTime: time.Now(), Status: "active"}
+		if err := tx.CreateWorkSession(ctx, session); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithinTransaction: got %v, want the callback error", err)
+	}
+
+	active, err := repo.GetActiveSession(ctx, employeeID)
+	if err != nil {
+		t.Fatalf("GetActiveSession: %v", err)
+	}
+	if active != nil {
+		t.Fatalf("rolled back session is visible: %+v", active)
+	}
+}
+
+// testConcurrentCheckins mirrors the service's check-in transaction from
+// several goroutines at once and verifies only one session is ever opened.
+func testConcurrentCheckins(t *testing.T, repo repository.Repository) {
+	const swipes = 10
+	employeeID := EmployeeID("swipe")
+
+	var (
+		wg      sync.WaitGroup
+		created atomic.Int64
+	)
+
+	for i := 0; i < swipes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+				if err := tx.LockEmployee(ctx, employeeID); err != nil {
+					return err
+				}
+				active, err := tx.GetActiveSession(ctx, employeeID)
+				if err != nil || active != nil {
+					return err
+				}
+				session := &model.WorkSession{EmployeeID: employeeID, CheckinTime: time.Now(), Status: "active"}
+				if err := tx.CreateWorkSession(ctx, session); err != nil {
+					return err
+				}
+				created.Add(1)
+				return nil
+			})
+			if err != nil && !errors.Is(err, repository.ErrConflict) {
+				t.Errorf("WithinTransaction: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := created.Load(); n != 1 {
+		t.Fatalf("%d sessions created by concurrent swipes, want 1", n)
+	}
+}
+
+func testOutbox(t *testing.T, repo repository.Repository) {
+	errAbort := errors.New("abort")
+	msgType := EmployeeID("outbox_test")
+	rolledBackID := EmployeeID("rolled-back")
+
+	// Messages written in a rolled back transaction are never published.
+	err := repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		if err := tx.CreateOutboxMessage(ctx, &model.QueueMessage{ID: rolledBackID, Type: msgType}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithinTransaction: got %v, want the callback error", err)
+	}
+
+	report := model.LaborCostReport{EmployeeID: "E1", HoursWorked: 7.5, Date: "2024-01-15", SessionID: 1, IdempotencyKey: "work-session-1"}
+	data, version, err := payload.Encode(payload.TypeLaborCostReport, report)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	msg := &model.QueueMessage{
+		ID:            EmployeeID("msg"),
+		Type:          payload.TypeLaborCostReport,
+		SchemaVersion: version,
+		Payload:       data,
+		MaxAttempts:   5,
+	}
+	err = repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		return tx.CreateOutboxMessage(ctx, msg)
+	})
+	if err != nil {
+		t.Fatalf("CreateOutboxMessage: %v", err)
+	}
+
+	// Claim until our message shows up; a shared database may hold others,
+	// which are released again.
+	var claimed *model.OutboxMessage
+	err = repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		pending, err := tx.ClaimOutboxMessages(ctx, 1000, time.Minute)
+		if err != nil {
+			return err
+		}
+		var others []int64
+		for i := range pending {
+			switch pending[i].Message.ID {
+			case rolledBackID:
+				return fmt.Errorf("rolled back outbox message was claimed: %+v", pending[i])
+			case msg.ID:
+				claimed = &pending[i]
+			default:
+				others = append(others, pending[i].ID)
+			}
+		}
+		if claimed == nil {
+			return errors.New("outbox message was not claimed")
+		}
+		return tx.ReleaseOutboxMessages(ctx, others)
+	})
+	if err != nil {
+		t.Fatalf("ClaimOutboxMessages: %v", err)
+	}
+
+	// Leased messages aren't claimed again until the lease ends.
+	if ids := claimOutbox(t, repo); ids[claimed.ID] {
+		t.Fatal("leased outbox message claimed again")
+	}
+	// A failed message is claimed again once its retry delay has passed.
+	markOutboxFailed(t, repo, claimed.ID, 0)
+	if ids := claimOutbox(t, repo); !ids[claimed.ID] {
+		t.Fatal("outbox message not claimed again after its retry delay")
+	}
+	markOutboxFailed(t, repo, claimed.ID, time.Minute)
+	if ids := claimOutbox(t, repo); ids[claimed.ID] {
+		t.Fatal("failed outbox message claimed again before its retry delay")
+	}
+	err = repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		return tx.ReleaseOutboxMessages(ctx, []int64{claimed.ID})
+	})
+	if err != nil {
+		t.Fatalf("ReleaseOutboxMessages: %v", err)
+	}
+
+	if claimed.Message.ID != msg.ID || claimed.Message.SchemaVersion != version {
+		t.Fatalf("claimed message does not match: %+v", claimed.Message)
+	}
+	if got, err := payload.Decode[model.LaborCostReport](&claimed.Message); err != nil || got != report {
+		t.Fatalf("claimed payload: got %+v (%v), want %+v", got, err, report)
+	}
+
+	pendingBefore, err := repo.CountPendingOutbox(ctx)
+	if err != nil {
+		t.Fatalf("CountPendingOutbox: %v", err)
+	}
+
+	err = repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		return tx.MarkOutboxSent(ctx, claimed.ID)
+	})
+	if err != nil {
+		t.Fatalf("MarkOutboxSent: %v", err)
+	}
+
+	pendingAfter, err := repo.CountPendingOutbox(ctx)
+	if err != nil {
+		t.Fatalf("CountPendingOutbox: %v", err)
+	}
+	if pendingAfter != pendingBefore-1 {
+		t.Fatalf("pending outbox count went from %d to %d, want one fewer", pendingBefore, pendingAfter)
+	}
+
+	// Sent messages are no longer claimed.
+	if ids := claimOutbox(t, repo); ids[claimed.ID] {
+		t.Fatal("sent outbox message claimed again")
+	}
+
+	if _, err := repo.PurgeSentOutbox(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("PurgeSentOutbox: %v", err)
+	}
+}
+
+func markOutboxFailed(t *testing.T, repo repository.Repository, id int64, retryAfter time.Duration) {
+	t.Helper()
+	err := repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		return tx.MarkOutboxFailed(ctx, id, "broker unavailable", retryAfter)
+	})
+	if err != nil {
+		t.Fatalf("MarkOutboxFailed: %v", err)
+	}
+}
+
+// claimOutbox claims every claimable outbox message and releases them
+// again, returning the IDs that were claimed.
+func claimOutbox(t *testing.T, repo repository.Repository) map[int64]bool {
+	t.Helper()
+
+	ids := make(map[int64]bool)
+	err := repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		pending, err := tx.ClaimOutboxMessages(ctx, 1000, time.Minute)
+		if err != nil {
+			return err
+		}
+		released := make([]int64, 0, len(pending))
+		for _, p := range pending {
+			ids[p.ID] = true
+			released = append(released, p.ID)
+		}
+		return tx.ReleaseOutboxMessages(ctx, released)
+	})
+	if err != nil {
+		t.Fatalf("ClaimOutboxMessages: %v", err)
+	}
+	return ids
+}
+
+func testDeadLetters(t *testing.T, repo repository.Repository) {
+	msgType := EmployeeID("dead_test")
+
+	first := &model.DeadLetter{
+		Message: model.QueueMessage{ID: EmployeeID("dead"), Type: msgType, Attempts: 5},
+		Reason:  "legacy API returned 500",
+	}
+	second := &model.DeadLetter{
+		Message: model.QueueMessage{ID: EmployeeID("dead"), Type: msgType, Attempts: 3},
+		Reason:  "smtp timeout",
+	}
+	for _, dl := range []*model.DeadLetter{first, second} {
+		if err := repo.CreateDeadLetter(ctx, dl); err != nil {
+			t.Fatalf("CreateDeadLetter: %v", err)
+		}
+		if dl.ID == 0 || dl.FailedAt.IsZero() {
+			t.Fatalf("CreateDeadLetter did not assign ID and FailedAt: %+v", dl)
+		}
+	}
+
+	page, total, err := repo.ListDeadLetters(ctx, repository.DeadLetterFilter{MessageType: msgType, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListDeadLetters: %v", err)
+	}
+	if total != 2 || len(page) != 1 {
+		t.Fatalf("got %d of %d dead letters, want 1 of 2", len(page), total)
+	}
+	if page[0].ID != second.ID {
+		t.Fatalf("first page holds %d, want newest %d", page[0].ID, second.ID)
+	}
+
+	// An ID cursor walks (AfterID, MaxID] oldest first.
+	cursorTests := []struct {
+		filter repository.DeadLetterFilter
+		want   []int64
+	}{
+		{repository.DeadLetterFilter{OldestFirst: true}, []int64{first.ID, second.ID}},
+		{repository.DeadLetterFilter{AfterID: first.ID, OldestFirst: true}, []int64{second.ID}},
+		{repository.DeadLetterFilter{MaxID: first.ID, OldestFirst: true}, []int64{first.ID}},
+		{repository.DeadLetterFilter{AfterID: second.ID}, nil},
+	}
+	for _, tt := range cursorTests {
+		tt.filter.MessageType = msgType
+		tt.filter.Limit = 10
+		page, total, err := repo.ListDeadLetters(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("ListDeadLetters(%+v): %v", tt.filter, err)
+		}
+		var got []int64
+		for _, dl := range page {
+			got = append(got, dl.ID)
+		}
+		if total != len(tt.want) || !slices.Equal(got, tt.want) {
+			t.Fatalf("ListDeadLetters(%+v) = %v of %d, want %v", tt.filter, got, total, tt.want)
+		}
+	}
+
+	got, err := repo.GetDeadLetter(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("GetDeadLetter: %v", err)
+	}
+	if got == nil || got.Reason != first.Reason || got.Message.ID != first.Message.ID || got.Message.Attempts != 5 {
+		t.Fatalf("GetDeadLetter = %+v, want %+v", got, first)
+	}
+
+	if err := repo.DeleteDeadLetter(ctx, first.ID); err != nil {
+		t.Fatalf("DeleteDeadLetter: %v", err)
+	}
+	if err := repo.DeleteDeadLetter(ctx, first.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("second DeleteDeadLetter: got %v, want ErrNotFound", err)
+	}
+	if got, err := repo.GetDeadLetter(ctx, first.ID); err != nil || got != nil {
+		t.Fatalf("deleted dead letter still readable: %+v, %v", got, err)
+	}
+}
+
+func testLegacyDeliveries(t *testing.T, repo repository.Repository) {
+	key := EmployeeID("delivery")
+	sessionID := 42
+
+	if got, err := repo.GetLegacyDelivery(ctx, key); err != nil || got != nil {
+		t.Fatalf("GetLegacyDelivery before any attempt = %+v, %v; want nil", got, err)
+	}
+
+	reason := "legacy API returned 503"
+	failed := &model.LegacyDelivery{
+		IdempotencyKey: key,
+		MessageID:      "msg-1",
+		EmployeeID:     "E1",
+		SessionID:      &sessionID,
+		HoursWorked:    7.25,
+		WorkDate:       "2024-01-15",
+		Status:         "failed",
+		LastError:      &reason,
+	}
+	if err := repo.RecordLegacyDelivery(ctx, failed); err != nil {
+		t.Fatalf("RecordLegacyDelivery: %v", err)
+	}
+	if failed.Attempts != 1 || failed.FirstAttemptAt.IsZero() || failed.AcknowledgedAt != nil {
+		t.Fatalf("first attempt recorded as %+v", failed)
+	}
+
+	acknowledged := &model.LegacyDelivery{
+		IdempotencyKey: key,
+		MessageID:      "msg-1",
+		EmployeeID:     "E1",
+		SessionID:      &sessionID,
+		HoursWorked:    7.25,
+		WorkDate:       "2024-01-15",
+		Status:         "acknowledged",
+	}
+	if err := repo.RecordLegacyDelivery(ctx, acknowledged); err != nil {
+		t.Fatalf("RecordLegacyDelivery: %v", err)
+	}
+	if acknowledged.Attempts != 2 || acknowledged.AcknowledgedAt == nil || acknowledged.LastError != nil {
+		t.Fatalf("second attempt recorded as %+v", acknowledged)
+	}
+
+	// A late failure report, e.g. from a redelivered copy of the message,
+	// doesn't undo the acknowledgement.
+	late := *failed
+	if err := repo.RecordLegacyDelivery(ctx, &late); err != nil {
+		t.Fatalf("RecordLegacyDelivery: %v", err)
+	}
+
+	got, err := repo.GetLegacyDelivery(ctx, key)
+	if err != nil {
+		t.Fatalf("GetLegacyDelivery: %v", err)
+	}
+	if got == nil || got.Status != "acknowledged" || got.Attempts != 3 || got.AcknowledgedAt == nil {
+		t.Fatalf("GetLegacyDelivery = %+v, want acknowledged after 3 attempts", got)
+	}
+	if got.SessionID == nil || *got.SessionID != sessionID || got.HoursWorked != 7.25 || got.WorkDate != "2024-01-15" {
+		t.Fatalf("GetLegacyDelivery = %+v, want the recorded report", got)
+	}
+
+	// A report that was already attempted can't be marked queued
+	if queued, err := repo.MarkLegacyDeliveryQueued(ctx, acknowledged); err != nil || queued {
+		t.Fatalf("MarkLegacyDeliveryQueued on an acknowledged delivery = %v, %v; want false", queued, err)
+	}
+
+	requeued := &model.LegacyDelivery{
+		IdempotencyKey: EmployeeID("requeued"),
+		MessageID:      "msg-2",
+		EmployeeID:     "E1",
+		SessionID:      &sessionID,
+		HoursWorked:    7.25,
+		WorkDate:       "2024-01-15",
+	}
+	if queued, err := repo.MarkLegacyDeliveryQueued(ctx, requeued); err != nil || !queued {
+		t.Fatalf("MarkLegacyDeliveryQueued = %v, %v; want true", queued, err)
+	}
+	if requeued.Status != "queued" || requeued.Attempts != 0 {
+		t.Fatalf("queued delivery recorded as %+v", requeued)
+	}
+	again := *requeued
+	if queued, err := repo.MarkLegacyDeliveryQueued(ctx, &again); err != nil || queued {
+		t.Fatalf("MarkLegacyDeliveryQueued twice = %v, %v; want false", queued, err)
+	}
+
+	// The first attempt replaces the queued status
+	requeued.Status = "failed"
+	requeued.LastError = &reason
+	if err := repo.RecordLegacyDelivery(ctx, requeued); err != nil {
+		t.Fatalf("RecordLegacyDelivery: %v", err)
+	}
+	if requeued.Status != "failed" || requeued.Attempts != 1 {
+		t.Fatalf("attempt after queueing recorded as %+v", requeued)
+	}
+}
+
+func testUnreportedSessions(t *testing.T, repo repository.Repository) {
+	employeeID := EmployeeID("reconcile")
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	complete := func(checkout time.Time) int {
+		t.Helper()
+		session := &model.WorkSession{
+			EmployeeID:  employeeID,
+			CheckinTime: checkout.Add(-8 * time.Hour),
+			Status:      "active",
+		}
+		if err := repo.CreateWorkSession(ctx, session); err != nil {
+			t.Fatalf("CreateWorkSession: %v", err)
+		}
+		hours := 8.0
+		session.CheckoutTime = &checkout
+		session.HoursWorked = &hours
+		session.Status = "completed"
+		if err := repo.UpdateWorkSession(ctx, session); err != nil {
+			t.Fatalf("UpdateWorkSession: %v", err)
+		}
+		return session.ID
+	}
+	record := func(sessionID int, status string) {
+		t.Helper()
+		delivery := &model.LegacyDelivery{
+			IdempotencyKey: EmployeeID("delivery"),
+			MessageID:      EmployeeID("msg"),
+			EmployeeID:     employeeID,
+			SessionID:      &sessionID,
+			HoursWorked:    8,
+			WorkDate:       "2024-03-04",
+			Status:         status,
+		}
+		if err := repo.RecordLegacyDelivery(ctx, delivery); err != nil {
+			t.Fatalf("RecordLegacyDelivery: %v", err)
+		}
+	}
+
+	acknowledged := complete(day.Add(9 * time.Hour))
+	record(acknowledged, "acknowledged")
+	failed := complete(day.Add(12 * time.Hour))
+	record(failed, "failed")
+	// Delivered under two keys: listed once, with the latest delivery
+	twice := complete(day.Add(14 * time.Hour))
+	record(twice, "failed")
+	time.Sleep(time.Millisecond)
+	record(twice, "rejected")
+	missing := complete(day.Add(17 * time.Hour))
+	complete(day.Add(24 * time.Hour)) // outside the range
+	if err := repo.CreateWorkSession(ctx, &model.WorkSession{EmployeeID: employeeID, CheckinTime: day, Status: "active"}); err != nil {
+		t.Fatalf("CreateWorkSession: %v", err)
+	}
+
+	discrepancies, total, err := repo.ListUnreportedSessions(ctx, day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("ListUnreportedSessions: %v", err)
+	}
+	// Other subtests may share the database, so only this employee's
+	// sessions are checked exactly
+	if total < 4 {
+		t.Fatalf("ListUnreportedSessions total = %d, want at least 4", total)
+	}
+	var ours []model.ReportDiscrepancy
+	for _, d := range discrepancies {
+		if d.EmployeeID == employeeID {
+			ours = append(ours, d)
+		}
+	}
+	if len(ours) != 3 {
+		t.Fatalf("ListUnreportedSessions = %+v, want sessions %d, %d and %d once each", ours, failed, twice, missing)
+	}
+	if ours[0].SessionID != failed || ours[0].DeliveryStatus == nil || *ours[0].DeliveryStatus != "failed" || ours[0].Attempts != 1 {
+		t.Fatalf("first discrepancy = %+v, want the failed delivery of session %d", ours[0], failed)
+	}
+	if ours[1].SessionID != twice || ours[1].DeliveryStatus == nil || *ours[1].DeliveryStatus != "rejected" {
+		t.Fatalf("second discrepancy = %+v, want the latest, rejected delivery of session %d", ours[1], twice)
+	}
+	if ours[2].SessionID != missing || ours[2].DeliveryStatus != nil || ours[2].HoursWorked != 8 {
+		t.Fatalf("third discrepancy = %+v, want session %d never delivered", ours[2], missing)
+	}
+	if !ours[2].CheckoutTime.Equal(day.Add(17 * time.Hour)) {
+		t.Fatalf("checkout time = %v, want %v", ours[2].CheckoutTime, day.Add(17*time.Hour))
+	}
+}
+
+func testCompletedSessions(t *testing.T, repo repository.Repository) {
+	employeeID := EmployeeID("export")
+	day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+
+	var ids []int
+	for _, checkout := range []time.Time{day.Add(17 * time.Hour), day.Add(9 * time.Hour), day.Add(24 * time.Hour)} {
+		session := &model.WorkSession{EmployeeID: employeeID, CheckinTime: checkout.Add(-8 * time.Hour), Status: "active"}
+		if err := repo.CreateWorkSession(ctx, session); err != nil {
+			t.Fatalf("CreateWorkSession: %v", err)
+		}
+		hours := 8.0
+		session.CheckoutTime = &checkout
+		session.HoursWorked = &hours
+		session.Status = "completed"
+		if err := repo.UpdateWorkSession(ctx, session); err != nil {
+			t.Fatalf("UpdateWorkSession: %v", err)
+		}
+		ids = append(ids, session.ID)
+	}
+	if err := repo.CreateWorkSession(ctx, &model.WorkSession{EmployeeID: employeeID, CheckinTime: day, Status: "active"}); err != nil {
+		t.Fatalf("CreateWorkSession: %v", err)
+	}
+
+	sessions, err := repo.ListCompletedSessions(ctx, day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("ListCompletedSessions: %v", err)
+	}
+	var ours []model.WorkSession
+	for _, s := range sessions {
+		if s.EmployeeID == employeeID {
+			ours = append(ours, s)
+		}
+	}
+	// Oldest checkout first; the session checked out at midnight is outside
+	// the range and the active one isn't completed
+	if len(ours) != 2 || ours[0].ID != ids[1] || ours[1].ID != ids[0] {
+		t.Fatalf("ListCompletedSessions = %+v, want sessions %d and %d", ours, ids[1], ids[0])
+	}
+	if ours[1].CheckoutTime == nil || !ours[1].CheckoutTime.Equal(day.Add(17*time.Hour)) || ours[1].HoursWorked == nil || *ours[1].HoursWorked != 8 {
+		t.Fatalf("ListCompletedSessions returned %+v", ours[1])
+	}
+}
+
+func testExportWatermarks(t *testing.T, repo repository.Repository) {
+	name := EmployeeID("exporter")
+
+	if got, err := repo.GetExportWatermark(ctx, name); err != nil || got != nil {
+		t.Fatalf("GetExportWatermark before any export = %v, %v; want nil", got, err)
+	}
+
+	for _, until := range []time.Time{
+		time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+	} {
+		if err := repo.SetExportWatermark(ctx, name, until); err != nil {
+			t.Fatalf("SetExportWatermark: %v", err)
+		}
+		got, err := repo.GetExportWatermark(ctx, name)
+		if err != nil {
+			t.Fatalf("GetExportWatermark: %v", err)
+		}
+		if got == nil || !got.Equal(until) {
+			t.Fatalf("GetExportWatermark = %v, want %v", got, until)
+		}
+	}
+}
+
+func testEmployeePreferences(t *testing.T, repo repository.Repository) {
+	employeeID := EmployeeID("prefs")
+
+	if got, err := repo.GetEmployeePreferences(ctx, employeeID); err != nil || got != nil {
+		t.Fatalf("GetEmployeePreferences before any were set = %+v, %v; want nil", got, err)
+	}
+
+	for _, locale := range []string{"de", "de-AT"} {
+		prefs := &model.EmployeePreferences{EmployeeID: employeeID, Locale: locale}
+		if err := repo.SetEmployeePreferences(ctx, prefs); err != nil {
+			t.Fatalf("SetEmployeePreferences: %v", err)
+		}
+		if prefs.UpdatedAt.IsZero() {
+			t.Fatal("SetEmployeePreferences did not set UpdatedAt")
+		}
+
+		got, err := repo.GetEmployeePreferences(ctx, employeeID)
+		if err != nil {
+			t.Fatalf("GetEmployeePreferences: %v", err)
+		}
+		if got == nil || got.EmployeeID != employeeID || got.Locale != locale {
+			t.Fatalf("GetEmployeePreferences = %+v, want locale %s", got, locale)
+		}
+	}
+}
+
+func testSumHoursWorked(t *testing.T, repo repository.Repository) {
+	employeeID := EmployeeID("week")
+	monday := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+
+	for _, s := range []struct {
+		checkout time.Time
+		hours    float64
+	}{
+		{monday.Add(17 * time.Hour), 8},
+		{monday.Add(41 * time.Hour), 7.5},
+		{monday.Add(-7 * time.Hour), 6}, // the week before
+	} {
+		session := &model.WorkSession{EmployeeID: employeeID, CheckinTime: s.checkout.Add(-time.Hour), Status: "active"}
+		if err := repo.CreateWorkSession(ctx, session); err != nil {
+			t.Fatalf("CreateWorkSession: %v", err)
+		}
+		checkout, hours := s.checkout, s.hours
+		session.CheckoutTime = &checkout
+		session.HoursWorked = &hours
+		session.Status = "completed"
+		if err := repo.UpdateWorkSession(ctx, session); err != nil {
+			t.Fatalf("UpdateWorkSession: %v", err)
+		}
+	}
+	if err := repo.CreateWorkSession(ctx, &model.WorkSession{EmployeeID: employeeID, CheckinTime: monday, Status: "active"}); err != nil {
+		t.Fatalf("CreateWorkSession: %v", err)
+	}
+
+	total, err := repo.SumHoursWorked(ctx, employeeID, monday, monday.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatalf("SumHoursWorked: %v", err)
+	}
+	if total != 15.5 {
+		t.Fatalf("SumHoursWorked = %v, want 15.5", total)
+	}
+
+	if total, err := repo.SumHoursWorked(ctx, EmployeeID("nobody"), monday, monday.AddDate(0, 0, 7)); err != nil || total != 0 {
+		t.Fatalf("SumHoursWorked without sessions = %v, %v; want 0", total, err)
+	}
+}
@@ -0,0 +1,87 @@
+package service_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/email"
+	"github.com/omaaartamer/factory-checkin-api/internal/legacy"
+	"github.com/omaaartamer/factory-checkin-api/internal/legacy/legacytest"
+	"github.com/omaaartamer/factory-checkin-api/internal/outbox"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/internal/service"
+	"github.com/omaaartamer/factory-checkin-api/internal/worker"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// TestCheckinFlow runs check-in to labor cost report the way demo mode does:
+// in-memory storage and queue, the real relay, worker and handlers, and a
+// fake legacy API.
+func TestCheckinFlow(t *testing.T) {
+	ctx := context.Background()
+
+	legacyAPI := legacytest.NewServer()
+	server := httptest.NewServer(legacyAPI)
+	defer server.Close()
+
+	cfg := config.Load()
+	cfg.LegacyAPIURL = server.URL + legacytest.ReportPath
+	cfg.EmailDelivery = "log"
+
+	repo := repository.NewMemoryRepository()
+	q := queue.NewMemoryQueue()
+	checkins := service.NewCheckinService(repo, q, cfg)
+
+	handlers := worker.NewRegistry()
+	if err := legacy.RegisterHandlers(handlers, repo, cfg); err != nil {
+		t.Fatalf("legacy.RegisterHandlers: %v", err)
+	}
+	if err := email.RegisterHandlers(handlers, repo, cfg); err != nil {
+		t.Fatalf("email.RegisterHandlers: %v", err)
+	}
+	bgWorker := worker.NewWorker(q, repo, handlers, cfg)
+	bgWorker.Start()
+	defer bgWorker.Stop()
+	relay := outbox.NewRelay(repo, q, cfg)
+
+	if resp, err := checkins.ProcessCheckin(ctx, "EMP001"); err != nil || resp.EventType != "checkin" {
+		t.Fatalf("check-in: %+v, %v", resp, err)
+	}
+	resp, err := checkins.ProcessCheckin(ctx, "EMP001")
+	if err != nil || resp.EventType != "checkout" {
+		t.Fatalf("checkout: %+v, %v", resp, err)
+	}
+
+	if pending, _ := repo.CountPendingOutbox(ctx); pending != 2 {
+		t.Fatalf("%d outbox messages after checkout, want the report and the email", pending)
+	}
+	if sent, err := relay.RelayPending(ctx); err != nil || sent != 2 {
+		t.Fatalf("RelayPending sent %d (%v), want 2", sent, err)
+	}
+	if pending, _ := repo.CountPendingOutbox(ctx); pending != 0 {
+		t.Fatalf("%d outbox messages left after relaying", pending)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(q.Completed()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("worker completed %d of 2 messages; failed: %+v", len(q.Completed()), q.Failed())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	reports := legacyAPI.Reports()
+	if len(reports) != 1 || reports[0].EmployeeID != "EMP001" || reports[0].HoursWorked != *resp.HoursWorked {
+		t.Fatalf("legacy API received %+v, want one report for EMP001", reports)
+	}
+	delivery, err := repo.GetLegacyDelivery(ctx, reports[0].IdempotencyKey)
+	if err != nil || delivery == nil || delivery.Status != "acknowledged" {
+		t.Fatalf("delivery ledger = %+v (%v), want acknowledged", delivery, err)
+	}
+	if failed := q.Failed(); len(failed) != 0 {
+		t.Fatalf("messages failed: %+v", failed)
+	}
+}
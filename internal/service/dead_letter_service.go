@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// ErrNotReplayable is returned when replaying a malformed message whose body
+// still can't be decoded.
+var ErrNotReplayable = errors.New("dead letter cannot be replayed")
+
+// Page size used when replaying every dead letter.
+const replayBatchSize = 100
+
+// DeadLetterService lets operators inspect, replay and purge messages that
+// exhausted their retries.
+type DeadLetterService struct {
+	repo     repository.Repository
+	queue    queue.Queue
+	timeouts config.Timeouts
+}
+
+func NewDeadLetterService(repo repository.Repository, q queue.Queue, cfg *config.Config) *DeadLetterService {
+	return &DeadLetterService{
+		repo:     repo,
+		queue:    q,
+		timeouts: cfg.Timeouts,
+	}
+}
+
+func (s *DeadLetterService) List(ctx context.Context, messageType string, limit, offset int) ([]model.DeadLetter, int, error) {
+	return s.listDeadLetters(ctx, repository.DeadLetterFilter{
+		MessageType: messageType,
+		Limit:       limit,
+		Offset:      offset,
+	})
+}
+
+func (s *DeadLetterService) listDeadLetters(ctx context.Context, filter repository.DeadLetterFilter) ([]model.DeadLetter, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	return s.repo.ListDeadLetters(ctx, filter)
+}
+
+func (s *DeadLetterService) Get(ctx context.Context, id int64) (*model.DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	deadLetter, err := s.repo.GetDeadLetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if deadLetter == nil {
+		return nil, fmt.Errorf("dead letter %d: %w", id, ErrDeadLetterNotFound)
+	}
+	return deadLetter, nil
+}
+
+// Replay puts a dead message back on the queue with a fresh set of attempts.
+// The message goes through the outbox in the same transaction that removes
+// the dead letter, so it is never lost or replayed twice. A queue that keeps
+// its own dead-letter copies (RabbitMQ's dead-letter queue) keeps this one:
+// those copies are a record for broker tooling, never consumed or replayed,
+// and are only removed by Purge.
+func (s *DeadLetterService) Replay(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	return s.repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		deadLetter, err := tx.GetDeadLetter(ctx, id)
+		if err != nil {
+			return err
+		}
+		if deadLetter == nil {
+			return fmt.Errorf("dead letter %d: %w", id, ErrDeadLetterNotFound)
+		}
+
+		msg := deadLetter.Message
+		if msg.Type == queue.TypeMalformed {
+			// Replay what was received, in case this release can read it
+			original, err := queue.DecodeMalformed(&msg)
+			if err != nil {
+				return fmt.Errorf("dead letter %d: %w: %v", id, ErrNotReplayable, err)
+			}
+			msg = *original
+		}
+		msg.Attempts = 0
+		msg.Status = "pending"
+		msg.ProcessAt = time.Now()
+
+		if err := tx.CreateOutboxMessage(ctx, &msg); err != nil {
+			return fmt.Errorf("failed to queue replay: %w", err)
+		}
+		return tx.DeleteDeadLetter(ctx, id)
+	})
+}
+
+// ReplayMany replays each of the given dead letters and returns the IDs that
+// were replayed. It stops at the first failure.
+func (s *DeadLetterService) ReplayMany(ctx context.Context, ids []int64) ([]int64, error) {
+	replayed := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if err := s.Replay(ctx, id); err != nil {
+			return replayed, err
+		}
+		replayed = append(replayed, id)
+	}
+	return replayed, nil
+}
+
+// ReplayAll replays every dead letter of the given type, or of every type if
+// messageType is empty, and returns how many were replayed. Malformed
+// messages that still can't be decoded are left in place. Only dead letters
+// that existed when it started are replayed: it walks them oldest first up to
+// the newest one at the start, so a replayed message that fails again and is
+// dead-lettered anew waits for the next replay instead of looping.
+func (s *DeadLetterService) ReplayAll(ctx context.Context, messageType string) (int, error) {
+	newest, _, err := s.listDeadLetters(ctx, repository.DeadLetterFilter{MessageType: messageType, Limit: 1})
+	if err != nil || len(newest) == 0 {
+		return 0, err
+	}
+
+	replayed := 0
+	cursor := int64(0)
+	for {
+		page, _, err := s.listDeadLetters(ctx, repository.DeadLetterFilter{
+			MessageType: messageType,
+			AfterID:     cursor,
+			MaxID:       newest[0].ID,
+			OldestFirst: true,
+			Limit:       replayBatchSize,
+		})
+		if err != nil {
+			return replayed, err
+		}
+		if len(page) == 0 {
+			return replayed, nil
+		}
+
+		for _, deadLetter := range page {
+			cursor = deadLetter.ID
+			err := s.Replay(ctx, deadLetter.ID)
+			if errors.Is(err, ErrDeadLetterNotFound) || errors.Is(err, ErrNotReplayable) {
+				continue // removed by someone else meanwhile, or still unreadable
+			}
+			if err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+	}
+}
+
+// Delete discards a single dead letter without replaying it.
+func (s *DeadLetterService) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	if err := s.repo.DeleteDeadLetter(ctx, id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("dead letter %d: %w", id, ErrDeadLetterNotFound)
+		}
+		return err
+	}
+	return nil
+}
+
+// Purge discards every dead letter, including the queue's own dead-letter
+// copies when it keeps them.
+func (s *DeadLetterService) Purge(ctx context.Context) (int64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	purged, err := s.repo.PurgeDeadLetters(dbCtx)
+	if err != nil {
+		return 0, err
+	}
+
+	if purger, ok := s.queue.(queue.DeadLetterPurger); ok {
+		queueCtx, cancel := context.WithTimeout(ctx, s.timeouts.Queue)
+		defer cancel()
+
+		if _, err := purger.PurgeDeadLetters(queueCtx); err != nil {
+			return purged, err
+		}
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+func malformedDeadLetter(t *testing.T, repo repository.Repository, body string) int64 {
+	t.Helper()
+	payload, _ := json.Marshal(queue.MalformedPayload{Body: body, Error: "failed to decode"})
+	dl := &model.DeadLetter{
+		Message: model.QueueMessage{ID: queue.NewMessageID(), Type: queue.TypeMalformed, Payload: payload},
+		Reason:  "malformed message: failed to decode",
+	}
+	if err := repo.CreateDeadLetter(context.Background(), dl); err != nil {
+		t.Fatalf("CreateDeadLetter: %v", err)
+	}
+	return dl.ID
+}
+
+func TestReplayMalformedDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	svc := NewDeadLetterService(repo, queue.NewMemoryQueue(), config.Load())
+
+	stillBad := malformedDeadLetter(t, repo, "{not json")
+	readable := malformedDeadLetter(t, repo, `{"id":"original","type":"email_notification","payload":{}}`)
+
+	if err := svc.Replay(ctx, stillBad); !errors.Is(err, ErrNotReplayable) {
+		t.Fatalf("Replay of an unreadable body: got %v, want ErrNotReplayable", err)
+	}
+
+	replayed, err := svc.ReplayAll(ctx, "")
+	if err != nil {
+		t.Fatalf("ReplayAll: %v", err)
+	}
+	if replayed != 1 {
+		t.Fatalf("ReplayAll replayed %d, want 1", replayed)
+	}
+
+	// The readable one is replayed as the message it wraps
+	var claimed []model.OutboxMessage
+	err = repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		claimed, err = tx.ClaimOutboxMessages(ctx, 10, 0)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ClaimOutboxMessages: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Message.ID != "original" || claimed[0].Message.Type != "email_notification" {
+		t.Fatalf("outbox = %+v, want the original message", claimed)
+	}
+
+	if dl, _ := repo.GetDeadLetter(ctx, readable); dl != nil {
+		t.Fatal("replayed dead letter was not removed")
+	}
+	if dl, _ := repo.GetDeadLetter(ctx, stillBad); dl == nil {
+		t.Fatal("unreadable dead letter was removed")
+	}
+}
+
+// failingAgain is a repository where every replayed message fails again at
+// once and is dead-lettered anew, as it would when the cause isn't fixed.
+type failingAgain struct {
+	*repository.MemoryRepository
+}
+
+func (r failingAgain) WithinTransaction(ctx context.Context, fn func(tx repository.Tx) error) error {
+	return r.MemoryRepository.WithinTransaction(ctx, func(tx repository.Tx) error {
+		return fn(failingAgainTx{tx})
+	})
+}
+
+type failingAgainTx struct {
+	repository.Tx
+}
+
+func (tx failingAgainTx) CreateOutboxMessage(ctx context.Context, msg *model.QueueMessage) error {
+	if err := tx.Tx.CreateOutboxMessage(ctx, msg); err != nil {
+		return err
+	}
+	return tx.CreateDeadLetter(ctx, &model.DeadLetter{Message: *msg, Reason: "failed again"})
+}
+
+func TestReplayAllStopsAtDeadLettersAddedMeanwhile(t *testing.T) {
+	ctx := context.Background()
+	repo := failingAgain{repository.NewMemoryRepository()}
+	svc := NewDeadLetterService(repo, queue.NewMemoryQueue(), config.Load())
+
+	for i := 0; i < 3; i++ {
+		dl := &model.DeadLetter{
+			Message: model.QueueMessage{ID: queue.NewMessageID(), Type: "email_notification"},
+			Reason:  "smtp timeout",
+		}
+		if err := repo.CreateDeadLetter(ctx, dl); err != nil {
+			t.Fatalf("CreateDeadLetter: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	var replayed int
+	var err error
+	go func() {
+		defer close(done)
+		replayed, err = svc.ReplayAll(ctx, "")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReplayAll kept replaying the dead letters it created")
+	}
+
+	if err != nil || replayed != 3 {
+		t.Fatalf("ReplayAll = %d, %v; want 3", replayed, err)
+	}
+	list, total, err := repo.ListDeadLetters(ctx, repository.DeadLetterFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListDeadLetters: %v", err)
+	}
+	if total != 3 || list[0].Reason != "failed again" {
+		t.Fatalf("dead letters = %+v, want the three that failed again", list)
+	}
+}
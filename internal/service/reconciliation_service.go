@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+const dateLayout = "2006-01-02"
+
+// Name of the reconciliation lock taken with repository.Tx.LockJob.
+const reconciliationJob = "reconciliation"
+
+// Reports requeued per transaction, so that each transaction stays within
+// the database timeout however many reports went missing.
+const requeueBatchSize = 100
+
+// ReconciliationService compares completed work sessions with the labor cost
+// reports the legacy system acknowledged, and can re-enqueue the reports that
+// went missing.
+type ReconciliationService struct {
+	repo     repository.Repository
+	timeouts config.Timeouts
+	// Sessions completed more recently than this may still have their report
+	// on its way, so they are never re-enqueued
+	grace time.Duration
+}
+
+func NewReconciliationService(repo repository.Repository, cfg *config.Config) *ReconciliationService {
+	return &ReconciliationService{
+		repo:     repo,
+		timeouts: cfg.Timeouts,
+		grace:    time.Duration(cfg.ReconciliationGraceMinutes) * time.Minute,
+	}
+}
+
+// Reconcile reports the sessions completed between the from and to dates
+// (inclusive, in local time) whose labor cost report wasn't acknowledged.
+//
+// With requeue set, reports that were never attempted are enqueued again
+// through the outbox under their original idempotency key, so a report that
+// was only delayed is still delivered once. Reports already requeued are
+// "queued" in the ledger until they are attempted and aren't enqueued again;
+// failed and rejected deliveries are left to their retries and the
+// dead-letter queue.
+func (s *ReconciliationService) Reconcile(ctx context.Context, from, to time.Time, requeue bool) (*model.ReconciliationReport, error) {
+	start := startOfDay(from)
+	last := startOfDay(to)
+	end := last.AddDate(0, 0, 1)
+
+	listCtx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	discrepancies, total, err := s.repo.ListUnreportedSessions(listCtx, start, end)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unreported sessions: %w", err)
+	}
+
+	report := &model.ReconciliationReport{
+		From:            start.Format(dateLayout),
+		To:              last.Format(dateLayout),
+		SessionsChecked: total,
+		Discrepancies:   discrepancies,
+		GeneratedAt:     time.Now(),
+	}
+
+	if requeue {
+		requeued, err := s.requeue(ctx, discrepancies)
+		if err != nil {
+			return nil, err
+		}
+		report.Requeued = requeued
+	}
+
+	return report, nil
+}
+
+// requeue writes a fresh labor cost report to the outbox for every session
+// that has no delivery recorded and is past the grace period, marking them
+// requeued as their batch commits. Each report is recorded as "queued" in the
+// delivery ledger in the same transaction, so later runs leave it alone while
+// it waits in the outbox or queue. Only one replica requeues at a time; the
+// others requeue nothing, and a replica that finds the lock taken between
+// batches stops there.
+func (s *ReconciliationService) requeue(ctx context.Context, discrepancies []model.ReportDiscrepancy) (int, error) {
+	cutoff := time.Now().Add(-s.grace)
+
+	var due []int
+	for i, d := range discrepancies {
+		if d.DeliveryStatus == nil && !d.CheckoutTime.After(cutoff) {
+			due = append(due, i)
+		}
+	}
+
+	requeued := 0
+	for len(due) > 0 {
+		batch := due[:min(len(due), requeueBatchSize)]
+		due = due[len(batch):]
+
+		indexes, err := s.requeueBatch(ctx, discrepancies, batch)
+		if errors.Is(err, repository.ErrConflict) {
+			log.Printf("Reconciliation is requeueing reports elsewhere, not requeueing here: %v", err)
+			return requeued, nil
+		}
+		if err != nil {
+			return requeued, err
+		}
+
+		for _, i := range indexes {
+			discrepancies[i].Requeued = true
+			log.Printf("Re-enqueued labor cost report for session %d (employee %s)", discrepancies[i].SessionID, discrepancies[i].EmployeeID)
+		}
+		requeued += len(indexes)
+	}
+	return requeued, nil
+}
+
+// requeueBatch requeues the discrepancies at batch in one transaction and
+// returns the indexes of those it requeued.
+func (s *ReconciliationService) requeueBatch(ctx context.Context, discrepancies []model.ReportDiscrepancy, batch []int) ([]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	var indexes []int
+	err := s.repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		indexes = indexes[:0]
+		if err := tx.LockJob(ctx, reconciliationJob); err != nil {
+			return err
+		}
+
+		for _, i := range batch {
+			d := discrepancies[i]
+			date := d.CheckoutTime.In(time.Local).Format(dateLayout)
+			msg, err := queue.CreateLaborCostMessage(d.SessionID, d.EmployeeID, d.HoursWorked, date)
+			if err != nil {
+				return fmt.Errorf("failed to create labor cost report for session %d: %w", d.SessionID, err)
+			}
+
+			sessionID := d.SessionID
+			queued, err := tx.MarkLegacyDeliveryQueued(ctx, &model.LegacyDelivery{
+				IdempotencyKey: queue.LaborCostIdempotencyKey(d.SessionID),
+				MessageID:      msg.ID,
+				EmployeeID:     d.EmployeeID,
+				SessionID:      &sessionID,
+				HoursWorked:    d.HoursWorked,
+				WorkDate:       date,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to record requeued report for session %d: %w", d.SessionID, err)
+			}
+			if !queued {
+				// Attempted since the sessions were listed
+				continue
+			}
+
+			if err := tx.CreateOutboxMessage(ctx, msg); err != nil {
+				return fmt.Errorf("failed to queue labor cost report for session %d: %w", d.SessionID, err)
+			}
+			indexes = append(indexes, i)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
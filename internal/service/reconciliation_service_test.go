@@ -0,0 +1,154 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/internal/service"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// completeSession stores a session completed at checkout.
+func completeSession(t *testing.T, repo repository.Repository, employeeID string, checkout time.Time) int {
+	t.Helper()
+	ctx := context.Background()
+	session := &model.WorkSession{EmployeeID: employeeID, CheckinTime: checkout.Add(-8 * time.Hour), Status: "active"}
+	if err := repo.CreateWorkSession(ctx, session); err != nil {
+		t.Fatalf("CreateWorkSession: %v", err)
+	}
+	hours := 8.0
+	session.CheckoutTime = &checkout
+	session.HoursWorked = &hours
+	session.Status = "completed"
+	if err := repo.UpdateWorkSession(ctx, session); err != nil {
+		t.Fatalf("UpdateWorkSession: %v", err)
+	}
+	return session.ID
+}
+
+func TestReconcileRequeuesOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	cfg := config.Load()
+	cfg.ReconciliationGraceMinutes = 60
+	svc := service.NewReconciliationService(repo, cfg)
+
+	now := time.Now()
+	lost := completeSession(t, repo, "EMP001", now.Add(-3*time.Hour))
+	completeSession(t, repo, "EMP002", now.Add(-10*time.Minute)) // within the grace period
+
+	report, err := svc.Reconcile(ctx, now.AddDate(0, 0, -1), now, true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if report.Requeued != 1 || len(report.Discrepancies) != 2 {
+		t.Fatalf("first run = %+v, want 2 discrepancies and 1 requeued", report)
+	}
+	delivery, err := repo.GetLegacyDelivery(ctx, queue.LaborCostIdempotencyKey(lost))
+	if err != nil || delivery == nil || delivery.Status != "queued" {
+		t.Fatalf("ledger after requeue = %+v, %v; want queued", delivery, err)
+	}
+
+	// The requeued report is still waiting in the outbox, e.g. behind an
+	// open circuit breaker; later runs must not queue it again
+	for run := 2; run <= 3; run++ {
+		report, err := svc.Reconcile(ctx, now.AddDate(0, 0, -1), now, true)
+		if err != nil {
+			t.Fatalf("Reconcile: %v", err)
+		}
+		if report.Requeued != 0 {
+			t.Fatalf("run %d requeued %d reports, want 0", run, report.Requeued)
+		}
+		if pending, _ := repo.CountPendingOutbox(ctx); pending != 1 {
+			t.Fatalf("%d outbox messages after run %d, want 1", pending, run)
+		}
+	}
+}
+
+// lockedRepository is a repository whose reconciliation lock is held by
+// another replica.
+type lockedRepository struct {
+	*repository.MemoryRepository
+}
+
+func (r lockedRepository) WithinTransaction(ctx context.Context, fn func(tx repository.Tx) error) error {
+	return r.MemoryRepository.WithinTransaction(ctx, func(tx repository.Tx) error {
+		return fn(lockedTx{tx})
+	})
+}
+
+type lockedTx struct {
+	repository.Tx
+}
+
+func (lockedTx) LockJob(ctx context.Context, job string) error {
+	return fmt.Errorf("job %s is running elsewhere: %w", job, repository.ErrConflict)
+}
+
+func TestReconcileSkipsRequeueWhileLocked(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	cfg := config.Load()
+	cfg.ReconciliationGraceMinutes = 60
+	svc := service.NewReconciliationService(lockedRepository{repo}, cfg)
+
+	now := time.Now()
+	completeSession(t, repo, "EMP001", now.Add(-3*time.Hour))
+
+	report, err := svc.Reconcile(ctx, now.AddDate(0, 0, -1), now, true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if report.Requeued != 0 || len(report.Discrepancies) != 1 {
+		t.Fatalf("report = %+v, want the discrepancy listed but not requeued", report)
+	}
+	if pending, _ := repo.CountPendingOutbox(ctx); pending != 0 {
+		t.Fatalf("%d outbox messages, want none while another replica holds the lock", pending)
+	}
+}
+
+// countingRepository counts transactions.
+type countingRepository struct {
+	*repository.MemoryRepository
+	transactions int
+}
+
+func (r *countingRepository) WithinTransaction(ctx context.Context, fn func(tx repository.Tx) error) error {
+	r.transactions++
+	return r.MemoryRepository.WithinTransaction(ctx, fn)
+}
+
+func TestReconcileRequeuesInBatches(t *testing.T) {
+	ctx := context.Background()
+	repo := &countingRepository{MemoryRepository: repository.NewMemoryRepository()}
+	cfg := config.Load()
+	cfg.ReconciliationGraceMinutes = 60
+	svc := service.NewReconciliationService(repo, cfg)
+
+	now := time.Now()
+	const lost = 250
+	for i := 0; i < lost; i++ {
+		completeSession(t, repo, fmt.Sprintf("EMP%03d", i), now.Add(-3*time.Hour))
+	}
+
+	report, err := svc.Reconcile(ctx, now.AddDate(0, 0, -1), now, true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if report.Requeued != lost {
+		t.Fatalf("requeued %d reports, want all %d", report.Requeued, lost)
+	}
+	// Each transaction has its own database timeout, so a large backlog
+	// isn't requeued in one
+	if repo.transactions < 2 {
+		t.Fatalf("requeued in %d transaction(s), want several batches", repo.transactions)
+	}
+	if pending, _ := repo.CountPendingOutbox(ctx); pending != lost {
+		t.Fatalf("%d outbox messages, want %d", pending, lost)
+	}
+}
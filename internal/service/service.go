@@ -1,45 +1,86 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/omaaartamer/factory-checkin-api/internal/model"
 	"github.com/omaaartamer/factory-checkin-api/internal/queue"
 	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
 )
 
+// ConflictError is returned when a check-in collides with another request for
+// the same employee that is still being processed, e.g. a double badge swipe.
+type ConflictError struct {
+	EmployeeID string
+	Err        error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("another check-in for employee %s is already in progress", e.EmployeeID)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
 type CheckinService struct {
-	repo  *repository.Repository
-	queue queue.Queue
+	repo     repository.Repository
+	queue    queue.Queue
+	timeouts config.Timeouts
 }
 
-func NewCheckinService(repo *repository.Repository, q queue.Queue) *CheckinService {
+func NewCheckinService(repo repository.Repository, q queue.Queue, cfg *config.Config) *CheckinService {
 	return &CheckinService{
-		repo:  repo,
-		queue: q,
+		repo:     repo,
+		queue:    q,
+		timeouts: cfg.Timeouts,
 	}
 }
 
-func (s *CheckinService) ProcessCheckin(employeeID string) (*model.CheckinResponse, error) {
+func (s *CheckinService) ProcessCheckin(ctx context.Context, employeeID string) (*model.CheckinResponse, error) {
 	now := time.Now()
 
-	// Check if employee has an active session
-	activeSession, err := s.repo.GetActiveSession(employeeID)
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	// Reading the active session and writing the event and session must be
+	// atomic, otherwise two swipes arriving together can both check in.
+	var response *model.CheckinResponse
+	err := s.repo.WithinTransaction(ctx, func(tx repository.Tx) error {
+		if err := tx.LockEmployee(ctx, employeeID); err != nil {
+			return err
+		}
+
+		// Check if employee has an active session
+		activeSession, err := tx.GetActiveSession(ctx, employeeID)
+		if err != nil {
+			return fmt.Errorf("failed to check active session: %w", err)
+		}
+
+		if activeSession != nil {
+			// Employee is checking out
+			response, err = s.processCheckout(ctx, tx, employeeID, activeSession, now)
+		} else {
+			// Employee is checking in
+			response, err = s.processCheckin(ctx, tx, employeeID, now)
+		}
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to check active session: %w", err)
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, &ConflictError{EmployeeID: employeeID, Err: err}
+		}
+		return nil, err
 	}
 
-	if activeSession != nil {
-		// Employee is checking out
-		return s.processCheckout(employeeID, activeSession, now)
-	} else {
-		// Employee is checking in
-		return s.processCheckin(employeeID, now)
-	}
+	return response, nil
 }
 
-func (s *CheckinService) processCheckin(employeeID string, timestamp time.Time) (*model.CheckinResponse, error) {
+func (s *CheckinService) processCheckin(ctx context.Context, tx repository.Tx, employeeID string, timestamp time.Time) (*model.CheckinResponse, error) {
 	// Create checkin event
 	event := &model.CheckinEvent{
 		EmployeeID: employeeID,
@@ -47,7 +88,7 @@ func (s *CheckinService) processCheckin(employeeID string, timestamp time.Time)
 		Timestamp:  timestamp,
 	}
 
-	if err := s.repo.CreateCheckinEvent(event); err != nil {
+	if err := tx.CreateCheckinEvent(ctx, event); err != nil {
 		return nil, fmt.Errorf("failed to create checkin event: %w", err)
 	}
 
@@ -58,7 +99,7 @@ func (s *CheckinService) processCheckin(employeeID string, timestamp time.Time)
 		Status:      "active",
 	}
 
-	if err := s.repo.CreateWorkSession(session); err != nil {
+	if err := tx.CreateWorkSession(ctx, session); err != nil {
 		return nil, fmt.Errorf("failed to create work session: %w", err)
 	}
 
@@ -70,7 +111,7 @@ func (s *CheckinService) processCheckin(employeeID string, timestamp time.Time)
 	}, nil
 }
 
-func (s *CheckinService) processCheckout(employeeID string, activeSession *model.WorkSession, timestamp time.Time) (*model.CheckinResponse, error) {
+func (s *CheckinService) processCheckout(ctx context.Context, tx repository.Tx, employeeID string, activeSession *model.WorkSession, timestamp time.Time) (*model.CheckinResponse, error) {
 	// Create checkout event
 	event := &model.CheckinEvent{
 		EmployeeID: employeeID,
@@ -78,7 +119,7 @@ func (s *CheckinService) processCheckout(employeeID string, activeSession *model
 		Timestamp:  timestamp,
 	}
 
-	if err := s.repo.CreateCheckinEvent(event); err != nil {
+	if err := tx.CreateCheckinEvent(ctx, event); err != nil {
 		return nil, fmt.Errorf("failed to create checkout event: %w", err)
 	}
 
@@ -91,12 +132,14 @@ func (s *CheckinService) processCheckout(employeeID string, activeSession *model
 	activeSession.HoursWorked = &hoursWorked
 	activeSession.Status = "completed"
 
-	if err := s.repo.UpdateWorkSession(activeSession); err != nil {
+	if err := tx.UpdateWorkSession(ctx, activeSession); err != nil {
 		return nil, fmt.Errorf("failed to update work session: %w", err)
 	}
 
-	// Queue async tasks - these should not fail the checkout process
-	s.queueAsyncTasks(employeeID, hoursWorked, timestamp)
+	// Queue async tasks through the outbox so they commit with the checkout
+	if err := s.queueAsyncTasks(ctx, tx, activeSession); err != nil {
+		return nil, err
+	}
 
 	return &model.CheckinResponse{
 		Success:     true,
@@ -107,31 +150,88 @@ func (s *CheckinService) processCheckout(employeeID string, activeSession *model
 	}, nil
 }
 
-func (s *CheckinService) queueAsyncTasks(employeeID string, hoursWorked float64, timestamp time.Time) {
-	dateStr := timestamp.Format("2006-01-02")
+// queueAsyncTasks writes the post-checkout messages to the outbox. The outbox
+// relay publishes them once the transaction commits, so a broker outage can
+// delay a labor cost report but never lose it.
+func (s *CheckinService) queueAsyncTasks(ctx context.Context, tx repository.Tx, session *model.WorkSession) error {
+	employeeID := session.EmployeeID
+	hoursWorked := *session.HoursWorked
+	dateStr := session.CheckoutTime.Format("2006-01-02")
 
 	// Queue labor cost report - this is critical business data
-	laborCostMsg := queue.CreateLaborCostMessage(employeeID, hoursWorked, dateStr)
-	if err := s.queue.Enqueue(laborCostMsg); err != nil {
-		// Log error but don't fail checkout
-		fmt.Printf("WARNING: Failed to queue labor cost report for employee %s: %v\n", employeeID, err)
+	laborCostMsg, err := queue.CreateLaborCostMessage(session.ID, employeeID, hoursWorked, dateStr)
+	if err != nil {
+		return fmt.Errorf("failed to create labor cost report: %w", err)
+	}
+	if err := tx.CreateOutboxMessage(ctx, laborCostMsg); err != nil {
+		return fmt.Errorf("failed to queue labor cost report: %w", err)
 	}
 
 	// Queue email notification - this is nice-to-have
-	emailMsg := queue.CreateEmailMessage(employeeID, hoursWorked, dateStr)
-	if err := s.queue.Enqueue(emailMsg); err != nil {
-		// Log error but don't fail checkout
-		fmt.Printf("WARNING: Failed to queue email notification for employee %s: %v\n", employeeID, err)
+	emailMsg, err := queue.CreateEmailMessage(employeeID, hoursWorked, dateStr, session.CheckinTime, *session.CheckoutTime)
+	if err != nil {
+		return fmt.Errorf("failed to create email notification: %w", err)
+	}
+	if err := tx.CreateOutboxMessage(ctx, emailMsg); err != nil {
+		return fmt.Errorf("failed to queue email notification: %w", err)
 	}
+
+	return nil
 }
 
-func (s *CheckinService) GetEmployeeStatus(employeeID string) (*model.WorkSession, error) {
-	return s.repo.GetActiveSession(employeeID)
+func (s *CheckinService) GetEmployeeStatus(ctx context.Context, employeeID string) (*model.WorkSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	return s.repo.GetActiveSession(ctx, employeeID)
 }
 
-func (s *CheckinService) GetQueueStatus() map[string]interface{} {
-	return map[string]interface{}{
-		"pending_messages": s.queue.GetPendingCount(),
+// GetEmployeePreferences returns nil if the employee has no preferences set.
+func (s *CheckinService) GetEmployeePreferences(ctx context.Context, employeeID string) (*model.EmployeePreferences, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	prefs, err := s.repo.GetEmployeePreferences(ctx, employeeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+func (s *CheckinService) SetEmployeePreferences(ctx context.Context, prefs *model.EmployeePreferences) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	if err := s.repo.SetEmployeePreferences(ctx, prefs); err != nil {
+		return fmt.Errorf("failed to save preferences: %w", err)
+	}
+	return nil
+}
+
+// QueueHealth returns nil if the queue is usable, or why it isn't. Queues that
+// can't tell are assumed healthy.
+func (s *CheckinService) QueueHealth() error {
+	if checker, ok := s.queue.(queue.HealthChecker); ok {
+		return checker.Healthy()
+	}
+	return nil
+}
+
+func (s *CheckinService) GetQueueStatus(ctx context.Context) map[string]interface{} {
+	queueCtx, cancel := context.WithTimeout(ctx, s.timeouts.Queue)
+	defer cancel()
+
+	status := map[string]interface{}{
+		"pending_messages": s.queue.GetPendingCount(queueCtx),
 		"timestamp":        time.Now(),
 	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, s.timeouts.Database)
+	defer cancel()
+
+	if outboxPending, err := s.repo.CountPendingOutbox(dbCtx); err == nil {
+		status["outbox_pending"] = outboxPending
+	}
+
+	return status
 }
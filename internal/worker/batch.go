@@ -0,0 +1,130 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+)
+
+// batchItem is a message waiting in a batch. done is closed once the message
+// has been settled.
+type batchItem struct {
+	msg  *model.QueueMessage
+	done chan struct{}
+}
+
+// batcher collects the messages of one type into batches for its
+// BatchHandler. A batch is flushed when it reaches BatchSize or its first
+// message has waited BatchWindow, and takes one type slot and one processor
+// while it is handled.
+type batcher struct {
+	w     *Worker
+	reg   Registration
+	items chan batchItem
+}
+
+func newBatcher(w *Worker, reg Registration) *batcher {
+	return &batcher{w: w, reg: reg, items: make(chan batchItem)}
+}
+
+// add puts msg in the next batch and returns once it has been settled.
+func (b *batcher) add(msg *model.QueueMessage) {
+	item := batchItem{msg: msg, done: make(chan struct{})}
+	select {
+	case b.items <- item:
+	case <-b.w.stopping:
+		b.w.requeue(msg)
+		return
+	}
+	<-item.done
+}
+
+// collect gathers batches until the worker stops. The batch being gathered
+// then is flushed straight away.
+func (b *batcher) collect() {
+	var batch []batchItem
+	var window *time.Timer
+	var due <-chan time.Time
+
+	flush := func() {
+		if window != nil {
+			window.Stop()
+			window, due = nil, nil
+		}
+		if len(batch) > 0 {
+			go b.flush(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case item := <-b.items:
+			batch = append(batch, item)
+			if len(batch) == 1 {
+				window = time.NewTimer(b.reg.BatchWindow)
+				due = window.C
+			}
+			if len(batch) >= b.reg.BatchSize {
+				flush()
+			}
+		case <-due:
+			flush()
+		case <-b.w.stopping:
+			flush()
+			return
+		}
+	}
+}
+
+func (b *batcher) flush(batch []batchItem) {
+	defer func() {
+		for _, item := range batch {
+			close(item.done)
+		}
+	}()
+
+	msgs := make([]*model.QueueMessage, len(batch))
+	for i, item := range batch {
+		msgs[i] = item.msg
+	}
+
+	release, ok := b.w.acquire(b.reg.Type)
+	if !ok {
+		for _, msg := range msgs {
+			b.w.requeue(msg)
+		}
+		return
+	}
+	defer release()
+
+	log.Printf("Processing batch of %d %s messages", len(msgs), b.reg.Type)
+	errs := b.handle(msgs)
+	for i, msg := range msgs {
+		b.w.settle(msg, b.reg, errs[i])
+	}
+}
+
+// handle runs the batch handler under the registration's timeout and makes
+// sure there is one result per message.
+func (b *batcher) handle(msgs []*model.QueueMessage) []error {
+	ctx := b.w.ctx
+	if b.reg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.reg.Timeout)
+		defer cancel()
+	}
+
+	errs := b.reg.BatchHandler(ctx, msgs)
+	if len(errs) != len(msgs) {
+		err := fmt.Errorf("%s batch handler returned %d results for %d messages", b.reg.Type, len(errs), len(msgs))
+		errs = make([]error, len(msgs))
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
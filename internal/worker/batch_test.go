@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+)
+
+// batchRecorder is a batch handler that records the batches it is given
+// and fails the messages whose IDs are in fail.
+type batchRecorder struct {
+	fail map[string]bool
+
+	mu      sync.Mutex
+	batches [][]string
+	times   []time.Time
+}
+
+func (r *batchRecorder) handle(ctx context.Context, msgs []*model.QueueMessage) []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, len(msgs))
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+		if r.fail[msg.ID] {
+			errs[i] = errors.New("rejected by the bulk endpoint")
+		}
+	}
+	r.batches = append(r.batches, ids)
+	r.times = append(r.times, time.Now())
+	return errs
+}
+
+func (r *batchRecorder) recorded() ([][]string, []time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string(nil), r.batches...), append([]time.Time(nil), r.times...)
+}
+
+func startBatchWorker(t *testing.T, q queue.Queue, size int, window time.Duration, rec *batchRecorder) {
+	t.Helper()
+	reg := NewRegistry()
+	reg.Register(Registration{Type: testType, BatchHandler: rec.handle, BatchSize: size, BatchWindow: window})
+	startWorker(t, q, repository.NewMemoryRepository(), reg)
+}
+
+func TestBatchFlushedBySize(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	rec := &batchRecorder{}
+	startBatchWorker(t, q, 3, time.Hour, rec)
+
+	for range 3 {
+		enqueue(t, q, &model.QueueMessage{Type: testType})
+	}
+	waitFor(t, "the batch to complete", func() bool { return len(q.Completed()) == 3 })
+
+	batches, _ := rec.recorded()
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("batches = %v, want one batch of 3 without waiting for the window", batches)
+	}
+}
+
+func TestBatchFlushedByTime(t *testing.T) {
+	const window = 100 * time.Millisecond
+	q := queue.NewMemoryQueue()
+	rec := &batchRecorder{}
+	startBatchWorker(t, q, 10, window, rec)
+
+	start := time.Now()
+	enqueue(t, q, &model.QueueMessage{Type: testType})
+	enqueue(t, q, &model.QueueMessage{Type: testType})
+	waitFor(t, "the batch to complete", func() bool { return len(q.Completed()) == 2 })
+
+	batches, times := rec.recorded()
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("batches = %v, want one batch of 2", batches)
+	}
+	if waited := times[0].Sub(start); waited < window {
+		t.Fatalf("batch flushed after %s, before its %s window", waited, window)
+	}
+}
+
+func TestBatchRetriesOnlyFailedMessages(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	rec := &batchRecorder{fail: map[string]bool{"rejected": true}}
+	startBatchWorker(t, q, 3, time.Hour, rec)
+
+	enqueue(t, q, &model.QueueMessage{ID: "first", Type: testType, MaxAttempts: 5})
+	enqueue(t, q, &model.QueueMessage{ID: "rejected", Type: testType, MaxAttempts: 5})
+	enqueue(t, q, &model.QueueMessage{ID: "last", Type: testType, MaxAttempts: 5})
+	waitFor(t, "the batch to be settled", func() bool {
+		return len(q.Completed()) == 2 && len(q.Pending()) == 1
+	})
+
+	for _, msg := range q.Completed() {
+		if msg.ID == "rejected" {
+			t.Fatal("the failed message was completed")
+		}
+	}
+	if retry := q.Pending()[0]; retry.ID != "rejected" || !retry.ProcessAt.After(time.Now()) {
+		t.Fatalf("pending = %+v, want the failed message scheduled for a retry", retry)
+	}
+}
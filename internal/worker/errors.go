@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+)
+
+// Shortest time a message is postponed for, so a dependency that keeps
+// asking for retries "now" can't spin the worker.
+const minPostpone = 1 * time.Second
+
+// Permanent marks err as a failure that retrying cannot fix, such as a
+// malformed payload, so the worker dead-letters the message straight away.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string   { return e.err.Error() }
+func (e permanentError) Unwrap() error   { return e.err }
+func (e permanentError) Permanent() bool { return true }
+
+// isPermanent reports whether err or any error it wraps has a Permanent
+// method that returns true.
+func isPermanent(err error) bool {
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// postponement returns how long to put off a message whose handler failed
+// with an error that has a RetryAfter method, e.g. because a circuit breaker
+// is open. Such failures are not the message's fault and don't use up an
+// attempt.
+func postponement(err error) (time.Duration, bool) {
+	var r interface{ RetryAfter() time.Duration }
+	if !errors.As(err, &r) {
+		return 0, false
+	}
+	return max(r.RetryAfter(), minPostpone), true
+}
+
+// malformedError describes a queue.TypeMalformed message for its dead letter.
+func malformedError(msg *model.QueueMessage) error {
+	var p queue.MalformedPayload
+	if err := json.Unmarshal(msg.Payload, &p); err != nil {
+		return fmt.Errorf("malformed message: %w", err)
+	}
+	return fmt.Errorf("malformed message: %s", p.Error)
+}
@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// HandlerFunc processes one message. A nil error completes the message;
+// anything else schedules a retry or, once its attempts are used up,
+// dead-letters it. Two kinds of error are treated differently:
+//
+//   - errors with a Permanent() bool method that returns true, such as those
+//     made by Permanent, dead-letter the message straight away
+//   - errors with a RetryAfter() time.Duration method postpone the message by
+//     that long without using up an attempt
+type HandlerFunc func(ctx context.Context, msg *model.QueueMessage) error
+
+// BatchHandlerFunc processes a batch of messages of one type. It returns one
+// error per message, in the same order, each treated as a HandlerFunc error
+// for its own message, so a partly failed batch retries only the messages
+// that failed.
+type BatchHandlerFunc func(ctx context.Context, msgs []*model.QueueMessage) []error
+
+// Registration describes how the worker processes one message type. Exactly
+// one of Handler and BatchHandler must be set.
+type Registration struct {
+	Type         string
+	Handler      HandlerFunc
+	BatchHandler BatchHandlerFunc
+
+	// BatchSize and BatchWindow bound a batch for BatchHandler: it is handled
+	// once it has BatchSize messages or its first message has waited
+	// BatchWindow. Batches can't be larger than config.WorkerPrefetch.
+	BatchSize   int
+	BatchWindow time.Duration
+
+	// RetryPolicy, Concurrency and MaxAttempts are the defaults for the
+	// type; NewWorker applies the <TYPE>_RETRY_*, <TYPE>_CONCURRENCY and
+	// <TYPE>_MAX_RETRIES variables on top (see config.RetryPolicyFor).
+
+	// RetryPolicy spaces out attempts after a failure. The zero value uses
+	// config.DefaultRetryPolicy.
+	RetryPolicy config.RetryPolicy
+
+	// Timeout bounds each call to Handler or BatchHandler. Zero means no
+	// timeout beyond the worker's own shutdown.
+	Timeout time.Duration
+
+	// Concurrency limits how many messages (or batches) of this type are
+	// processed at once, within config.WorkerConcurrency. Zero means no limit
+	// of its own.
+	Concurrency int
+
+	// MaxAttempts is how many attempts a message of this type gets before
+	// it is dead-lettered. Zero uses the message's own MaxAttempts, or
+	// config.MaxRetries.
+	MaxAttempts int
+}
+
+// tuned returns reg with the operator's settings for its type applied.
+func (reg Registration) tuned(cfg *config.Config) Registration {
+	reg.RetryPolicy = cfg.RetryPolicyFor(reg.Type, reg.RetryPolicy)
+	reg.Concurrency = cfg.ConcurrencyFor(reg.Type, reg.Concurrency)
+	reg.MaxAttempts = cfg.MaxRetriesFor(reg.Type, reg.MaxAttempts)
+	return reg
+}
+
+// Registry collects the handlers the worker dispatches to by message type.
+// Packages that produce async jobs register their own handlers, so adding a
+// job type doesn't touch the worker. Everything must be registered before
+// the registry is passed to NewWorker.
+type Registry struct {
+	handlers map[string]Registration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]Registration)}
+}
+
+// Register adds the handler for a message type. Registering a type twice is
+// a programming error and panics.
+func (r *Registry) Register(reg Registration) {
+	if reg.Type == "" || (reg.Handler == nil) == (reg.BatchHandler == nil) {
+		panic("worker: registration needs a message type and either a handler or a batch handler")
+	}
+	if reg.BatchHandler != nil && (reg.BatchSize < 1 || reg.BatchWindow <= 0) {
+		panic(fmt.Sprintf("worker: batch handler for %s needs a batch size and window", reg.Type))
+	}
+	if _, dup := r.handlers[reg.Type]; dup {
+		panic(fmt.Sprintf("worker: handler for %s registered twice", reg.Type))
+	}
+	r.handlers[reg.Type] = reg
+}
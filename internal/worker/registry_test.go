@@ -0,0 +1,130 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+func TestDispatchByType(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	reg := NewRegistry()
+
+	var mu sync.Mutex
+	seen := make(map[string][]string) // handler -> message types it was given
+	for _, msgType := range []string{"first_type", "second_type"} {
+		reg.Register(Registration{Type: msgType, Handler: func(ctx context.Context, msg *model.QueueMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+			seen[msgType] = append(seen[msgType], msg.Type)
+			return nil
+		}})
+	}
+	startWorker(t, q, repository.NewMemoryRepository(), reg)
+
+	enqueue(t, q, &model.QueueMessage{Type: "first_type"})
+	enqueue(t, q, &model.QueueMessage{Type: "second_type"})
+	enqueue(t, q, &model.QueueMessage{Type: "second_type"})
+	waitFor(t, "all messages to complete", func() bool { return len(q.Completed()) == 3 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := seen["first_type"]; len(got) != 1 || got[0] != "first_type" {
+		t.Errorf("first_type handler got %v", got)
+	}
+	if got := seen["second_type"]; len(got) != 2 || got[0] != "second_type" || got[1] != "second_type" {
+		t.Errorf("second_type handler got %v", got)
+	}
+}
+
+func TestTypeConcurrencyLimit(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	reg := NewRegistry()
+
+	const limit = 2
+	var mu sync.Mutex
+	running, peak := 0, 0
+	release := make(chan struct{})
+	reg.Register(Registration{Type: "slow_type", Concurrency: limit, Handler: func(ctx context.Context, msg *model.QueueMessage) error {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}})
+	reg.Register(Registration{Type: "fast_type", Handler: func(ctx context.Context, msg *model.QueueMessage) error {
+		return nil
+	}})
+	startWorker(t, q, repository.NewMemoryRepository(), reg)
+
+	for range 5 {
+		enqueue(t, q, &model.QueueMessage{Type: "slow_type"})
+	}
+	waitFor(t, "the slow handlers to fill their slots", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running == limit
+	})
+
+	// The slow type is at its limit; other types must still be processed
+	enqueue(t, q, &model.QueueMessage{Type: "fast_type"})
+	waitFor(t, "the fast message to complete", func() bool { return len(q.Completed()) == 1 })
+
+	mu.Lock()
+	if peak != limit {
+		t.Errorf("%d slow messages ran at once, want %d", peak, limit)
+	}
+	mu.Unlock()
+
+	close(release)
+	waitFor(t, "every slow message to complete", func() bool { return len(q.Completed()) == 6 })
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != limit {
+		t.Errorf("%d slow messages ran at once, want %d", peak, limit)
+	}
+}
+
+func TestTypeSettingsFromEnvironment(t *testing.T) {
+	t.Setenv("TEST_MESSAGE_CONCURRENCY", "1")
+	t.Setenv("TEST_MESSAGE_MAX_RETRIES", "1")
+	t.Setenv("TEST_MESSAGE_RETRY_DELAY_SECONDS", "90")
+
+	q := queue.NewMemoryQueue()
+	repo := repository.NewMemoryRepository()
+	reg := NewRegistry()
+	reg.Register(Registration{Type: testType, Concurrency: 4, MaxAttempts: 5, Handler: func(ctx context.Context, msg *model.QueueMessage) error {
+		return errors.New("unavailable")
+	}})
+	w := startWorker(t, q, repo, reg)
+
+	got := w.handlers[testType]
+	if got.Concurrency != 1 || cap(w.typeSlots[testType]) != 1 {
+		t.Errorf("concurrency = %d (%d slots), want 1", got.Concurrency, cap(w.typeSlots[testType]))
+	}
+	if got.RetryPolicy.BaseDelay != 90*time.Second {
+		t.Errorf("retry delay = %s, want 90s", got.RetryPolicy.BaseDelay)
+	}
+	if defaults := config.Load().DefaultRetryPolicy; got.RetryPolicy.MaxDelay != defaults.MaxDelay {
+		t.Errorf("max delay = %s, want the default %s", got.RetryPolicy.MaxDelay, defaults.MaxDelay)
+	}
+
+	// TEST_MESSAGE_MAX_RETRIES overrides both the registration and the
+	// message's own limit
+	enqueue(t, q, &model.QueueMessage{Type: testType, MaxAttempts: 5})
+	waitFor(t, "the message to be dead-lettered", func() bool { return len(q.Failed()) == 1 })
+	if list := deadLetters(t, repo); len(list) != 1 || list[0].Message.Attempts != 1 {
+		t.Fatalf("dead letters = %+v, want one after a single attempt", list)
+	}
+}
@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+// retryDelay returns how long to wait before the next attempt of a message
+// that has failed attempts times, using exponential backoff with jitter.
+func retryDelay(policy config.RetryPolicy, attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(policy.BaseDelay) * math.Pow(multiplier, float64(attempts-1))
+	if policy.MaxDelay > 0 && delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+
+	// Spread retries of messages that failed together so they don't all hit
+	// the recovering dependency at the same moment
+	if policy.Jitter > 0 {
+		jitter := math.Min(policy.Jitter, 1)
+		delay += delay * jitter * (2*rand.Float64() - 1)
+	}
+	if policy.MaxDelay > 0 && delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+
+	return time.Duration(delay)
+}
@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := config.RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Multiplier: 2}
+
+	tests := []struct {
+		name     string
+		policy   config.RetryPolicy
+		attempts int
+		want     time.Duration
+	}{
+		{"first retry waits the base delay", policy, 1, time.Second},
+		{"attempts below one count as one", policy, 0, time.Second},
+		{"grows by the multiplier", policy, 4, 8 * time.Second},
+		{"capped at the maximum", policy, 10, time.Minute},
+		{"no maximum", config.RetryPolicy{BaseDelay: time.Second, Multiplier: 2}, 10, 512 * time.Second},
+		{"multiplier below one is constant", config.RetryPolicy{BaseDelay: time.Second, Multiplier: 0.5}, 5, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryDelay(tt.policy, tt.attempts); got != tt.want {
+				t.Fatalf("retryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   config.RetryPolicy
+		attempts int
+		min, max time.Duration
+	}{
+		{"spread around the delay", config.RetryPolicy{BaseDelay: 10 * time.Second, Multiplier: 1, Jitter: 0.2}, 1, 8 * time.Second, 12 * time.Second},
+		{"jitter above one is clamped", config.RetryPolicy{BaseDelay: 10 * time.Second, Multiplier: 1, Jitter: 5}, 1, 0, 20 * time.Second},
+		{"never above the maximum", config.RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Multiplier: 2, Jitter: 0.5}, 10, 30 * time.Second, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(map[time.Duration]bool)
+			for i := 0; i < 200; i++ {
+				got := retryDelay(tt.policy, tt.attempts)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("retryDelay = %s, want between %s and %s", got, tt.min, tt.max)
+				}
+				seen[got] = true
+			}
+			if len(seen) < 2 {
+				t.Fatal("jitter produced the same delay every time")
+			}
+		})
+	}
+}
+
+type retryAfterError time.Duration
+
+func (e retryAfterError) Error() string             { return "retry later" }
+func (e retryAfterError) RetryAfter() time.Duration { return time.Duration(e) }
+
+func TestFailureClassification(t *testing.T) {
+	plain := errors.New("boom")
+
+	if isPermanent(plain) {
+		t.Error("plain error classified as permanent")
+	}
+	if !isPermanent(fmt.Errorf("wrapped: %w", Permanent(plain))) {
+		t.Error("wrapped Permanent error not classified as permanent")
+	}
+	if _, ok := postponement(plain); ok {
+		t.Error("plain error postponed")
+	}
+	if d, ok := postponement(fmt.Errorf("wrapped: %w", retryAfterError(time.Minute))); !ok || d != time.Minute {
+		t.Errorf("postponement = %s, %v; want 1m, true", d, ok)
+	}
+	if d, _ := postponement(retryAfterError(0)); d != minPostpone {
+		t.Errorf("postponement of 0 = %s, want %s", d, minPostpone)
+	}
+}
@@ -1,123 +1,424 @@
 package worker
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/omaaartamer/factory-checkin-api/internal/email"
-	"github.com/omaaartamer/factory-checkin-api/internal/legacy"
 	"github.com/omaaartamer/factory-checkin-api/internal/model"
 	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
 	"github.com/omaaartamer/factory-checkin-api/pkg/config"
 )
 
+// How long the polling fallback waits before asking an empty queue again.
+const pollInterval = 1 * time.Second
+
+// How long a message waits before it is tried again when its dead letter
+// couldn't be stored.
+const deadLetterRetryDelay = 30 * time.Second
+
+// How long Shutdown waits for cancelled messages to be handed back once its
+// deadline has passed.
+const cancelGrace = 5 * time.Second
+
+// Worker processes queue messages on a bounded pool of goroutines. Messages
+// are pushed by the queue when it supports it (queue.Consumer) and polled
+// with Dequeue otherwise.
+//
+// Each message is handed to the handler registered for its type, or
+// collected into a batch for its type's batch handler. Three limits apply:
+// at most config.WorkerPrefetch messages are held at once, at most
+// config.WorkerConcurrency are processed at once, and each message type may
+// have its own lower limit so that slow legacy calls can never occupy every
+// processor and starve email delivery.
 type Worker struct {
-	queue     queue.Queue
-	emailSvc  *email.EmailService
-	legacyAPI *legacy.LegacyAPIClient
-	config    *config.Config
-	stopChan  chan bool
+	queue    queue.Queue
+	repo     repository.Repository
+	handlers map[string]Registration
+	batchers map[string]*batcher
+	config   *config.Config
+
+	held       chan struct{}            // one slot per message held by the worker
+	processors chan struct{}            // one slot per message being processed
+	typeSlots  map[string]chan struct{} // per message type processing limits
+	inFlight   sync.WaitGroup
+
+	// ctx is cancelled when a shutdown runs out of time, aborting the
+	// external calls of the messages still being processed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	stopping chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
 }
 
-func NewWorker(q queue.Queue, cfg *config.Config) *Worker {
-	return &Worker{
-		queue:     q,
-		emailSvc:  email.NewEmailService(cfg),
-		legacyAPI: legacy.NewLegacyAPIClient(cfg),
-		config:    cfg,
-		stopChan:  make(chan bool),
+func NewWorker(q queue.Queue, repo repository.Repository, handlers *Registry, cfg *config.Config) *Worker {
+	registrations := make(map[string]Registration, len(handlers.handlers))
+	typeSlots := make(map[string]chan struct{})
+	for msgType, reg := range handlers.handlers {
+		reg = reg.tuned(cfg)
+		registrations[msgType] = reg
+		if reg.Concurrency > 0 {
+			typeSlots[msgType] = make(chan struct{}, reg.Concurrency)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w := &Worker{
+		ctx:        ctx,
+		cancel:     cancel,
+		queue:      q,
+		repo:       repo,
+		handlers:   registrations,
+		config:     cfg,
+		held:       make(chan struct{}, max(cfg.WorkerPrefetch, 1)),
+		processors: make(chan struct{}, max(cfg.WorkerConcurrency, 1)),
+		typeSlots:  typeSlots,
+		stopping:   make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	w.batchers = make(map[string]*batcher)
+	for msgType, reg := range registrations {
+		if reg.BatchHandler != nil {
+			w.batchers[msgType] = newBatcher(w, reg)
+		}
 	}
+	return w
 }
 
 func (w *Worker) Start() {
-	log.Println("Background worker started - processing queue messages...")
+	types := make([]string, 0, len(w.handlers))
+	for msgType := range w.handlers {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	log.Printf("Background worker started - processing %s messages with %d processors...", strings.Join(types, ", "), cap(w.processors))
+
+	for _, b := range w.batchers {
+		go b.collect()
+	}
+	go w.dispatch(w.intake())
+}
+
+// Stop stops taking new messages and waits for the ones being processed to
+// finish. Messages received but not yet started are handed back to the queue.
+func (w *Worker) Stop() {
+	w.Shutdown(context.Background())
+}
+
+// Shutdown is Stop with a deadline. If ctx expires first, the messages still
+// being processed are cancelled and handed back to the queue, and Shutdown
+// returns ctx.Err(). Messages that can't be handed back in time are left
+// unacknowledged; the queue redelivers them once it is closed.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.stopOnce.Do(func() {
+		close(w.stopping)
+		if consumer, ok := w.queue.(queue.Consumer); ok {
+			if err := consumer.StopConsuming(); err != nil {
+				log.Printf("Failed to stop consumer: %v", err)
+			}
+		}
+	})
+
+	select {
+	case <-w.done:
+		log.Println("Background worker stopped")
+		return nil
+	case <-ctx.Done():
+	}
+
+	log.Printf("Background worker cancelling in-flight messages: %v", ctx.Err())
+	w.cancel()
+	select {
+	case <-w.done:
+	case <-time.After(cancelGrace):
+		log.Println("Background worker stopped with messages still in flight")
+	}
+	return ctx.Err()
+}
+
+// intake returns the stream of messages to process, preferring push
+// delivery over polling.
+func (w *Worker) intake() <-chan *model.QueueMessage {
+	if consumer, ok := w.queue.(queue.Consumer); ok {
+		messages, err := consumer.Consume(cap(w.held))
+		if err == nil {
+			return messages
+		}
+		log.Printf("Failed to start consumer, falling back to polling: %v", err)
+	}
+	return w.poll()
+}
+
+func (w *Worker) poll() <-chan *model.QueueMessage {
+	messages := make(chan *model.QueueMessage)
 
 	go func() {
+		defer close(messages)
 		for {
+			ctx, cancel := w.queueContext()
+			msg, err := w.queue.Dequeue(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Error dequeuing message: %v", err)
+			}
+
+			if msg == nil {
+				// No messages available (or the queue failed) - check again shortly
+				select {
+				case <-w.stopping:
+					return
+				case <-time.After(pollInterval):
+				}
+				continue
+			}
+
 			select {
-			case <-w.stopChan:
-				log.Println("Background worker stopped")
+			case messages <- msg:
+			case <-w.stopping:
+				w.requeue(msg)
 				return
-			default:
-				w.processMessages()
-				time.Sleep(1 * time.Second) // Check for messages every second
 			}
 		}
 	}()
+
+	return messages
 }
 
-func (w *Worker) Stop() {
-	w.stopChan <- true
+// dispatch hands each message to its own goroutine once a held slot is free.
+// It returns after the intake channel closes and all processing is done.
+func (w *Worker) dispatch(messages <-chan *model.QueueMessage) {
+	defer close(w.done)
+
+	for msg := range messages {
+		select {
+		case <-w.stopping:
+			w.requeue(msg)
+			continue
+		default:
+		}
+
+		select {
+		case w.held <- struct{}{}:
+		case <-w.stopping:
+			w.requeue(msg)
+			continue
+		}
+
+		w.inFlight.Add(1)
+		go w.run(msg)
+	}
+
+	w.inFlight.Wait()
 }
 
-func (w *Worker) processMessages() {
-	msg, err := w.queue.Dequeue()
-	if err != nil {
-		log.Printf("Error dequeuing message: %v", err)
+func (w *Worker) run(msg *model.QueueMessage) {
+	defer w.inFlight.Done()
+	defer func() { <-w.held }()
+
+	if msg.DeadLetterReason != "" {
+		ctx, cancel := w.queueContext()
+		defer cancel()
+		w.deadLetter(ctx, msg, msg.DeadLetterReason)
 		return
 	}
 
-	if msg == nil {
-		return // No messages available
+	if b, ok := w.batchers[msg.Type]; ok {
+		b.add(msg)
+		return
 	}
 
+	release, ok := w.acquire(msg.Type)
+	if !ok {
+		w.requeue(msg)
+		return
+	}
+	defer release()
+
+	w.processMessage(msg)
+}
+
+// acquire takes a slot for the message type and then a processor, returning
+// a function that gives both back. Taking the type slot first means messages
+// waiting on a busy type don't hold processors other types could use. It
+// fails if the worker stops while waiting; the caller hands the messages
+// back to the queue.
+func (w *Worker) acquire(msgType string) (release func(), ok bool) {
+	slots, limited := w.typeSlots[msgType]
+	if limited {
+		select {
+		case slots <- struct{}{}:
+		case <-w.stopping:
+			return nil, false
+		}
+	}
+
+	select {
+	case w.processors <- struct{}{}:
+	case <-w.stopping:
+		if limited {
+			<-slots
+		}
+		return nil, false
+	}
+
+	return func() {
+		<-w.processors
+		if limited {
+			<-slots
+		}
+	}, true
+}
+
+func (w *Worker) requeue(msg *model.QueueMessage) {
+	ctx, cancel := w.queueContext()
+	defer cancel()
+
+	if err := w.queue.Requeue(ctx, msg); err != nil {
+		log.Printf("Failed to requeue message %s: %v", msg.ID, err)
+	}
+}
+
+func (w *Worker) processMessage(msg *model.QueueMessage) {
 	log.Printf("Processing message: %s (Type: %s, Attempt: %d)", msg.ID, msg.Type, msg.Attempts)
 
+	reg, ok := w.handlers[msg.Type]
 	var processingErr error
-
-	switch msg.Type {
-	case "labor_cost_report":
-		processingErr = w.processLaborCostReport(msg)
-	case "email_notification":
-		processingErr = w.processEmailNotification(msg)
+	switch {
+	case msg.Type == queue.TypeMalformed:
+		processingErr = Permanent(malformedError(msg))
+	case ok:
+		processingErr = w.handle(reg, msg)
 	default:
 		processingErr = fmt.Errorf("unknown message type: %s", msg.Type)
 	}
 
+	w.settle(msg, reg, processingErr)
+}
+
+// settle acknowledges a processed message, or retries or dead-letters it if
+// processing failed.
+func (w *Worker) settle(msg *model.QueueMessage, reg Registration, processingErr error) {
+	if processingErr != nil && w.ctx.Err() != nil {
+		// Cancelled by shutdown - not the message's fault
+		log.Printf("Processing of message %s interrupted by shutdown: %v", msg.ID, processingErr)
+		w.requeue(msg)
+		return
+	}
+
+	ctx, cancel := w.queueContext()
+	defer cancel()
+
 	if processingErr != nil {
 		log.Printf("Failed to process message %s: %v", msg.ID, processingErr)
-		w.queue.MarkFailed(msg.ID)
+		w.handleFailure(ctx, msg, reg, processingErr)
 	} else {
 		log.Printf("Successfully processed message %s", msg.ID)
-		w.queue.MarkCompleted(msg.ID)
+		if err := w.queue.MarkCompleted(ctx, msg); err != nil {
+			log.Printf("Failed to acknowledge message %s: %v", msg.ID, err)
+		}
 	}
 }
 
-func (w *Worker) processLaborCostReport(msg *model.QueueMessage) error {
-	employeeID, ok := msg.Payload["employee_id"].(string)
-	if !ok {
-		return fmt.Errorf("invalid employee_id in payload")
+// handle runs the registered handler under its timeout. The handler's
+// context is also cancelled if a shutdown runs out of time.
+func (w *Worker) handle(reg Registration, msg *model.QueueMessage) error {
+	ctx := w.ctx
+	if reg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, reg.Timeout)
+		defer cancel()
 	}
+	return reg.Handler(ctx, msg)
+}
 
-	hoursWorked, ok := msg.Payload["hours_worked"].(float64)
-	if !ok {
-		return fmt.Errorf("invalid hours_worked in payload")
+// handleFailure schedules another attempt with exponential backoff, or
+// dead-letters the message once it has used all of its attempts.
+func (w *Worker) handleFailure(ctx context.Context, msg *model.QueueMessage, reg Registration, cause error) {
+	if delay, ok := postponement(cause); ok {
+		w.postpone(ctx, msg, delay)
+		return
+	}
+	if isPermanent(cause) {
+		log.Printf("Message %s failed permanently, giving up", msg.ID)
+		w.deadLetter(ctx, msg, cause.Error())
+		return
 	}
 
-	date, ok := msg.Payload["date"].(string)
-	if !ok {
-		return fmt.Errorf("invalid date in payload")
+	maxAttempts := reg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = msg.MaxAttempts
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = w.config.MaxRetries
+	}
+
+	if msg.Attempts >= maxAttempts {
+		log.Printf("Message %s exhausted %d attempts, giving up", msg.ID, maxAttempts)
+		w.deadLetter(ctx, msg, cause.Error())
+		return
 	}
 
-	return w.legacyAPI.ReportHours(employeeID, hoursWorked, date)
+	policy := reg.RetryPolicy
+	if policy == (config.RetryPolicy{}) {
+		policy = w.config.DefaultRetryPolicy
+	}
+	delay := retryDelay(policy, msg.Attempts)
+	msg.ProcessAt = time.Now().Add(delay)
+	if err := w.queue.Retry(ctx, msg); err != nil {
+		log.Printf("Failed to schedule retry for message %s: %v", msg.ID, err)
+		return
+	}
+	log.Printf("Message %s will be retried in %s (attempt %d of %d)", msg.ID, delay.Round(time.Second), msg.Attempts+1, maxAttempts)
 }
 
-func (w *Worker) processEmailNotification(msg *model.QueueMessage) error {
-	employeeID, ok := msg.Payload["employee_id"].(string)
-	if !ok {
-		return fmt.Errorf("invalid employee_id in payload")
+// postpone schedules the message again after delay, giving back the attempt
+// that was just used.
+func (w *Worker) postpone(ctx context.Context, msg *model.QueueMessage, delay time.Duration) {
+	msg.Attempts--
+	msg.ProcessAt = time.Now().Add(delay)
+	if err := w.queue.Retry(ctx, msg); err != nil {
+		log.Printf("Failed to postpone message %s: %v", msg.ID, err)
+		return
 	}
+	log.Printf("Message %s postponed for %s", msg.ID, delay.Round(time.Second))
+}
 
-	hoursWorked, ok := msg.Payload["hours_worked"].(float64)
-	if !ok {
-		return fmt.Errorf("invalid hours_worked in payload")
+// deadLetter records the failure in the dead-letter store for inspection and
+// replay, and hands the message to the queue's own dead-letter queue. If the
+// dead letter can't be stored, the message stays on the queue marked with the
+// reason and comes back after deadLetterRetryDelay, so it is never dropped
+// without a record. Its handler doesn't run again meanwhile: only storing the
+// dead letter is retried.
+func (w *Worker) deadLetter(ctx context.Context, msg *model.QueueMessage, reason string) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), w.config.Timeouts.Database)
+	defer cancel()
+
+	deadLetter := &model.DeadLetter{Message: *msg, Reason: reason}
+	deadLetter.Message.Status = "failed"
+	deadLetter.Message.DeadLetterReason = ""
+	if err := w.repo.CreateDeadLetter(dbCtx, deadLetter); err != nil {
+		log.Printf("Failed to store dead letter for message %s: %v", msg.ID, err)
+		msg.DeadLetterReason = reason
+		w.postpone(ctx, msg, deadLetterRetryDelay)
+		return
 	}
 
-	date, ok := msg.Payload["date"].(string)
-	if !ok {
-		return fmt.Errorf("invalid date in payload")
+	if err := w.queue.MarkFailed(ctx, msg, reason); err != nil {
+		log.Printf("Failed to mark message %s as failed: %v", msg.ID, err)
 	}
+}
 
-	return w.emailSvc.SendWorkedHoursEmail(employeeID, hoursWorked, date)
+// queueContext bounds one queue operation. It is not derived from w.ctx:
+// messages must still be settled after in-flight work has been cancelled.
+func (w *Worker) queueContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), w.config.Timeouts.Queue)
 }
@@ -0,0 +1,157 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/omaaartamer/factory-checkin-api/internal/model"
+	"github.com/omaaartamer/factory-checkin-api/internal/queue"
+	"github.com/omaaartamer/factory-checkin-api/internal/repository"
+	"github.com/omaaartamer/factory-checkin-api/pkg/config"
+)
+
+const testType = "test_message"
+
+// startWorker runs a worker over q and repo until the test ends.
+func startWorker(t *testing.T, q queue.Queue, repo repository.Repository, reg *Registry) *Worker {
+	t.Helper()
+	cfg := config.Load()
+	w := NewWorker(q, repo, reg, cfg)
+	w.Start()
+	t.Cleanup(w.Stop)
+	return w
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func enqueue(t *testing.T, q queue.Queue, msg *model.QueueMessage) {
+	t.Helper()
+	if msg.ID == "" {
+		msg.ID = queue.NewMessageID()
+	}
+	if msg.ProcessAt.IsZero() {
+		msg.ProcessAt = time.Now()
+	}
+	if err := q.Enqueue(context.Background(), msg); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+}
+
+func deadLetters(t *testing.T, repo repository.Repository) []model.DeadLetter {
+	t.Helper()
+	list, _, err := repo.ListDeadLetters(context.Background(), repository.DeadLetterFilter{Limit: 100})
+	if err != nil {
+		t.Fatalf("ListDeadLetters: %v", err)
+	}
+	return list
+}
+
+func TestPermanentFailureIsDeadLettered(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	repo := repository.NewMemoryRepository()
+	reg := NewRegistry()
+	reg.Register(Registration{Type: testType, Handler: func(ctx context.Context, msg *model.QueueMessage) error {
+		return Permanent(errors.New("rejected"))
+	}})
+	startWorker(t, q, repo, reg)
+
+	enqueue(t, q, &model.QueueMessage{Type: testType, MaxAttempts: 5})
+	waitFor(t, "the queue to dead-letter the message", func() bool { return len(q.Failed()) == 1 })
+
+	list := deadLetters(t, repo)
+	if len(list) != 1 || list[0].Reason != "rejected" || list[0].Message.Type != testType {
+		t.Fatalf("dead letters = %+v, want the rejected message", list)
+	}
+}
+
+// failingDeadLetters is a repository that can't store dead letters.
+type failingDeadLetters struct {
+	*repository.MemoryRepository
+}
+
+func (failingDeadLetters) CreateDeadLetter(ctx context.Context, deadLetter *model.DeadLetter) error {
+	return errors.New("database unavailable")
+}
+
+func TestDeadLetterStoreFailureKeepsMessage(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	reg := NewRegistry()
+	reg.Register(Registration{Type: testType, Handler: func(ctx context.Context, msg *model.QueueMessage) error {
+		return Permanent(errors.New("rejected"))
+	}})
+	startWorker(t, q, failingDeadLetters{repository.NewMemoryRepository()}, reg)
+
+	enqueue(t, q, &model.QueueMessage{Type: testType, MaxAttempts: 5})
+	waitFor(t, "the message to be postponed", func() bool {
+		pending := q.Pending()
+		return len(pending) == 1 && pending[0].ProcessAt.After(time.Now())
+	})
+
+	if failed := q.Failed(); len(failed) != 0 {
+		t.Fatalf("message left the queue without a dead letter: %+v", failed)
+	}
+	pending := q.Pending()[0]
+	if wait := time.Until(pending.ProcessAt); wait < deadLetterRetryDelay/2 {
+		t.Fatalf("message comes back in %s, want about %s", wait, deadLetterRetryDelay)
+	}
+	if pending.Attempts != 0 {
+		t.Fatalf("postponed message used up an attempt: %d", pending.Attempts)
+	}
+	if pending.DeadLetterReason != "rejected" {
+		t.Fatalf("postponed message carries dead-letter reason %q, want %q", pending.DeadLetterReason, "rejected")
+	}
+}
+
+func TestDeadLetterRetryDoesNotRunHandler(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	repo := repository.NewMemoryRepository()
+	reg := NewRegistry()
+	var calls atomic.Int32
+	reg.Register(Registration{Type: testType, Handler: func(ctx context.Context, msg *model.QueueMessage) error {
+		calls.Add(1)
+		return nil
+	}})
+	startWorker(t, q, repo, reg)
+
+	// As postponed by a worker that couldn't store the dead letter
+	enqueue(t, q, &model.QueueMessage{Type: testType, MaxAttempts: 5, DeadLetterReason: "rejected"})
+	waitFor(t, "the message to be dead-lettered", func() bool { return len(q.Failed()) == 1 })
+
+	if n := calls.Load(); n != 0 {
+		t.Fatalf("handler ran %d times for a message already given up on", n)
+	}
+	list := deadLetters(t, repo)
+	if len(list) != 1 || list[0].Reason != "rejected" || list[0].Message.DeadLetterReason != "" {
+		t.Fatalf("dead letters = %+v, want one with the original reason", list)
+	}
+}
+
+func TestMalformedMessageIsDeadLettered(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	repo := repository.NewMemoryRepository()
+	startWorker(t, q, repo, NewRegistry())
+
+	payload, _ := json.Marshal(queue.MalformedPayload{Body: "{not json", Error: "invalid character 'n'"})
+	enqueue(t, q, &model.QueueMessage{Type: queue.TypeMalformed, Payload: payload, MaxAttempts: 1})
+	waitFor(t, "the malformed message to be dead-lettered", func() bool { return len(q.Failed()) == 1 })
+
+	list := deadLetters(t, repo)
+	if len(list) != 1 || list[0].Message.Type != queue.TypeMalformed || !strings.Contains(list[0].Reason, "invalid character") {
+		t.Fatalf("dead letters = %+v, want the malformed message", list)
+	}
+}
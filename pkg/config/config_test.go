@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTypeSettings(t *testing.T) {
+	t.Setenv("RETRY_DELAY_SECONDS", "10")
+	t.Setenv("PAYROLL_EXPORT_RETRY_DELAY_SECONDS", "45")
+	t.Setenv("PAYROLL_EXPORT_RETRY_JITTER", "0")
+	t.Setenv("PAYROLL_EXPORT_CONCURRENCY", "3")
+	t.Setenv("PAYROLL_EXPORT_MAX_RETRIES", "7")
+	cfg := Load()
+
+	custom := RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour, Multiplier: 3, Jitter: 0.5}
+	tests := []struct {
+		name     string
+		msgType  string
+		defaults RetryPolicy
+		want     RetryPolicy
+	}{
+		{"unset type uses the default policy", "unknown_type", RetryPolicy{}, cfg.DefaultRetryPolicy},
+		{"unset type keeps its own defaults", "unknown_type", custom, custom},
+		{"variables apply to any type", "payroll_export", RetryPolicy{},
+			RetryPolicy{BaseDelay: 45 * time.Second, MaxDelay: cfg.DefaultRetryPolicy.MaxDelay, Multiplier: cfg.DefaultRetryPolicy.Multiplier}},
+		{"variables override the type's defaults", "payroll_export", custom,
+			RetryPolicy{BaseDelay: 45 * time.Second, MaxDelay: time.Hour, Multiplier: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.RetryPolicyFor(tt.msgType, tt.defaults); got != tt.want {
+				t.Errorf("RetryPolicyFor(%q) = %+v, want %+v", tt.msgType, got, tt.want)
+			}
+		})
+	}
+
+	if cfg.DefaultRetryPolicy.BaseDelay != 10*time.Second {
+		t.Errorf("default retry delay = %s, want 10s", cfg.DefaultRetryPolicy.BaseDelay)
+	}
+	if got := cfg.ConcurrencyFor("payroll_export", 1); got != 3 {
+		t.Errorf("ConcurrencyFor(payroll_export) = %d, want 3", got)
+	}
+	if got := cfg.ConcurrencyFor("unknown_type", 4); got != 4 {
+		t.Errorf("ConcurrencyFor(unknown_type) = %d, want the default 4", got)
+	}
+	if got := cfg.MaxRetriesFor("payroll_export", 5); got != 7 {
+		t.Errorf("MaxRetriesFor(payroll_export) = %d, want 7", got)
+	}
+	if got := cfg.MaxRetriesFor("unknown_type", 0); got != 0 {
+		t.Errorf("MaxRetriesFor(unknown_type) = %d, want 0", got)
+	}
+}